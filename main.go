@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
@@ -18,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
 )
 
 var configFilePath string
@@ -60,16 +62,40 @@ func main() {
 		Client: struct {
 			*secretsmanager.SecretsManager
 			*cloudformation.CloudFormation
+			sqs.MessageMoveClient
 		}{
-			SecretsManager: secretsmanager.New(sess, cfg),
-			CloudFormation: cloudformation.New(sess, cfg),
+			SecretsManager:    secretsmanager.New(sess, cfg),
+			CloudFormation:    cloudformation.New(sess, cfg),
+			MessageMoveClient: sqs.MessageMoveClient{SQS: awssqs.New(sess, cfg)},
 		},
-		Environment:          sqsClientConfig.DeployEnvironment,
-		ResourcePrefix:       sqsClientConfig.ResourcePrefix,
-		AdditionalUserPolicy: sqsClientConfig.AdditionalUserPolicy,
-		PermissionsBoundary:  sqsClientConfig.PermissionsBoundary,
-		Timeout:              sqsClientConfig.Timeout,
-		Logger:               logger,
+		Environment:           sqsClientConfig.DeployEnvironment,
+		ResourcePrefix:        sqsClientConfig.ResourcePrefix,
+		AdditionalUserPolicy:  sqsClientConfig.AdditionalUserPolicy,
+		PermissionsBoundary:   sqsClientConfig.PermissionsBoundary,
+		AllowedKMSKeyARNs:     sqsClientConfig.AllowedKMSKeyARNs,
+		AllowedSenderAccounts: sqsClientConfig.AllowedSenderAccounts,
+		DenyInsecureTransport: sqsClientConfig.DenyInsecureTransport,
+		RestrictPrincipals:    sqsClientConfig.RestrictPrincipals,
+		BrokerPrincipalARN:    sqsClientConfig.BrokerPrincipalARN,
+		Tags:                  sqsClientConfig.Tags,
+		RoleTrustPrincipalARN: sqsClientConfig.RoleTrustPrincipalARN,
+		AccessPolicies:        sqsClientConfig.AccessPolicies,
+		Plans:                 sqsClientConfig.Plans,
+		Catalog:               config.Catalog.Catalog.Services,
+		Timeout:               sqsClientConfig.Timeout,
+		AccessKeyMaxAge:       sqsClientConfig.AccessKeyMaxAge(),
+		AccessKeyGracePeriod:  sqsClientConfig.AccessKeyGracePeriod(),
+		Logger:                logger,
+	}
+
+	if sqsProvider.AccessKeyMaxAge > 0 {
+		go sqsProvider.RunAccessKeyRotation(context.Background(), sqs.AccessKeyRotationInterval)
+	}
+
+	for i, service := range config.Catalog.Catalog.Services {
+		for j, plan := range service.Plans {
+			config.Catalog.Catalog.Services[i].Plans[j].Schemas = sqsProvider.Schemas(plan)
+		}
 	}
 
 	serviceBroker, err := broker.New(config, sqsProvider, logger)
@@ -77,7 +103,7 @@ func main() {
 		log.Fatalf("Error creating service broker: %s", err)
 	}
 
-	brokerAPI := broker.NewAPI(serviceBroker, logger, config)
+	brokerAPI := sqs.LastOperationRecovery(sqs.BindingRotation(broker.NewAPI(sqs.NewBroker(serviceBroker, sqsProvider), logger, config), sqsProvider))
 
 	listenAddress := fmt.Sprintf("%s:%s", config.API.Host, config.API.Port)
 	listener, err := net.Listen("tcp", listenAddress)
@@ -0,0 +1,222 @@
+package sqs
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+const (
+	AccessPolicyQueuesPrimary   = "primary"
+	AccessPolicyQueuesSecondary = "secondary"
+	AccessPolicyQueuesBoth      = "both"
+)
+
+// AccessPolicyConfig declares what bindings with an access policy may
+// do. Access policies are configured in the broker config under
+// "access_policies", keyed by name, and add to or replace the
+// DefaultAccessPolicies.
+type AccessPolicyConfig struct {
+	// Actions are granted on the instance's queues. Actions prefixed
+	// "sns:" are granted on its topic and subscriptions instead, when
+	// the instance has a topic.
+	Actions []string `json:"actions"`
+	// Queues is the queues the actions are granted on: "primary" for
+	// the primary and named queues, "secondary" for the dead letter
+	// queue, or "both", the default.
+	Queues string `json:"queues,omitempty"`
+	// Statements are added to the binding's policy as they are, for
+	// access to other services such as CloudWatch.
+	Statements []PolicyStatement `json:"statements,omitempty"`
+}
+
+// PolicyStatement is an extra statement in the policy of bindings with an
+// access policy.
+type PolicyStatement struct {
+	// Effect is "Allow", the default, or "Deny".
+	Effect  string   `json:"effect,omitempty"`
+	Actions []string `json:"actions"`
+	// Resources defaults to every resource.
+	Resources []string `json:"resources,omitempty"`
+}
+
+// DefaultAccessPolicies are the access policies bindings may use unless
+// the broker config replaces them.
+var DefaultAccessPolicies = map[string]AccessPolicyConfig{
+	AccessPolicyFull: {
+		Actions: []string{
+			"sqs:ChangeMessageVisibility",
+			"sqs:DeleteMessage",
+			"sqs:GetQueueAttributes",
+			"sqs:GetQueueUrl",
+			"sqs:ListDeadLetterSourceQueues",
+			"sqs:ListQueueTags",
+			"sqs:PurgeQueue",
+			"sqs:ReceiveMessage",
+			"sqs:SendMessage",
+			"sns:GetSubscriptionAttributes",
+			"sns:GetTopicAttributes",
+			"sns:ListSubscriptionsByTopic",
+			"sns:Publish",
+			"sns:SetSubscriptionAttributes",
+			"sns:Subscribe",
+			"sns:Unsubscribe",
+		},
+	},
+	AccessPolicyProducer: {
+		Actions: []string{
+			"sqs:GetQueueAttributes",
+			"sqs:GetQueueUrl",
+			"sqs:ListDeadLetterSourceQueues",
+			"sqs:ListQueueTags",
+			"sqs:SendMessage",
+			"sns:GetTopicAttributes",
+			"sns:Publish",
+		},
+	},
+	AccessPolicyConsumer: {
+		Actions: []string{
+			"sqs:ChangeMessageVisibility",
+			"sqs:DeleteMessage",
+			"sqs:GetQueueAttributes",
+			"sqs:GetQueueUrl",
+			"sqs:ListDeadLetterSourceQueues",
+			"sqs:ListQueueTags",
+			"sqs:PurgeQueue",
+			"sqs:ReceiveMessage",
+			"sns:GetSubscriptionAttributes",
+			"sns:GetTopicAttributes",
+			"sns:ListSubscriptionsByTopic",
+			"sns:SetSubscriptionAttributes",
+		},
+	},
+	AccessPolicyMonitor: {
+		Actions: []string{
+			"sqs:GetQueueAttributes",
+			"sqs:GetQueueUrl",
+			"sqs:ListDeadLetterSourceQueues",
+			"sqs:ListQueueTags",
+			"sns:GetTopicAttributes",
+			"sns:ListSubscriptionsByTopic",
+		},
+		Statements: []PolicyStatement{{
+			Actions: []string{
+				"cloudwatch:GetMetricData",
+				"cloudwatch:GetMetricStatistics",
+				"cloudwatch:ListMetrics",
+			},
+		}},
+	},
+}
+
+var (
+	accessPolicyName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	queueAction      = regexp.MustCompile(`^(sqs|sns):[a-zA-Z*]+$`)
+	policyAction     = regexp.MustCompile(`^[a-z0-9-]+:[a-zA-Z0-9*]+$`)
+)
+
+// Validate checks the access policy is usable.
+func (policy AccessPolicyConfig) Validate() error {
+	if len(policy.Actions) == 0 {
+		return fmt.Errorf("actions: at least one action is required")
+	}
+	queueActions := 0
+	for _, action := range policy.Actions {
+		if !queueAction.MatchString(action) {
+			return fmt.Errorf("actions: %q must be an sqs: or sns: action", action)
+		}
+		if strings.HasPrefix(action, "sqs:") {
+			queueActions++
+		}
+	}
+	if queueActions == 0 {
+		return fmt.Errorf("actions: at least one sqs: action is required")
+	}
+	switch policy.Queues {
+	case "", AccessPolicyQueuesPrimary, AccessPolicyQueuesSecondary, AccessPolicyQueuesBoth:
+	default:
+		return fmt.Errorf("queues must be %q, %q or %q", AccessPolicyQueuesPrimary, AccessPolicyQueuesSecondary, AccessPolicyQueuesBoth)
+	}
+	for i, statement := range policy.Statements {
+		switch statement.Effect {
+		case "", "Allow", "Deny":
+		default:
+			return fmt.Errorf("statements[%d].effect must be \"Allow\" or \"Deny\"", i)
+		}
+		if len(statement.Actions) == 0 {
+			return fmt.Errorf("statements[%d].actions: at least one action is required", i)
+		}
+		for _, action := range statement.Actions {
+			if !policyAction.MatchString(action) {
+				return fmt.Errorf("statements[%d].actions: %q is not an IAM action", i, action)
+			}
+		}
+		for _, resource := range statement.Resources {
+			if resource == "" || strings.ContainsAny(resource, "\"\\\n") {
+				return fmt.Errorf("statements[%d].resources: %q is not a resource ARN", i, resource)
+			}
+		}
+	}
+	return nil
+}
+
+// StatementEffect returns the effect of an extra statement.
+func (statement PolicyStatement) StatementEffect() string {
+	if statement.Effect == "" {
+		return "Allow"
+	}
+	return statement.Effect
+}
+
+// StatementResources returns the resources of an extra statement.
+func (statement PolicyStatement) StatementResources() []string {
+	if len(statement.Resources) == 0 {
+		return []string{"*"}
+	}
+	return statement.Resources
+}
+
+// ValidateAccessPolicies checks the access policies in the broker config.
+func ValidateAccessPolicies(policies map[string]AccessPolicyConfig) error {
+	for _, name := range sortedKeys(policies) {
+		if !accessPolicyName.MatchString(name) {
+			return fmt.Errorf("access_policies: %q must be at most 64 letters, numbers, hyphens or underscores", name)
+		}
+		if err := policies[name].Validate(); err != nil {
+			return fmt.Errorf("access_policies.%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// withDefaultAccessPolicies returns the default access policies with the
+// given ones added, or replacing those of the same name.
+func withDefaultAccessPolicies(policies map[string]AccessPolicyConfig) map[string]AccessPolicyConfig {
+	merged := map[string]AccessPolicyConfig{}
+	for name, policy := range DefaultAccessPolicies {
+		merged[name] = policy
+	}
+	for name, policy := range policies {
+		merged[name] = policy
+	}
+	return merged
+}
+
+// accessPolicies returns the access policies bindings may request.
+func (s *Provider) accessPolicies() map[string]AccessPolicyConfig {
+	if s.AccessPolicies == nil {
+		return DefaultAccessPolicies
+	}
+	return s.AccessPolicies
+}
+
+func unknownAccessPolicy(policy AccessPolicy) error {
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("unknown access policy %#v", policy),
+		http.StatusBadRequest,
+		"unknown-access-policy",
+	)
+}
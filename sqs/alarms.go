@@ -0,0 +1,119 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+const (
+	ParamAlarms = "Alarms"
+)
+
+const (
+	ResourceAlarmTopic = "AlarmTopic"
+)
+
+const (
+	AlarmProtocolEmail = "email"
+	AlarmProtocolHTTPS = "https"
+)
+
+// Alarms configures CloudWatch alarms on an instance's queues. Each alarm
+// notifies the endpoints when it goes off and again when it clears.
+type Alarms struct {
+	// DLQDepth alarms when any message is moved to the secondary queue.
+	// It is on by default when any endpoints are given.
+	DLQDepth *bool `json:"dlq_depth,omitempty" dlq:"true"`
+	// OldestMessageAge alarms when the oldest message in the primary
+	// queue, or a named queue, has been waiting this many seconds.
+	OldestMessageAge *int `json:"oldest_message_age,omitempty" minimum:"60" maximum:"1209600"`
+	// QueueDepth alarms when this many messages are waiting in the
+	// primary queue, or a named queue.
+	QueueDepth *int `json:"queue_depth,omitempty" minimum:"1" maximum:"1000000000"`
+	// Endpoints receive the alarm notifications.
+	Endpoints []AlarmEndpoint `json:"endpoints,omitempty" maxItems:"10"`
+}
+
+// AlarmEndpoint is an email address or HTTPS URL that is subscribed to
+// the alarm topic.
+type AlarmEndpoint struct {
+	Protocol string `json:"protocol" required:"true" enum:"email,https"`
+	Endpoint string `json:"endpoint" required:"true"`
+}
+
+// IsEmpty returns true if no alarms are configured, which is how they
+// are removed on update.
+func (alarms *Alarms) IsEmpty() bool {
+	return alarms == nil || (alarms.DLQDepth == nil && alarms.OldestMessageAge == nil && alarms.QueueDepth == nil && len(alarms.Endpoints) == 0)
+}
+
+// HasDLQDepth returns true if the secondary queue is alarmed.
+func (alarms *Alarms) HasDLQDepth() bool {
+	return alarms.DLQDepth == nil || *alarms.DLQDepth
+}
+
+// AlarmTopicName builds the name for the topic that alarms notify.
+func (params *QueueTemplateBuilder) AlarmTopicName() string {
+	return params.QueueName + "-alarms"
+}
+
+// AlarmsEnabled returns true if the template should create alarms.
+func (params *QueueTemplateBuilder) AlarmsEnabled() bool {
+	return !params.Broadcast && !params.Alarms.IsEmpty()
+}
+
+// validateAlarms checks that alarms have somewhere to send their
+// notifications, and that the endpoints are usable.
+func validateAlarms(alarms *Alarms) error {
+	if alarms.IsEmpty() {
+		return nil
+	}
+	if len(alarms.Endpoints) == 0 {
+		return invalidParams(fmt.Errorf("alarms.endpoints: at least one endpoint is required"))
+	}
+	for i, endpoint := range alarms.Endpoints {
+		path := fmt.Sprintf("alarms.endpoints[%d].endpoint", i)
+		if err := validateTemplateString(path, endpoint.Endpoint); err != nil {
+			return err
+		}
+		switch endpoint.Protocol {
+		case AlarmProtocolEmail:
+			address, err := mail.ParseAddress(endpoint.Endpoint)
+			if err != nil || address.Address != endpoint.Endpoint {
+				return invalidParams(fmt.Errorf("%s: must be an email address", path))
+			}
+		case AlarmProtocolHTTPS:
+			u, err := url.Parse(endpoint.Endpoint)
+			if err != nil || u.Scheme != "https" || u.Host == "" || strings.ContainsAny(endpoint.Endpoint, " '") {
+				return invalidParams(fmt.Errorf("%s: must be an https URL", path))
+			}
+		}
+	}
+	return nil
+}
+
+// encodeAlarms returns the value of the Alarms stack parameter. Removed
+// alarms are stored as an empty string.
+func encodeAlarms(alarms *Alarms) string {
+	if alarms.IsEmpty() {
+		return ""
+	}
+	data, _ := json.Marshal(alarms) // Alarms always marshals
+	return string(data)
+}
+
+// decodeAlarms parses the value of the Alarms stack parameter. Stacks
+// from before alarms were supported have none.
+func decodeAlarms(value string) *Alarms {
+	if value == "" {
+		return nil
+	}
+	alarms := &Alarms{}
+	if err := json.Unmarshal([]byte(value), alarms); err != nil {
+		return nil
+	}
+	return alarms
+}
@@ -0,0 +1,101 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	ParamAllowedSenders = "AllowedSenders"
+)
+
+var (
+	accountID = regexp.MustCompile(`^[0-9]{12}$`)
+	// sourceARN matches the ARNs of resources in any partition, with an
+	// optional region and account as for S3 buckets.
+	sourceARN = regexp.MustCompile(`^arn:aws[a-z-]*:[a-z0-9-]+:[a-z0-9-]*:([0-9]{12})?:[a-zA-Z0-9_.:/+=,@*-]+$`)
+)
+
+// allowedSenderServices are the AWS service principals that may be
+// allowed to send to queues.
+var allowedSenderServices = []string{"events.amazonaws.com", "s3.amazonaws.com", "sns.amazonaws.com"}
+
+// AllowedSender is a principal that may send messages to the primary
+// queue: either an AWS service, limited to a single source, or another
+// AWS account.
+type AllowedSender struct {
+	// Service is the principal of an AWS service that can deliver to
+	// SQS: EventBridge, S3 or SNS.
+	Service string `json:"service,omitempty" enum:"events.amazonaws.com,s3.amazonaws.com,sns.amazonaws.com"`
+	// SourceARN is the resource the service sends on behalf of, such as
+	// an S3 bucket or an EventBridge rule. Required with Service.
+	SourceARN string `json:"source_arn,omitempty"`
+	// Account is the ID of an AWS account. It must be one of the
+	// accounts allowed by the operator.
+	Account string `json:"account,omitempty"`
+}
+
+// validateAllowedSenders checks each sender is either a service with a
+// source ARN or an account the operator allows.
+func (s *Provider) validateAllowedSenders(senders []AllowedSender) error {
+	for i, sender := range senders {
+		path := fmt.Sprintf("allowed_senders[%d]", i)
+		// senders are rendered into the queue template
+		fields := []struct{ name, value string }{
+			{"service", sender.Service},
+			{"source_arn", sender.SourceARN},
+			{"account", sender.Account},
+		}
+		for _, field := range fields {
+			if err := validateTemplateString(path+"."+field.name, field.value); err != nil {
+				return err
+			}
+		}
+		switch {
+		case sender.Service != "" && sender.Account != "":
+			return invalidParams(fmt.Errorf("%s: only one of service and account can be set", path))
+		case sender.Service != "":
+			if !contains(allowedSenderServices, sender.Service) {
+				return invalidParams(fmt.Errorf("%s.service: must be one of %s", path, strings.Join(allowedSenderServices, ", ")))
+			}
+			if !sourceARN.MatchString(sender.SourceARN) {
+				return invalidParams(fmt.Errorf("%s.source_arn: must be an ARN", path))
+			}
+		case sender.Account != "":
+			if sender.SourceARN != "" {
+				return invalidParams(fmt.Errorf("%s.source_arn: can only be set with service", path))
+			}
+			if !accountID.MatchString(sender.Account) {
+				return invalidParams(fmt.Errorf("%s.account: must be a 12 digit AWS account ID", path))
+			}
+			if !contains(s.AllowedSenderAccounts, sender.Account) {
+				return invalidParams(fmt.Errorf("%s.account: %s is not permitted", path, sender.Account))
+			}
+		default:
+			return invalidParams(fmt.Errorf("%s: one of service and account is required", path))
+		}
+	}
+	return nil
+}
+
+// encodeAllowedSenders returns the value of the AllowedSenders stack
+// parameter.
+func encodeAllowedSenders(senders []AllowedSender) string {
+	data, _ := json.Marshal(senders) // AllowedSender always marshals
+	return string(data)
+}
+
+// decodeAllowedSenders parses the value of the AllowedSenders stack
+// parameter. Stacks from before allowed senders were supported have none.
+func decodeAllowedSenders(value string) []AllowedSender {
+	senders := []AllowedSender{}
+	if value == "" {
+		return senders
+	}
+	if err := json.Unmarshal([]byte(value), &senders); err != nil {
+		return []AllowedSender{}
+	}
+	return senders
+}
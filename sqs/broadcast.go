@@ -0,0 +1,53 @@
+package sqs
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	ResourceBindingQueue             = "BindingQueue"
+	ResourceBindingQueuePolicy       = "BindingQueuePolicy"
+	ResourceBindingQueueSubscription = "BindingQueueSubscription"
+)
+
+// BroadcastQueue is the queue a binding on a broadcast plan gets for
+// itself, subscribed to the instance's topic.
+type BroadcastQueue struct {
+	QueueName string
+	FIFO      bool
+	// Params are the instance's queue parameters, which the binding
+	// queue is created with.
+	Params QueueParams
+}
+
+// broadcastQueue returns the queue for a binding to an instance on a
+// broadcast plan. The queue is named after the binding, like the rest of
+// the binding's resources.
+func (s *Provider) broadcastQueue(bindingID string, queueStack *cloudformation.Stack) *BroadcastQueue {
+	queue := &BroadcastQueue{
+		FIFO:   strings.HasSuffix(getStackOutput(queueStack, OutputTopicARN), ExtFIFO),
+		Params: QueueParamsFromStack(stackParamValues(queueStack)),
+	}
+	queue.QueueName = s.getStackName(bindingID)
+	if queue.FIFO {
+		queue.QueueName += ExtFIFO
+	}
+	return queue
+}
+
+// isBroadcastStack returns true if the queue stack has a topic but no
+// queues, as the queues belong to its bindings.
+func isBroadcastStack(stack *cloudformation.Stack) bool {
+	return getStackOutput(stack, OutputPrimaryQueueURL) == "" && getStackOutput(stack, OutputTopicARN) != ""
+}
+
+// isFIFOStack returns true if the queue stack has FIFO queues, or a FIFO
+// topic for the queues of its bindings.
+func isFIFOStack(stack *cloudformation.Stack) bool {
+	if isBroadcastStack(stack) {
+		return strings.HasSuffix(getStackOutput(stack, OutputTopicARN), ExtFIFO)
+	}
+	return strings.HasSuffix(getStackOutput(stack, OutputPrimaryQueueURL), ExtFIFO)
+}
@@ -0,0 +1,71 @@
+package sqs
+
+import (
+	"context"
+
+	"github.com/alphagov/paas-service-broker-base/broker"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+// Broker extends the base broker with GetInstance, which the base broker
+// does not implement, served from the SQS provider. It also provisions
+// and deprovisions synchronously when the platform does not allow
+// asynchronous operations, which the base broker rejects.
+type Broker struct {
+	*broker.Broker
+	Provider *Provider
+}
+
+// NewBroker wraps a base broker backed by provider.
+func NewBroker(base *broker.Broker, provider *Provider) *Broker {
+	return &Broker{
+		Broker:   base,
+		Provider: provider,
+	}
+}
+
+// Services advertises that instances can be fetched.
+func (b *Broker) Services(ctx context.Context) ([]domain.Service, error) {
+	services, err := b.Broker.Services(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range services {
+		services[i].InstancesRetrievable = true
+	}
+	return services, nil
+}
+
+func (b *Broker) GetInstance(ctx context.Context, instanceID string) (domain.GetInstanceDetailsSpec, error) {
+	spec, err := b.Provider.GetInstance(ctx, instanceID)
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, err
+	}
+	return *spec, nil
+}
+
+// Provision waits for the queue stack to be created if the platform does
+// not allow asynchronous operations.
+func (b *Broker) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	spec, err := b.Broker.Provision(ctx, instanceID, details, true)
+	if err != nil || asyncAllowed || !spec.IsAsync {
+		return spec, err
+	}
+	if err := b.Provider.WaitForProvision(ctx, instanceID, spec.OperationData); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+	return domain.ProvisionedServiceSpec{DashboardURL: spec.DashboardURL}, nil
+}
+
+// Deprovision waits for the queue stack to be deleted if the platform
+// does not allow asynchronous operations.
+func (b *Broker) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	spec, err := b.Broker.Deprovision(ctx, instanceID, details, true)
+	if err != nil || asyncAllowed || !spec.IsAsync {
+		return spec, err
+	}
+	if err := b.Provider.WaitForDeprovision(ctx, instanceID, spec.OperationData); err != nil {
+		return domain.DeprovisionServiceSpec{}, err
+	}
+	return domain.DeprovisionServiceSpec{}, nil
+}
@@ -2,6 +2,7 @@ package sqs
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,10 +14,13 @@ import (
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o fakes/fake_sqs_client.go . Client
 type Client interface {
 	DescribeStacksWithContext(aws.Context, *cloudformation.DescribeStacksInput, ...request.Option) (*cloudformation.DescribeStacksOutput, error)
+	DescribeStackEventsWithContext(aws.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)
 	CreateStackWithContext(aws.Context, *cloudformation.CreateStackInput, ...request.Option) (*cloudformation.CreateStackOutput, error)
 	UpdateStackWithContext(aws.Context, *cloudformation.UpdateStackInput, ...request.Option) (*cloudformation.UpdateStackOutput, error)
 	DeleteStackWithContext(aws.Context, *cloudformation.DeleteStackInput, ...request.Option) (*cloudformation.DeleteStackOutput, error)
 	GetSecretValueWithContext(aws.Context, *secretsmanager.GetSecretValueInput, ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+	StartMessageMoveTaskWithContext(aws.Context, *StartMessageMoveTaskInput, ...request.Option) (*StartMessageMoveTaskOutput, error)
+	ListMessageMoveTasksWithContext(aws.Context, *ListMessageMoveTasksInput, ...request.Option) (*ListMessageMoveTasksOutput, error)
 }
 
 type Config struct {
@@ -31,14 +35,98 @@ type Config struct {
 	// Endpoint.
 	AdditionalUserPolicy string `json:"additional_user_policy"`
 	PermissionsBoundary  string `json:"permissions_boundary"`
+	// AllowedKMSKeyARNs lists the existing KMS keys that tenants may
+	// select with the kms_key_arn parameter. When empty, tenants can
+	// only use keys created by the broker.
+	AllowedKMSKeyARNs []string `json:"allowed_kms_key_arns"`
+	// AllowedSenderAccounts lists the AWS accounts that tenants may
+	// allow to send to their queues with the allowed_senders parameter.
+	AllowedSenderAccounts []string `json:"allowed_sender_accounts"`
+	// DenyInsecureTransport adds a statement to every queue policy that
+	// denies requests not made over TLS.
+	DenyInsecureTransport bool `json:"deny_insecure_transport"`
+	// RestrictPrincipals adds a statement to every queue policy that
+	// denies principals in the account other than the IAM users and
+	// roles the broker creates for bindings and BrokerPrincipalARN, which
+	// must be set to the IAM role or user the broker runs as.
+	RestrictPrincipals bool   `json:"restrict_principals"`
+	BrokerPrincipalARN string `json:"broker_principal_arn"`
+	// Tags are added to every stack the broker creates, and so to every
+	// resource in them, alongside the broker's own tags and any the
+	// tenant sets with the tags parameter.
+	Tags map[string]string `json:"tags"`
+	// RoleTrustPrincipalARN is the IAM principal allowed to assume the
+	// roles of role bindings, with each binding's external ID. Role
+	// bindings are not available unless it is set.
+	RoleTrustPrincipalARN string `json:"role_trust_principal_arn"`
+	// AccessKeyMaxAgeDays is the age at which the access keys of
+	// bindings are rotated. Keys are not rotated when it is zero.
+	AccessKeyMaxAgeDays int `json:"access_key_max_age_days"`
+	// AccessKeyGracePeriodHours is how long the old access key of a
+	// binding keeps working after rotation, so apps can fetch the new
+	// credentials.
+	AccessKeyGracePeriodHours int `json:"access_key_grace_period_hours"`
+	// AccessPolicies declares the access policies bindings may request,
+	// keyed by name. They are added to DefaultAccessPolicies, replacing
+	// any of the same name.
+	AccessPolicies map[string]AccessPolicyConfig `json:"access_policies"`
+	// Plans declares the behaviour of each catalog plan, keyed by plan
+	// ID. Plans that are not listed fall back to using the plan name to
+	// decide between standard and FIFO queues.
+	Plans map[string]PlanConfig `json:"plans"`
 }
 
 func NewConfig(configJSON []byte) (*Config, error) {
-	config := &Config{}
+	config := &Config{
+		AccessKeyGracePeriodHours: 24,
+	}
 	err := json.Unmarshal(configJSON, &config)
 	if err != nil {
 		return nil, err
 	}
+	if config.RestrictPrincipals && config.BrokerPrincipalARN == "" {
+		return nil, fmt.Errorf("Config error: broker_principal_arn is required with restrict_principals")
+	}
+	if len(config.Tags) > MaxOperatorTags {
+		return nil, fmt.Errorf("Config error: tags: at most %d tags can be set", MaxOperatorTags)
+	}
+	for _, key := range sortedKeys(config.Tags) {
+		if err := validateTag(key, config.Tags[key]); err != nil {
+			return nil, fmt.Errorf("Config error: tags.%s: %s", key, err)
+		}
+	}
+	if config.AccessKeyMaxAgeDays < 0 || config.AccessKeyGracePeriodHours < 0 {
+		return nil, fmt.Errorf("Config error: access_key_max_age_days and access_key_grace_period_hours cannot be negative")
+	}
+	if config.AccessKeyMaxAgeDays > 0 && config.AccessKeyGracePeriod() >= config.AccessKeyMaxAge() {
+		return nil, fmt.Errorf("Config error: access_key_grace_period_hours must be shorter than access_key_max_age_days")
+	}
+	if err := ValidateAccessPolicies(config.AccessPolicies); err != nil {
+		return nil, fmt.Errorf("Config error: %s", err)
+	}
+	config.AccessPolicies = withDefaultAccessPolicies(config.AccessPolicies)
+	for id, plan := range config.Plans {
+		if err := plan.Validate(); err != nil {
+			return nil, fmt.Errorf("Config error: plan %s: %s", id, err)
+		}
+		if err := plan.ValidateAccessPolicies(config.AccessPolicies); err != nil {
+			return nil, fmt.Errorf("Config error: plan %s: %s", id, err)
+		}
+		if plan.DefaultBindingType() == BindingTypeRole && config.RoleTrustPrincipalARN == "" {
+			return nil, fmt.Errorf("Config error: plan %s: role_trust_principal_arn is required for role bindings", id)
+		}
+	}
 
 	return config, nil
 }
+
+// AccessKeyMaxAge returns the age at which binding access keys are
+// rotated.
+func (config *Config) AccessKeyMaxAge() time.Duration {
+	return time.Duration(config.AccessKeyMaxAgeDays) * 24 * time.Hour
+}
+
+// AccessKeyGracePeriod returns how long replaced access keys are kept.
+func (config *Config) AccessKeyGracePeriod() time.Duration {
+	return time.Duration(config.AccessKeyGracePeriodHours) * time.Hour
+}
@@ -1,6 +1,9 @@
 package sqs_test
 
 import (
+	"time"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -16,3 +19,101 @@ var _ = Describe("Client", func() {
 		Expect(true).To(Equal(true))
 	})
 })
+
+var _ = Describe("Config", func() {
+	It("requires the broker principal to restrict principals", func() {
+		_, err := sqs.NewConfig([]byte(`{"restrict_principals": true}`))
+		Expect(err).To(MatchError(ContainSubstring("broker_principal_arn is required")))
+		config, err := sqs.NewConfig([]byte(`{"restrict_principals": true, "broker_principal_arn": "arn:aws:iam::123456789012:role/sqs-broker"}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.RestrictPrincipals).To(BeTrue())
+	})
+
+	It("validates the operator's tags", func() {
+		config, err := sqs.NewConfig([]byte(`{"tags": {"cost-centre": "1234"}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Tags).To(Equal(map[string]string{"cost-centre": "1234"}))
+		_, err = sqs.NewConfig([]byte(`{"tags": {"aws:team": "paas"}}`))
+		Expect(err).To(MatchError("Config error: tags.aws:team: is reserved for use by AWS"))
+		_, err = sqs.NewConfig([]byte(`{"tags": {"Environment": "prod"}}`))
+		Expect(err).To(MatchError("Config error: tags.Environment: is set by the broker"))
+	})
+
+	It("requires a trusted principal for plans with role bindings", func() {
+		_, err := sqs.NewConfig([]byte(`{"plans": {"uuid-2": {"queue_type": "standard", "binding_types": ["role"]}}}`))
+		Expect(err).To(MatchError("Config error: plan uuid-2: role_trust_principal_arn is required for role bindings"))
+		config, err := sqs.NewConfig([]byte(`{"role_trust_principal_arn": "arn:aws:iam::123456789012:role/platform", "plans": {"uuid-2": {"queue_type": "standard", "binding_types": ["role"]}}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.RoleTrustPrincipalARN).To(Equal("arn:aws:iam::123456789012:role/platform"))
+	})
+
+	It("configures access key rotation", func() {
+		config, err := sqs.NewConfig([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AccessKeyMaxAge()).To(BeZero())
+		Expect(config.AccessKeyGracePeriod()).To(Equal(24 * time.Hour))
+		config, err = sqs.NewConfig([]byte(`{"access_key_max_age_days": 90, "access_key_grace_period_hours": 48}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AccessKeyMaxAge()).To(Equal(90 * 24 * time.Hour))
+		Expect(config.AccessKeyGracePeriod()).To(Equal(48 * time.Hour))
+		_, err = sqs.NewConfig([]byte(`{"access_key_max_age_days": 1, "access_key_grace_period_hours": 24}`))
+		Expect(err).To(MatchError("Config error: access_key_grace_period_hours must be shorter than access_key_max_age_days"))
+		_, err = sqs.NewConfig([]byte(`{"access_key_max_age_days": -1}`))
+		Expect(err).To(MatchError(ContainSubstring("cannot be negative")))
+	})
+
+	It("adds the configured access policies to the defaults", func() {
+		config, err := sqs.NewConfig([]byte(`{}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AccessPolicies).To(Equal(sqs.DefaultAccessPolicies))
+		config, err = sqs.NewConfig([]byte(`{
+			"access_policies": {
+				"consumer": {"actions": ["sqs:ReceiveMessage", "sqs:DeleteMessage"]},
+				"autoscaler": {
+					"actions": ["sqs:GetQueueAttributes"],
+					"queues": "primary",
+					"statements": [{"actions": ["cloudwatch:GetMetricData"]}]
+				}
+			},
+			"plans": {"uuid-2": {"queue_type": "standard", "access_policies": ["autoscaler"]}}
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.AccessPolicies).To(HaveLen(5))
+		Expect(config.AccessPolicies).To(HaveKeyWithValue("full", sqs.DefaultAccessPolicies["full"]))
+		Expect(config.AccessPolicies).To(HaveKeyWithValue("consumer", sqs.AccessPolicyConfig{
+			Actions: []string{"sqs:ReceiveMessage", "sqs:DeleteMessage"},
+		}))
+		Expect(config.AccessPolicies).To(HaveKeyWithValue("autoscaler", sqs.AccessPolicyConfig{
+			Actions:    []string{"sqs:GetQueueAttributes"},
+			Queues:     sqs.AccessPolicyQueuesPrimary,
+			Statements: []sqs.PolicyStatement{{Actions: []string{"cloudwatch:GetMetricData"}}},
+		}))
+	})
+
+	DescribeTable("validates the access policies",
+		func(configJSON, expectedErr string) {
+			_, err := sqs.NewConfig([]byte(configJSON))
+			Expect(err).To(MatchError(expectedErr))
+		},
+		Entry("bad name", `{"access_policies": {"read only": {"actions": ["sqs:GetQueueUrl"]}}}`,
+			`Config error: access_policies: "read only" must be at most 64 letters, numbers, hyphens or underscores`),
+		Entry("no actions", `{"access_policies": {"reader": {"actions": []}}}`,
+			"Config error: access_policies.reader: actions: at least one action is required"),
+		Entry("not a queue action", `{"access_policies": {"reader": {"actions": ["s3:GetObject"]}}}`,
+			`Config error: access_policies.reader: actions: "s3:GetObject" must be an sqs: or sns: action`),
+		Entry("only topic actions", `{"access_policies": {"publisher": {"actions": ["sns:Publish"]}}}`,
+			"Config error: access_policies.publisher: actions: at least one sqs: action is required"),
+		Entry("unknown queues", `{"access_policies": {"reader": {"actions": ["sqs:GetQueueUrl"], "queues": "all"}}}`,
+			`Config error: access_policies.reader: queues must be "primary", "secondary" or "both"`),
+		Entry("unknown effect", `{"access_policies": {"reader": {"actions": ["sqs:GetQueueUrl"], "statements": [{"effect": "Maybe", "actions": ["cloudwatch:ListMetrics"]}]}}}`,
+			`Config error: access_policies.reader: statements[0].effect must be "Allow" or "Deny"`),
+		Entry("statement without actions", `{"access_policies": {"reader": {"actions": ["sqs:GetQueueUrl"], "statements": [{}]}}}`,
+			"Config error: access_policies.reader: statements[0].actions: at least one action is required"),
+		Entry("statement action", `{"access_policies": {"reader": {"actions": ["sqs:GetQueueUrl"], "statements": [{"actions": ["ListMetrics"]}]}}}`,
+			`Config error: access_policies.reader: statements[0].actions: "ListMetrics" is not an IAM action`),
+		Entry("statement resource", `{"access_policies": {"reader": {"actions": ["sqs:GetQueueUrl"], "statements": [{"actions": ["cloudwatch:ListMetrics"], "resources": [""]}]}}}`,
+			`Config error: access_policies.reader: statements[0].resources: "" is not a resource ARN`),
+		Entry("unknown plan access policy", `{"plans": {"uuid-2": {"queue_type": "standard", "access_policies": ["reader"]}}}`,
+			`Config error: plan uuid-2: access_policies: unknown access policy "reader"`),
+	)
+})
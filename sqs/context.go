@@ -0,0 +1,133 @@
+package sqs
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+const (
+	TagOrganizationGUID = "OrganizationGUID"
+	TagOrganizationName = "OrganizationName"
+	TagSpaceGUID        = "SpaceGUID"
+	TagSpaceName        = "SpaceName"
+	TagNamespace        = "Namespace"
+	TagClusterID        = "ClusterID"
+	TagAppGUID          = "AppGUID"
+)
+
+const (
+	PlatformCloudFoundry = "cloudfoundry"
+	PlatformKubernetes   = "kubernetes"
+)
+
+// contextTagKeys are the tags taken from the context of an instance.
+var contextTagKeys = []string{
+	TagOrganizationGUID,
+	TagOrganizationName,
+	TagSpaceGUID,
+	TagSpaceName,
+	TagNamespace,
+	TagClusterID,
+}
+
+// platformContext is the OSB context the platform sends with provision,
+// update and bind requests. The cloudfoundry profile describes the org
+// and space of the instance, the kubernetes profile its namespace and
+// cluster.
+type platformContext struct {
+	Platform         string `json:"platform"`
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name"`
+	Namespace        string `json:"namespace"`
+	ClusterID        string `json:"clusterid"`
+}
+
+// contextTags returns the tags describing where an instance belongs on
+// the platform. Contexts that cannot be parsed, or from other platforms,
+// give no tags.
+func contextTags(rawContext json.RawMessage) map[string]string {
+	tags := map[string]string{}
+	if len(rawContext) == 0 {
+		return tags
+	}
+	context := platformContext{}
+	if err := json.Unmarshal(rawContext, &context); err != nil {
+		return tags
+	}
+	switch context.Platform {
+	case PlatformCloudFoundry:
+		setTag(tags, TagOrganizationGUID, context.OrganizationGUID)
+		setTag(tags, TagOrganizationName, context.OrganizationName)
+		setTag(tags, TagSpaceGUID, context.SpaceGUID)
+		setTag(tags, TagSpaceName, context.SpaceName)
+	case PlatformKubernetes:
+		setTag(tags, TagNamespace, context.Namespace)
+		setTag(tags, TagClusterID, context.ClusterID)
+	}
+	return tags
+}
+
+// provisionContextTags returns the context tags for a new instance.
+// Platforms that send no context still give the org and space GUIDs.
+func provisionContextTags(details domain.ProvisionDetails) map[string]string {
+	tags := contextTags(details.RawContext)
+	if len(tags) == 0 {
+		setTag(tags, TagOrganizationGUID, details.OrganizationGUID)
+		setTag(tags, TagSpaceGUID, details.SpaceGUID)
+	}
+	return tags
+}
+
+// updateContextTags returns the context tags for an updated instance.
+// The platform sends the context again when an org or space is renamed,
+// but when an update has no context the stack keeps the tags it has.
+func updateContextTags(details domain.UpdateDetails, stack *cloudformation.Stack) map[string]string {
+	tags := contextTags(details.RawContext)
+	if len(tags) > 0 {
+		return tags
+	}
+	for _, tag := range stack.Tags {
+		if contains(contextTagKeys, aws.StringValue(tag.Key)) {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return tags
+}
+
+// bindContextTags returns the context tags for a new binding, which name
+// the app it is bound to. The org and space are on the instance's stack,
+// which leaves room for the instance's tags on the binding's.
+func bindContextTags(details domain.BindDetails) map[string]string {
+	tags := map[string]string{}
+	appGUID := details.AppGUID
+	if details.BindResource != nil && details.BindResource.AppGuid != "" {
+		appGUID = details.BindResource.AppGuid
+	}
+	setTag(tags, TagAppGUID, appGUID)
+	return tags
+}
+
+// setTag sets a tag unless the value is empty. Names on the platform can
+// include characters that AWS does not allow in tags, which are replaced
+// with underscores.
+func setTag(tags map[string]string, key, value string) {
+	if value == "" {
+		return
+	}
+	value = strings.Map(func(r rune) rune {
+		if !tagValue.MatchString(string(r)) {
+			return '_'
+		}
+		return r
+	}, value)
+	if runes := []rune(value); len(runes) > 256 {
+		value = string(runes[:256])
+	}
+	tags[key] = value
+}
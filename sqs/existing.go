@@ -0,0 +1,168 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/awslabs/goformation/v4/intrinsics"
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// existingInstance handles a provision request for an instance whose
+// stack already exists. The OSB spec requires an identical request to
+// succeed, so platforms can safely retry after a timeout, and anything
+// else to conflict.
+func (s *Provider) existingInstance(ctx context.Context, stackName, tmpl string, tags []*cloudformation.Tag, params QueueParams) (*domain.ProvisionedServiceSpec, error) {
+	stack, err := s.getStack(ctx, stackName)
+	if err == ErrStackNotFound {
+		return nil, apiresponses.ErrInstanceAlreadyExists
+	} else if err != nil {
+		return nil, err
+	}
+	if !hasTags(stack, tags) {
+		return nil, apiresponses.ErrInstanceAlreadyExists
+	}
+	same, err := hasParams(stack, tmpl, params.CreateParams())
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, apiresponses.ErrInstanceAlreadyExists
+	}
+
+	switch *stack.StackStatus {
+	case cloudformation.StackStatusCreateInProgress:
+		return &domain.ProvisionedServiceSpec{
+			OperationData: ProvisionOperation,
+			IsAsync:       true,
+		}, nil
+	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete:
+		return &domain.ProvisionedServiceSpec{
+			AlreadyExists: true,
+		}, nil
+	default:
+		return nil, apiresponses.ErrInstanceAlreadyExists
+	}
+}
+
+// existingBinding handles a bind request for a binding whose stack
+// already exists. Identical requests get the existing credentials.
+func (s *Provider) existingBinding(ctx context.Context, stackName string, tags []*cloudformation.Tag, asyncAllowed bool) (*domain.Binding, error) {
+	stack, err := s.getStack(ctx, stackName)
+	if err == ErrStackNotFound {
+		return nil, apiresponses.ErrBindingAlreadyExists
+	} else if err != nil {
+		return nil, err
+	}
+	if !hasTags(withBindingType(stack), tags) {
+		return nil, apiresponses.ErrBindingAlreadyExists
+	}
+	// bindings without subscription settings have no BindingParameters
+	// tag, which hasTags would not notice missing from the request
+	if findTag(stack.Tags, TagBindingParameters) != findTag(tags, TagBindingParameters) {
+		return nil, apiresponses.ErrBindingAlreadyExists
+	}
+
+	switch *stack.StackStatus {
+	case cloudformation.StackStatusCreateInProgress:
+		if !asyncAllowed {
+			return s.getBindingSync(ctx, stackName, BindOperation)
+		}
+		return &domain.Binding{
+			IsAsync:       true,
+			OperationData: BindOperation,
+		}, nil
+	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete:
+		binding, err := s.getBinding(ctx, stackName)
+		if err != nil {
+			return nil, err
+		}
+		return &domain.Binding{
+			AlreadyExists: true,
+			Credentials:   binding.Credentials,
+		}, nil
+	default:
+		return nil, apiresponses.ErrBindingAlreadyExists
+	}
+}
+
+// withBindingType returns the binding stack with its BindingType tag.
+// Bindings from before binding types were recorded are for IAM users.
+func withBindingType(stack *cloudformation.Stack) *cloudformation.Stack {
+	for _, tag := range stack.Tags {
+		if aws.StringValue(tag.Key) == TagBindingType {
+			return stack
+		}
+	}
+	tagged := *stack
+	tagged.Tags = append([]*cloudformation.Tag{
+		{Key: aws.String(TagBindingType), Value: aws.String(BindingTypeUser)},
+	}, stack.Tags...)
+	return &tagged
+}
+
+// hasTags returns true if the stack carries all of the given tags.
+func hasTags(stack *cloudformation.Stack, tags []*cloudformation.Tag) bool {
+	existing := map[string]string{}
+	for _, tag := range stack.Tags {
+		if tag.Key != nil && tag.Value != nil {
+			existing[*tag.Key] = *tag.Value
+		}
+	}
+	for _, tag := range tags {
+		value, ok := existing[aws.StringValue(tag.Key)]
+		if !ok || value != aws.StringValue(tag.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasParams returns true if the stack's parameter values are those it
+// would have been created with from the template and parameters.
+func hasParams(stack *cloudformation.Stack, tmpl string, params []*cloudformation.Parameter) (bool, error) {
+	expected, err := templateDefaults(tmpl)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range params {
+		expected[*p.ParameterKey] = *p.ParameterValue
+	}
+	current := stackParamValues(stack)
+	if len(current) != len(expected) {
+		return false, nil
+	}
+	for key, value := range expected {
+		if v, ok := current[key]; !ok || v != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// templateDefaults returns the default value of each template parameter.
+func templateDefaults(tmpl string) (map[string]string, error) {
+	data, err := intrinsics.ProcessYAML([]byte(tmpl), &intrinsics.ProcessorOptions{NoProcess: true})
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		Parameters map[string]struct {
+			Default interface{}
+		}
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	defaults := map[string]string{}
+	for name, param := range parsed.Parameters {
+		if param.Default != nil {
+			defaults[name] = fmt.Sprint(param.Default)
+		}
+	}
+	return defaults, nil
+}
@@ -42,6 +42,21 @@ type FakeClient struct {
 		result1 *cloudformation.DeleteStackOutput
 		result2 error
 	}
+	DescribeStackEventsWithContextStub        func(context.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)
+	describeStackEventsWithContextMutex       sync.RWMutex
+	describeStackEventsWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *cloudformation.DescribeStackEventsInput
+		arg3 []request.Option
+	}
+	describeStackEventsWithContextReturns struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}
+	describeStackEventsWithContextReturnsOnCall map[int]struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}
 	DescribeStacksWithContextStub        func(context.Context, *cloudformation.DescribeStacksInput, ...request.Option) (*cloudformation.DescribeStacksOutput, error)
 	describeStacksWithContextMutex       sync.RWMutex
 	describeStacksWithContextArgsForCall []struct {
@@ -72,6 +87,36 @@ type FakeClient struct {
 		result1 *secretsmanager.GetSecretValueOutput
 		result2 error
 	}
+	ListMessageMoveTasksWithContextStub        func(context.Context, *sqs.ListMessageMoveTasksInput, ...request.Option) (*sqs.ListMessageMoveTasksOutput, error)
+	listMessageMoveTasksWithContextMutex       sync.RWMutex
+	listMessageMoveTasksWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *sqs.ListMessageMoveTasksInput
+		arg3 []request.Option
+	}
+	listMessageMoveTasksWithContextReturns struct {
+		result1 *sqs.ListMessageMoveTasksOutput
+		result2 error
+	}
+	listMessageMoveTasksWithContextReturnsOnCall map[int]struct {
+		result1 *sqs.ListMessageMoveTasksOutput
+		result2 error
+	}
+	StartMessageMoveTaskWithContextStub        func(context.Context, *sqs.StartMessageMoveTaskInput, ...request.Option) (*sqs.StartMessageMoveTaskOutput, error)
+	startMessageMoveTaskWithContextMutex       sync.RWMutex
+	startMessageMoveTaskWithContextArgsForCall []struct {
+		arg1 context.Context
+		arg2 *sqs.StartMessageMoveTaskInput
+		arg3 []request.Option
+	}
+	startMessageMoveTaskWithContextReturns struct {
+		result1 *sqs.StartMessageMoveTaskOutput
+		result2 error
+	}
+	startMessageMoveTaskWithContextReturnsOnCall map[int]struct {
+		result1 *sqs.StartMessageMoveTaskOutput
+		result2 error
+	}
 	UpdateStackWithContextStub        func(context.Context, *cloudformation.UpdateStackInput, ...request.Option) (*cloudformation.UpdateStackOutput, error)
 	updateStackWithContextMutex       sync.RWMutex
 	updateStackWithContextArgsForCall []struct {
@@ -221,6 +266,71 @@ func (fake *FakeClient) DeleteStackWithContextReturnsOnCall(i int, result1 *clou
 	}{result1, result2}
 }
 
+func (fake *FakeClient) DescribeStackEventsWithContext(arg1 context.Context, arg2 *cloudformation.DescribeStackEventsInput, arg3 ...request.Option) (*cloudformation.DescribeStackEventsOutput, error) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	ret, specificReturn := fake.describeStackEventsWithContextReturnsOnCall[len(fake.describeStackEventsWithContextArgsForCall)]
+	fake.describeStackEventsWithContextArgsForCall = append(fake.describeStackEventsWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *cloudformation.DescribeStackEventsInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("DescribeStackEventsWithContext", []interface{}{arg1, arg2, arg3})
+	fake.describeStackEventsWithContextMutex.Unlock()
+	if fake.DescribeStackEventsWithContextStub != nil {
+		return fake.DescribeStackEventsWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.describeStackEventsWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextCallCount() int {
+	fake.describeStackEventsWithContextMutex.RLock()
+	defer fake.describeStackEventsWithContextMutex.RUnlock()
+	return len(fake.describeStackEventsWithContextArgsForCall)
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextCalls(stub func(context.Context, *cloudformation.DescribeStackEventsInput, ...request.Option) (*cloudformation.DescribeStackEventsOutput, error)) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	defer fake.describeStackEventsWithContextMutex.Unlock()
+	fake.DescribeStackEventsWithContextStub = stub
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextArgsForCall(i int) (context.Context, *cloudformation.DescribeStackEventsInput, []request.Option) {
+	fake.describeStackEventsWithContextMutex.RLock()
+	defer fake.describeStackEventsWithContextMutex.RUnlock()
+	argsForCall := fake.describeStackEventsWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextReturns(result1 *cloudformation.DescribeStackEventsOutput, result2 error) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	defer fake.describeStackEventsWithContextMutex.Unlock()
+	fake.DescribeStackEventsWithContextStub = nil
+	fake.describeStackEventsWithContextReturns = struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) DescribeStackEventsWithContextReturnsOnCall(i int, result1 *cloudformation.DescribeStackEventsOutput, result2 error) {
+	fake.describeStackEventsWithContextMutex.Lock()
+	defer fake.describeStackEventsWithContextMutex.Unlock()
+	fake.DescribeStackEventsWithContextStub = nil
+	if fake.describeStackEventsWithContextReturnsOnCall == nil {
+		fake.describeStackEventsWithContextReturnsOnCall = make(map[int]struct {
+			result1 *cloudformation.DescribeStackEventsOutput
+			result2 error
+		})
+	}
+	fake.describeStackEventsWithContextReturnsOnCall[i] = struct {
+		result1 *cloudformation.DescribeStackEventsOutput
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) DescribeStacksWithContext(arg1 context.Context, arg2 *cloudformation.DescribeStacksInput, arg3 ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
 	fake.describeStacksWithContextMutex.Lock()
 	ret, specificReturn := fake.describeStacksWithContextReturnsOnCall[len(fake.describeStacksWithContextArgsForCall)]
@@ -351,6 +461,136 @@ func (fake *FakeClient) GetSecretValueWithContextReturnsOnCall(i int, result1 *s
 	}{result1, result2}
 }
 
+func (fake *FakeClient) ListMessageMoveTasksWithContext(arg1 context.Context, arg2 *sqs.ListMessageMoveTasksInput, arg3 ...request.Option) (*sqs.ListMessageMoveTasksOutput, error) {
+	fake.listMessageMoveTasksWithContextMutex.Lock()
+	ret, specificReturn := fake.listMessageMoveTasksWithContextReturnsOnCall[len(fake.listMessageMoveTasksWithContextArgsForCall)]
+	fake.listMessageMoveTasksWithContextArgsForCall = append(fake.listMessageMoveTasksWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *sqs.ListMessageMoveTasksInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("ListMessageMoveTasksWithContext", []interface{}{arg1, arg2, arg3})
+	fake.listMessageMoveTasksWithContextMutex.Unlock()
+	if fake.ListMessageMoveTasksWithContextStub != nil {
+		return fake.ListMessageMoveTasksWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.listMessageMoveTasksWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) ListMessageMoveTasksWithContextCallCount() int {
+	fake.listMessageMoveTasksWithContextMutex.RLock()
+	defer fake.listMessageMoveTasksWithContextMutex.RUnlock()
+	return len(fake.listMessageMoveTasksWithContextArgsForCall)
+}
+
+func (fake *FakeClient) ListMessageMoveTasksWithContextCalls(stub func(context.Context, *sqs.ListMessageMoveTasksInput, ...request.Option) (*sqs.ListMessageMoveTasksOutput, error)) {
+	fake.listMessageMoveTasksWithContextMutex.Lock()
+	defer fake.listMessageMoveTasksWithContextMutex.Unlock()
+	fake.ListMessageMoveTasksWithContextStub = stub
+}
+
+func (fake *FakeClient) ListMessageMoveTasksWithContextArgsForCall(i int) (context.Context, *sqs.ListMessageMoveTasksInput, []request.Option) {
+	fake.listMessageMoveTasksWithContextMutex.RLock()
+	defer fake.listMessageMoveTasksWithContextMutex.RUnlock()
+	argsForCall := fake.listMessageMoveTasksWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) ListMessageMoveTasksWithContextReturns(result1 *sqs.ListMessageMoveTasksOutput, result2 error) {
+	fake.listMessageMoveTasksWithContextMutex.Lock()
+	defer fake.listMessageMoveTasksWithContextMutex.Unlock()
+	fake.ListMessageMoveTasksWithContextStub = nil
+	fake.listMessageMoveTasksWithContextReturns = struct {
+		result1 *sqs.ListMessageMoveTasksOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) ListMessageMoveTasksWithContextReturnsOnCall(i int, result1 *sqs.ListMessageMoveTasksOutput, result2 error) {
+	fake.listMessageMoveTasksWithContextMutex.Lock()
+	defer fake.listMessageMoveTasksWithContextMutex.Unlock()
+	fake.ListMessageMoveTasksWithContextStub = nil
+	if fake.listMessageMoveTasksWithContextReturnsOnCall == nil {
+		fake.listMessageMoveTasksWithContextReturnsOnCall = make(map[int]struct {
+			result1 *sqs.ListMessageMoveTasksOutput
+			result2 error
+		})
+	}
+	fake.listMessageMoveTasksWithContextReturnsOnCall[i] = struct {
+		result1 *sqs.ListMessageMoveTasksOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) StartMessageMoveTaskWithContext(arg1 context.Context, arg2 *sqs.StartMessageMoveTaskInput, arg3 ...request.Option) (*sqs.StartMessageMoveTaskOutput, error) {
+	fake.startMessageMoveTaskWithContextMutex.Lock()
+	ret, specificReturn := fake.startMessageMoveTaskWithContextReturnsOnCall[len(fake.startMessageMoveTaskWithContextArgsForCall)]
+	fake.startMessageMoveTaskWithContextArgsForCall = append(fake.startMessageMoveTaskWithContextArgsForCall, struct {
+		arg1 context.Context
+		arg2 *sqs.StartMessageMoveTaskInput
+		arg3 []request.Option
+	}{arg1, arg2, arg3})
+	fake.recordInvocation("StartMessageMoveTaskWithContext", []interface{}{arg1, arg2, arg3})
+	fake.startMessageMoveTaskWithContextMutex.Unlock()
+	if fake.StartMessageMoveTaskWithContextStub != nil {
+		return fake.StartMessageMoveTaskWithContextStub(arg1, arg2, arg3...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.startMessageMoveTaskWithContextReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) StartMessageMoveTaskWithContextCallCount() int {
+	fake.startMessageMoveTaskWithContextMutex.RLock()
+	defer fake.startMessageMoveTaskWithContextMutex.RUnlock()
+	return len(fake.startMessageMoveTaskWithContextArgsForCall)
+}
+
+func (fake *FakeClient) StartMessageMoveTaskWithContextCalls(stub func(context.Context, *sqs.StartMessageMoveTaskInput, ...request.Option) (*sqs.StartMessageMoveTaskOutput, error)) {
+	fake.startMessageMoveTaskWithContextMutex.Lock()
+	defer fake.startMessageMoveTaskWithContextMutex.Unlock()
+	fake.StartMessageMoveTaskWithContextStub = stub
+}
+
+func (fake *FakeClient) StartMessageMoveTaskWithContextArgsForCall(i int) (context.Context, *sqs.StartMessageMoveTaskInput, []request.Option) {
+	fake.startMessageMoveTaskWithContextMutex.RLock()
+	defer fake.startMessageMoveTaskWithContextMutex.RUnlock()
+	argsForCall := fake.startMessageMoveTaskWithContextArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeClient) StartMessageMoveTaskWithContextReturns(result1 *sqs.StartMessageMoveTaskOutput, result2 error) {
+	fake.startMessageMoveTaskWithContextMutex.Lock()
+	defer fake.startMessageMoveTaskWithContextMutex.Unlock()
+	fake.StartMessageMoveTaskWithContextStub = nil
+	fake.startMessageMoveTaskWithContextReturns = struct {
+		result1 *sqs.StartMessageMoveTaskOutput
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) StartMessageMoveTaskWithContextReturnsOnCall(i int, result1 *sqs.StartMessageMoveTaskOutput, result2 error) {
+	fake.startMessageMoveTaskWithContextMutex.Lock()
+	defer fake.startMessageMoveTaskWithContextMutex.Unlock()
+	fake.StartMessageMoveTaskWithContextStub = nil
+	if fake.startMessageMoveTaskWithContextReturnsOnCall == nil {
+		fake.startMessageMoveTaskWithContextReturnsOnCall = make(map[int]struct {
+			result1 *sqs.StartMessageMoveTaskOutput
+			result2 error
+		})
+	}
+	fake.startMessageMoveTaskWithContextReturnsOnCall[i] = struct {
+		result1 *sqs.StartMessageMoveTaskOutput
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) UpdateStackWithContext(arg1 context.Context, arg2 *cloudformation.UpdateStackInput, arg3 ...request.Option) (*cloudformation.UpdateStackOutput, error) {
 	fake.updateStackWithContextMutex.Lock()
 	ret, specificReturn := fake.updateStackWithContextReturnsOnCall[len(fake.updateStackWithContextArgsForCall)]
@@ -423,10 +663,16 @@ func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	defer fake.createStackWithContextMutex.RUnlock()
 	fake.deleteStackWithContextMutex.RLock()
 	defer fake.deleteStackWithContextMutex.RUnlock()
+	fake.describeStackEventsWithContextMutex.RLock()
+	defer fake.describeStackEventsWithContextMutex.RUnlock()
 	fake.describeStacksWithContextMutex.RLock()
 	defer fake.describeStacksWithContextMutex.RUnlock()
 	fake.getSecretValueWithContextMutex.RLock()
 	defer fake.getSecretValueWithContextMutex.RUnlock()
+	fake.listMessageMoveTasksWithContextMutex.RLock()
+	defer fake.listMessageMoveTasksWithContextMutex.RUnlock()
+	fake.startMessageMoveTaskWithContextMutex.RLock()
+	defer fake.startMessageMoveTaskWithContextMutex.RUnlock()
 	fake.updateStackWithContextMutex.RLock()
 	defer fake.updateStackWithContextMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
@@ -0,0 +1,222 @@
+package sqs
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	ParamActiveAccessKey    = "ActiveAccessKey"
+	ParamRetiringAccessKey  = "RetiringAccessKey"
+	ParamAccessKeyRotatedAt = "AccessKeyRotatedAt"
+)
+
+// AccessKeyRotationInterval is how often the broker looks for access keys
+// to rotate when AccessKeyMaxAge is set.
+var AccessKeyRotationInterval = time.Hour
+
+// RunAccessKeyRotation calls RotateAccessKeys every interval until the
+// context is canceled.
+func (s *Provider) RunAccessKeyRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.RotateAccessKeys(ctx, time.Now()); err != nil {
+			s.Logger.Error("rotate-access-keys", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RotateAccessKeys rotates the access keys of bindings in two steps. A
+// binding whose access key is older than AccessKeyMaxAge gets a second
+// key, which replaces the first in its credentials. Once
+// AccessKeyGracePeriod has passed, apps have had time to fetch the new
+// credentials and the old key is deleted. Each binding takes at most one
+// step per call. Bindings that cannot be rotated are logged and skipped.
+// Stacks that do not belong to this broker are skipped without looking
+// at them further. Bindings that are being changed, for example by
+// another broker instance rotating them at the same time, are left until
+// the next call.
+func (s *Provider) RotateAccessKeys(ctx context.Context, now time.Time) error {
+	input := &cloudformation.DescribeStacksInput{}
+	for {
+		out, err := s.Client.DescribeStacksWithContext(ctx, input)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			return nil
+		}
+		for _, stack := range out.Stacks {
+			if !s.isOwnBindingStack(stack) {
+				continue
+			}
+			if err := s.rotateAccessKey(ctx, stack, now); err != nil {
+				s.Logger.Error("rotate-access-key", err, lager.Data{"stack": aws.StringValue(stack.StackName)})
+			}
+		}
+		if out.NextToken == nil {
+			return nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+// rotateAccessKey takes the next rotation step for a binding stack, if one
+// is due. Bindings created before their keys could be rotated are
+// migrated instead, and rotated on a later call.
+func (s *Provider) rotateAccessKey(ctx context.Context, stack *cloudformation.Stack, now time.Time) error {
+	stackName := aws.StringValue(stack.StackName)
+	switch aws.StringValue(stack.StackStatus) {
+	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusUpdateRollbackComplete:
+	default:
+		return nil
+	}
+	params := stackParamValues(stack)
+	active, ok := params[ParamActiveAccessKey]
+	if !ok {
+		return s.migrateAccessKeys(ctx, stack)
+	}
+	if stack.CreationTime == nil {
+		return nil
+	}
+	rotatedAt := *stack.CreationTime
+	if t, err := time.Parse(time.RFC3339, params[ParamAccessKeyRotatedAt]); err == nil {
+		rotatedAt = t
+	}
+
+	retiring := params[ParamRetiringAccessKey]
+	rotatedAtValue := params[ParamAccessKeyRotatedAt]
+	switch {
+	case retiring != "":
+		if now.Sub(rotatedAt) < s.AccessKeyGracePeriod {
+			return nil
+		}
+		retiring = ""
+	case s.AccessKeyMaxAge > 0 && now.Sub(rotatedAt) >= s.AccessKeyMaxAge:
+		retiring = active
+		active = nextAccessKey(active)
+		rotatedAtValue = now.UTC().Format(time.RFC3339)
+	default:
+		return nil
+	}
+
+	_, err := s.Client.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{
+		StackName:           aws.String(stackName),
+		UsePreviousTemplate: aws.Bool(true),
+		Capabilities:        capabilities,
+		Parameters: []*cloudformation.Parameter{
+			{ParameterKey: aws.String(ParamActiveAccessKey), ParameterValue: aws.String(active)},
+			{ParameterKey: aws.String(ParamRetiringAccessKey), ParameterValue: aws.String(retiring)},
+			{ParameterKey: aws.String(ParamAccessKeyRotatedAt), ParameterValue: aws.String(rotatedAtValue)},
+		},
+		ClientRequestToken: aws.String(newOperationToken(RotateOperation)),
+	})
+	if isConcurrentUpdateError(err) || IsNoUpdatesError(err) {
+		s.Logger.Info("rotate-access-key-skipped", lager.Data{"stack": stackName, "reason": err.Error()})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.Logger.Info("rotate-access-key", lager.Data{"stack": stackName, "active": active, "retiring": retiring})
+	return nil
+}
+
+// migrateAccessKeys updates a binding stack created before access keys
+// could be rotated to the current user template. The binding's access key
+// has the same logical ID in both templates, so it is kept as the active
+// key and its credentials do not change. Role bindings have no access
+// keys. The queue of a broadcast binding is left alone, as its
+// subscription settings are only recorded in its template.
+func (s *Provider) migrateAccessKeys(ctx context.Context, stack *cloudformation.Stack) error {
+	stackName := aws.StringValue(stack.StackName)
+	if findTag(stack.Tags, TagBindingType) == BindingTypeRole {
+		return nil
+	}
+	accessPolicy := findTag(stack.Tags, TagAccessPolicy)
+	if accessPolicy == "" {
+		s.Logger.Info("migrate-access-keys-skipped", lager.Data{"stack": stackName, "reason": "access policy is not recorded"})
+		return nil
+	}
+	instanceID := findTag(stack.Tags, TagInstanceId)
+	queueStack, err := s.getStack(ctx, s.getStackName(instanceID))
+	if err != nil {
+		return err
+	}
+	if isBroadcastStack(queueStack) {
+		s.Logger.Info("migrate-access-keys-skipped", lager.Data{"stack": stackName, "reason": "broadcast bindings cannot be migrated"})
+		return nil
+	}
+
+	bindingID := strings.TrimPrefix(stackName, s.ResourcePrefix+"-")
+	userTemplate := s.newUserTemplate(bindingID, findTag(queueStack.Tags, TagServiceId), instanceID, queueStack)
+	userTemplate.AccessPolicy = accessPolicy
+	userTemplate.BindingType = BindingTypeUser
+	tmpl, err := userTemplate.Build()
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{
+		StackName:    aws.String(stackName),
+		TemplateBody: aws.String(tmpl),
+		Capabilities: capabilities,
+		Parameters: []*cloudformation.Parameter{
+			{ParameterKey: aws.String(ParamActiveAccessKey), ParameterValue: aws.String("1")},
+		},
+		ClientRequestToken: aws.String(newOperationToken(RotateOperation)),
+	})
+	if isConcurrentUpdateError(err) || IsNoUpdatesError(err) {
+		s.Logger.Info("migrate-access-keys-skipped", lager.Data{"stack": stackName, "reason": err.Error()})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.Logger.Info("migrate-access-keys", lager.Data{"stack": stackName})
+	return nil
+}
+
+// isOwnBindingStack returns true for the binding stacks of this broker.
+func (s *Provider) isOwnBindingStack(stack *cloudformation.Stack) bool {
+	return strings.HasPrefix(aws.StringValue(stack.StackName), s.ResourcePrefix+"-") && isBindingStack(stack)
+}
+
+// isConcurrentUpdateError returns true if CloudFormation rejected an
+// update because the stack is already being changed, or the request token
+// has already been used.
+func isConcurrentUpdateError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return awsErr.Code() == cloudformation.ErrCodeTokenAlreadyExistsException ||
+		strings.Contains(awsErr.Message(), "_IN_PROGRESS state")
+}
+
+// isBindingStack returns true for the stacks created by Bind, which are
+// tagged with their instance.
+func isBindingStack(stack *cloudformation.Stack) bool {
+	for _, tag := range stack.Tags {
+		if aws.StringValue(tag.Key) == TagInstanceId {
+			return true
+		}
+	}
+	return false
+}
+
+func nextAccessKey(active string) string {
+	if active == "2" {
+		return "1"
+	}
+	return "2"
+}
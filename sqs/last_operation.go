@@ -0,0 +1,68 @@
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const operationRecoveryKey contextKey = "operation_recovery"
+
+// operationRecovery is what the provider found out about the state an
+// instance was left in by a failed operation, for the OSB
+// instance_usable and update_repeatable fields of last_operation.
+type operationRecovery struct {
+	recorded         bool
+	instanceUsable   bool
+	updateRepeatable bool
+}
+
+// recordOperationRecovery passes back whether the instance is still usable
+// after a failed operation, and whether a failed update can be retried,
+// when the request came through LastOperationRecovery.
+func recordOperationRecovery(ctx context.Context, instanceUsable, updateRepeatable bool) {
+	if recovery, ok := ctx.Value(operationRecoveryKey).(*operationRecovery); ok {
+		recovery.recorded = true
+		recovery.instanceUsable = instanceUsable
+		recovery.updateRepeatable = updateRepeatable
+	}
+}
+
+// LastOperationRecovery adds the OSB instance_usable and update_repeatable
+// fields to instance last_operation responses, which the API library does
+// not support. The provider records them in the request context when an
+// operation has failed.
+func LastOperationRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/last_operation") || strings.Contains(r.URL.Path, "/service_bindings/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recovery := &operationRecovery{}
+		rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), operationRecoveryKey, recovery)))
+		body := rec.body.Bytes()
+		if rec.status == http.StatusOK && recovery.recorded {
+			if withRecovery, err := addOperationRecovery(body, recovery); err == nil {
+				body = withRecovery
+			}
+		}
+		rec.writeTo(w, body)
+	})
+}
+
+// addOperationRecovery adds the recovery fields to a last_operation
+// response.
+func addOperationRecovery(body []byte, recovery *operationRecovery) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var response map[string]interface{}
+	if err := decoder.Decode(&response); err != nil {
+		return nil, err
+	}
+	response["instance_usable"] = recovery.instanceUsable
+	response["update_repeatable"] = recovery.updateRepeatable
+	return json.Marshal(response)
+}
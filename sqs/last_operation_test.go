@@ -0,0 +1,29 @@
+package sqs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LastOperationRecovery", func() {
+	var handler http.Handler
+
+	BeforeEach(func() {
+		handler = sqs.LastOperationRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"state": "failed", "description": "failed"}`))
+		}))
+	})
+
+	It("leaves responses alone when the provider records nothing", func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/service_instances/instance-1/last_operation", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"state": "failed", "description": "failed"}`))
+	})
+})
@@ -0,0 +1,104 @@
+package sqs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// The vendored SDK predates SQS message move tasks, so the requests for
+// them are built by hand. SQS accepts them over the query protocol used
+// by the rest of the vendored client.
+const (
+	opStartMessageMoveTask = "StartMessageMoveTask"
+	opListMessageMoveTasks = "ListMessageMoveTasks"
+)
+
+const (
+	MessageMoveTaskStatusRunning    = "RUNNING"
+	MessageMoveTaskStatusCompleted  = "COMPLETED"
+	MessageMoveTaskStatusCancelling = "CANCELLING"
+	MessageMoveTaskStatusCancelled  = "CANCELLED"
+	MessageMoveTaskStatusFailed     = "FAILED"
+)
+
+type StartMessageMoveTaskInput struct {
+	_ struct{} `type:"structure"`
+
+	// DestinationArn is the queue to move messages to. Messages are
+	// moved back to their original source queues when it is empty.
+	DestinationArn *string `type:"string"`
+
+	// MaxNumberOfMessagesPerSecond limits the rate messages are moved
+	// at. SQS picks the rate when it is empty.
+	MaxNumberOfMessagesPerSecond *int64 `type:"integer"`
+
+	// SourceArn is the dead letter queue to move messages from.
+	SourceArn *string `type:"string" required:"true"`
+}
+
+type StartMessageMoveTaskOutput struct {
+	_ struct{} `type:"structure"`
+
+	TaskHandle *string `type:"string"`
+}
+
+type ListMessageMoveTasksInput struct {
+	_ struct{} `type:"structure"`
+
+	MaxResults *int64 `type:"integer"`
+
+	// SourceArn is the dead letter queue the tasks moved messages from.
+	SourceArn *string `type:"string" required:"true"`
+}
+
+type ListMessageMoveTasksOutput struct {
+	_ struct{} `type:"structure"`
+
+	// Results lists the most recent tasks first.
+	Results []*MessageMoveTask `locationName:"ListMessageMoveTasksResultEntry" type:"list" flattened:"true"`
+}
+
+type MessageMoveTask struct {
+	_ struct{} `type:"structure"`
+
+	ApproximateNumberOfMessagesMoved  *int64  `type:"long"`
+	ApproximateNumberOfMessagesToMove *int64  `type:"long"`
+	DestinationArn                    *string `type:"string"`
+	FailureReason                     *string `type:"string"`
+	MaxNumberOfMessagesPerSecond      *int64  `type:"integer"`
+	SourceArn                         *string `type:"string"`
+	StartedTimestamp                  *int64  `type:"long"`
+	Status                            *string `type:"string"`
+	TaskHandle                        *string `type:"string"`
+}
+
+// MessageMoveClient adds the SQS message move task calls to an SQS
+// client.
+type MessageMoveClient struct {
+	*awssqs.SQS
+}
+
+func (c MessageMoveClient) StartMessageMoveTaskWithContext(ctx aws.Context, input *StartMessageMoveTaskInput, opts ...request.Option) (*StartMessageMoveTaskOutput, error) {
+	output := &StartMessageMoveTaskOutput{}
+	req := c.NewRequest(&request.Operation{
+		Name:       opStartMessageMoveTask,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, input, output)
+	req.SetContext(ctx)
+	req.ApplyOptions(opts...)
+	return output, req.Send()
+}
+
+func (c MessageMoveClient) ListMessageMoveTasksWithContext(ctx aws.Context, input *ListMessageMoveTasksInput, opts ...request.Option) (*ListMessageMoveTasksOutput, error) {
+	output := &ListMessageMoveTasksOutput{}
+	req := c.NewRequest(&request.Operation{
+		Name:       opListMessageMoveTasks,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, input, output)
+	req.SetContext(ctx)
+	req.ApplyOptions(opts...)
+	return output, req.Send()
+}
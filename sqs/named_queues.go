@@ -0,0 +1,143 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	ParamQueues = "Queues"
+)
+
+var namedQueueName = regexp.MustCompile(`^[a-z0-9]+([-_][a-z0-9]+)*$`)
+
+// NamedQueue is an additional queue in a service instance, alongside the
+// primary and secondary queues. It has the encryption and queue type of
+// the instance but its own settings.
+type NamedQueue struct {
+	// Name identifies the queue in the binding credentials. It is
+	// lowercase letters and digits, separated by hyphens or underscores.
+	Name string `json:"name" required:"true"`
+	// DelaySeconds is the delivery delay of the queue.
+	DelaySeconds *int `json:"delay_seconds,omitempty" minimum:"0" maximum:"900"`
+	// MaximumMessageSize is the maximum message size of the queue.
+	MaximumMessageSize *int `json:"maximum_message_size,omitempty" minimum:"1024" maximum:"262144"`
+	// MessageRetentionPeriod is the number of seconds the queue retains
+	// a message.
+	MessageRetentionPeriod *int `json:"message_retention_period,omitempty" minimum:"60" maximum:"1209600"`
+	// ReceiveMessageWaitTimeSeconds is the long polling wait time of the
+	// queue.
+	ReceiveMessageWaitTimeSeconds *int `json:"receive_message_wait_time_seconds,omitempty" minimum:"0" maximum:"20"`
+	// RedriveMaxReceiveCount is the number of times a message is
+	// delivered before being moved to the instance's secondary queue. A
+	// value of 0 disables redrive.
+	RedriveMaxReceiveCount *int `json:"redrive_max_receive_count,omitempty" minimum:"0" maximum:"1000" dlq:"true"`
+	// VisibilityTimeout is the visibility timeout of the queue.
+	VisibilityTimeout *int `json:"visibility_timeout,omitempty" minimum:"0" maximum:"43200"`
+	// FilterPolicy limits the messages published to the instance's topic
+	// that are delivered to the queue.
+	FilterPolicy json.RawMessage `json:"filter_policy,omitempty" topic:"true"`
+	// RawMessageDelivery delivers messages published to the instance's
+	// topic without the SNS envelope.
+	RawMessageDelivery *bool `json:"raw_message_delivery,omitempty" topic:"true"`
+}
+
+// LogicalID returns the ID of the queue's resource in the queue stack.
+// Its outputs are the LogicalID followed by "URL" and "ARN".
+func (queue NamedQueue) LogicalID() string {
+	id := "Queue"
+	for _, part := range strings.FieldsFunc(queue.Name, func(r rune) bool { return r == '-' || r == '_' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// QueueParams returns the queue's settings in the form used for the
+// primary queue, so the plan's limits can be checked.
+func (queue NamedQueue) QueueParams() QueueParams {
+	return QueueParams{
+		DelaySeconds:                  queue.DelaySeconds,
+		MaximumMessageSize:            queue.MaximumMessageSize,
+		MessageRetentionPeriod:        queue.MessageRetentionPeriod,
+		ReceiveMessageWaitTimeSeconds: queue.ReceiveMessageWaitTimeSeconds,
+		RedriveMaxReceiveCount:        queue.RedriveMaxReceiveCount,
+		VisibilityTimeout:             queue.VisibilityTimeout,
+	}
+}
+
+// withDefaults fills in any settings not set on the queue from defaults.
+func (queue NamedQueue) withDefaults(defaults QueueParams) NamedQueue {
+	if queue.DelaySeconds == nil {
+		queue.DelaySeconds = defaults.DelaySeconds
+	}
+	if queue.MaximumMessageSize == nil {
+		queue.MaximumMessageSize = defaults.MaximumMessageSize
+	}
+	if queue.MessageRetentionPeriod == nil {
+		queue.MessageRetentionPeriod = defaults.MessageRetentionPeriod
+	}
+	if queue.ReceiveMessageWaitTimeSeconds == nil {
+		queue.ReceiveMessageWaitTimeSeconds = defaults.ReceiveMessageWaitTimeSeconds
+	}
+	if queue.RedriveMaxReceiveCount == nil {
+		queue.RedriveMaxReceiveCount = defaults.RedriveMaxReceiveCount
+	}
+	if queue.VisibilityTimeout == nil {
+		queue.VisibilityTimeout = defaults.VisibilityTimeout
+	}
+	if queue.FilterPolicy == nil {
+		queue.FilterPolicy = defaults.FilterPolicy
+	}
+	if queue.RawMessageDelivery == nil {
+		queue.RawMessageDelivery = defaults.RawMessageDelivery
+	}
+	return queue
+}
+
+// HasRedrive returns true if the queue redrives to the secondary queue.
+func (queue NamedQueue) HasRedrive() bool {
+	return queue.RedriveMaxReceiveCount != nil && *queue.RedriveMaxReceiveCount > 0
+}
+
+// validateNamedQueues checks the queue names are usable and unique, and
+// that the full queue names fit within the SQS limit of 80 characters.
+func validateNamedQueues(queues []NamedQueue, builder QueueTemplateBuilder) error {
+	ids := map[string]string{}
+	for _, queue := range queues {
+		if !namedQueueName.MatchString(queue.Name) {
+			return invalidParams(fmt.Errorf("queues: name %q must be lowercase letters and digits separated by hyphens or underscores", queue.Name))
+		}
+		if queue.Name == "pri" || queue.Name == "sec" {
+			return invalidParams(fmt.Errorf("queues: name %q is reserved", queue.Name))
+		}
+		if other, ok := ids[queue.LogicalID()]; ok {
+			return invalidParams(fmt.Errorf("queues: names %q and %q are too similar", other, queue.Name))
+		}
+		ids[queue.LogicalID()] = queue.Name
+		if name := builder.NamedQueueName(queue); len(name) > 80 {
+			return invalidParams(fmt.Errorf("queues: name %q is too long, the queue name %s is over 80 characters", queue.Name, name))
+		}
+	}
+	return nil
+}
+
+// encodeNamedQueues returns the value of the Queues stack parameter.
+func encodeNamedQueues(queues []NamedQueue) string {
+	data, _ := json.Marshal(queues) // NamedQueue always marshals
+	return string(data)
+}
+
+// decodeNamedQueues parses the value of the Queues stack parameter.
+// Stacks from before named queues were supported have none.
+func decodeNamedQueues(value string) []NamedQueue {
+	queues := []NamedQueue{}
+	if value == "" {
+		return queues
+	}
+	if err := json.Unmarshal([]byte(value), &queues); err != nil {
+		return []NamedQueue{}
+	}
+	return queues
+}
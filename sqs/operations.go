@@ -0,0 +1,71 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	uuid "github.com/satori/go.uuid"
+)
+
+// newOperationToken returns a ClientRequestToken for a stack operation.
+// The token is also returned to the platform as the OperationData, so it
+// starts with the operation name, e.g. "provision-<uuid>".
+func newOperationToken(operation string) string {
+	return fmt.Sprintf("%s-%s", operation, uuid.NewV4())
+}
+
+// parseOperationData splits OperationData into the operation name and
+// the ClientRequestToken of the stack operation. OperationData from
+// before tokens were used is just the operation name.
+func parseOperationData(opData string) (operation string, token string) {
+	parts := strings.SplitN(opData, "-", 2)
+	if len(parts) == 1 {
+		return opData, ""
+	}
+	return parts[0], opData
+}
+
+// maxOperationEventPages limits how far back operationStatus looks
+// through a stack's events, so that polling for an operation the stack
+// has no record of does not page through its whole history every time.
+const maxOperationEventPages = 10
+
+// operationStatus returns the status the stack reached for the operation
+// started with token, or an empty string if the most recent
+// maxOperationEventPages pages of stack events have no record of the
+// operation.
+func (s *Provider) operationStatus(ctx context.Context, stack *cloudformation.Stack, token string) (string, error) {
+	stackName := aws.StringValue(stack.StackId)
+	if stackName == "" {
+		stackName = aws.StringValue(stack.StackName)
+	}
+	input := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+	}
+	for page := 1; ; page++ {
+		out, err := s.Client.DescribeStackEventsWithContext(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		if out == nil {
+			return "", nil
+		}
+		// events are returned newest first, so the first stack event
+		// for the operation is its current status
+		for _, event := range out.StackEvents {
+			if aws.StringValue(event.ClientRequestToken) != token {
+				continue
+			}
+			if aws.StringValue(event.ResourceType) == "AWS::CloudFormation::Stack" {
+				return aws.StringValue(event.ResourceStatus), nil
+			}
+		}
+		if out.NextToken == nil || page == maxOperationEventPages {
+			return "", nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
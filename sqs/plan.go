@@ -0,0 +1,252 @@
+package sqs
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+const (
+	QueueTypeStandard = "standard"
+	QueueTypeFIFO     = "fifo"
+)
+
+// PlanConfig declares how the queues for a catalog plan behave. Plans
+// are configured in the broker config under "plans", keyed by catalog
+// plan ID, so new plans can be offered without code changes.
+type PlanConfig struct {
+	// QueueType is either "standard" or "fifo".
+	QueueType string `json:"queue_type"`
+	// DeadLetterQueue controls whether a secondary queue is created for
+	// redrive to be configured. It defaults to true.
+	DeadLetterQueue *bool `json:"dead_letter_queue,omitempty"`
+	// Topic adds an SNS topic to each instance that its primary and named
+	// queues are subscribed to, so that messages published to the topic
+	// fan out to every queue.
+	Topic bool `json:"topic,omitempty"`
+	// Broadcast gives each binding its own queue subscribed to the
+	// instance's topic, so that every app bound to the instance gets
+	// every message. The instance has no queues of its own and its queue
+	// parameters apply to the binding queues. It requires Topic.
+	Broadcast bool `json:"broadcast,omitempty"`
+	// Defaults are used for any parameters not given on provision.
+	Defaults QueueParams `json:"defaults"`
+	// Maximums are upper limits for the numeric parameters.
+	Maximums QueueParams `json:"maximums"`
+	// AccessPolicies lists the access policies bindings may request, by
+	// name. All configured access policies are allowed when empty.
+	AccessPolicies []AccessPolicy `json:"access_policies,omitempty"`
+	// BindingTypes lists the binding types bindings may request. The
+	// first is used when a binding does not ask for one. Bindings are
+	// for IAM users when empty, though they may ask for IAM roles.
+	BindingTypes []BindingType `json:"binding_types,omitempty"`
+}
+
+// FIFO returns true if the plan provisions FIFO queues.
+func (plan PlanConfig) FIFO() bool {
+	return plan.QueueType == QueueTypeFIFO
+}
+
+// HasDeadLetterQueue returns true if redrive may be configured. The
+// binding queues of broadcast plans have no dead letter queue.
+func (plan PlanConfig) HasDeadLetterQueue() bool {
+	return !plan.Broadcast && (plan.DeadLetterQueue == nil || *plan.DeadLetterQueue)
+}
+
+// Validate checks the plan configuration is usable.
+func (plan PlanConfig) Validate() error {
+	switch plan.QueueType {
+	case QueueTypeStandard, QueueTypeFIFO:
+	default:
+		return fmt.Errorf("queue_type must be one of %q or %q", QueueTypeStandard, QueueTypeFIFO)
+	}
+	for _, bindingType := range plan.BindingTypes {
+		switch bindingType {
+		case BindingTypeUser, BindingTypeRole:
+		default:
+			return fmt.Errorf("binding_types must be %q or %q", BindingTypeUser, BindingTypeRole)
+		}
+	}
+	if !plan.HasDeadLetterQueue() && plan.Defaults.RedriveMaxReceiveCount != nil && *plan.Defaults.RedriveMaxReceiveCount != 0 {
+		return fmt.Errorf("defaults.redrive_max_receive_count cannot be set without a dead letter queue")
+	}
+	if plan.Broadcast && !plan.Topic {
+		return fmt.Errorf("broadcast plans must have a topic")
+	}
+	if !plan.Topic && (plan.Defaults.FilterPolicy != nil || plan.Defaults.RawMessageDelivery != nil) {
+		return fmt.Errorf("defaults.filter_policy and defaults.raw_message_delivery cannot be set without a topic")
+	}
+	if err := validateFIFOParams(plan.Defaults, plan.FIFO(), nil); err != nil {
+		return err
+	}
+	return plan.CheckParams(plan.Defaults)
+}
+
+// ValidateAccessPolicies checks the access policies the plan lists are
+// among those configured.
+func (plan PlanConfig) ValidateAccessPolicies(policies map[string]AccessPolicyConfig) error {
+	for _, policy := range plan.AccessPolicies {
+		if _, ok := policies[policy]; !ok {
+			return fmt.Errorf("access_policies: unknown access policy %#v", policy)
+		}
+	}
+	return nil
+}
+
+// ApplyDefaults fills in any parameters not set in params, or on its
+// named queues, from the plan defaults.
+func (plan PlanConfig) ApplyDefaults(params QueueParams) QueueParams {
+	v := reflect.ValueOf(&params).Elem()
+	defaults := reflect.ValueOf(plan.Defaults)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsNil() {
+			v.Field(i).Set(defaults.Field(i))
+		}
+	}
+	params.Queues = plan.applyQueueDefaults(params.Queues)
+	return params
+}
+
+// overlayParams returns current with the parameters set in params
+// replacing its values.
+func overlayParams(current, params QueueParams) QueueParams {
+	v := reflect.ValueOf(&current).Elem()
+	changes := reflect.ValueOf(params)
+	for i := 0; i < v.NumField(); i++ {
+		if !changes.Field(i).IsNil() {
+			v.Field(i).Set(changes.Field(i))
+		}
+	}
+	return current
+}
+
+// applyQueueDefaults fills in any settings not set on the named queues
+// from the plan defaults.
+func (plan PlanConfig) applyQueueDefaults(queues []NamedQueue) []NamedQueue {
+	if queues == nil {
+		return nil
+	}
+	withDefaults := make([]NamedQueue, len(queues))
+	for i, queue := range queues {
+		withDefaults[i] = queue.withDefaults(plan.Defaults)
+	}
+	return withDefaults
+}
+
+// CheckParams enforces the plan's maximums and dead letter queue setting,
+// including on the named queues.
+func (plan PlanConfig) CheckParams(params QueueParams) error {
+	for _, queue := range params.Queues {
+		if err := plan.CheckParams(queue.QueueParams()); err != nil {
+			return err
+		}
+	}
+	if !plan.HasDeadLetterQueue() && params.RedriveMaxReceiveCount != nil && *params.RedriveMaxReceiveCount != 0 {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("redrive_max_receive_count is not supported by this plan"),
+			http.StatusBadRequest,
+			"plan-limit-exceeded",
+		)
+	}
+	v := reflect.ValueOf(params)
+	maximums := reflect.ValueOf(plan.Maximums)
+	for i := 0; i < v.NumField(); i++ {
+		value, ok := v.Field(i).Interface().(*int)
+		if !ok || value == nil {
+			continue
+		}
+		maximum := maximums.Field(i).Interface().(*int)
+		if maximum != nil && *value > *maximum {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("%s must be at most %d for this plan", name, *maximum),
+				http.StatusBadRequest,
+				"plan-limit-exceeded",
+			)
+		}
+	}
+	return nil
+}
+
+// CheckAccessPolicy returns an error if bindings on the plan may not use
+// the given access policy.
+func (plan PlanConfig) CheckAccessPolicy(policy AccessPolicy) error {
+	if len(plan.AccessPolicies) == 0 {
+		return nil
+	}
+	if policy == "" {
+		policy = AccessPolicyFull
+	}
+	for _, allowed := range plan.AccessPolicies {
+		if allowed == policy {
+			return nil
+		}
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("access_policy %q is not allowed for this plan", policy),
+		http.StatusBadRequest,
+		"access-policy-not-allowed",
+	)
+}
+
+// DefaultBindingType returns the binding type of bindings that do not ask
+// for one.
+func (plan PlanConfig) DefaultBindingType() BindingType {
+	if len(plan.BindingTypes) == 0 {
+		return BindingTypeUser
+	}
+	return plan.BindingTypes[0]
+}
+
+// CheckBindingType returns an error if bindings on the plan may not use
+// the given binding type.
+func (plan PlanConfig) CheckBindingType(bindingType BindingType) error {
+	if len(plan.BindingTypes) == 0 {
+		return nil
+	}
+	for _, allowed := range plan.BindingTypes {
+		if allowed == bindingType {
+			return nil
+		}
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("binding_type %q is not allowed for this plan", bindingType),
+		http.StatusBadRequest,
+		"binding-type-not-allowed",
+	)
+}
+
+// getPlan returns the configured behaviour for a catalog plan. Plans
+// without configuration fall back to treating a plan named "fifo" as a
+// FIFO queue and everything else as a standard queue.
+func (s *Provider) getPlan(plan domain.ServicePlan) PlanConfig {
+	if config, ok := s.Plans[plan.ID]; ok {
+		return config
+	}
+	if plan.Name == QueueTypeFIFO {
+		return PlanConfig{QueueType: QueueTypeFIFO}
+	}
+	return PlanConfig{QueueType: QueueTypeStandard}
+}
+
+// catalogPlan returns the catalog plan with the given ID, for requests
+// that only give the plan ID, so that unconfigured plans are resolved by
+// name in the same way as on provision. Plans missing from the catalog
+// are returned with only their ID.
+func (s *Provider) catalogPlan(serviceID, planID string) domain.ServicePlan {
+	for _, service := range s.Catalog {
+		if service.ID != serviceID {
+			continue
+		}
+		for _, plan := range service.Plans {
+			if plan.ID == planID {
+				return plan
+			}
+		}
+	}
+	return domain.ServicePlan{ID: planID}
+}
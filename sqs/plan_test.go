@@ -0,0 +1,124 @@
+package sqs_test
+
+import (
+	"encoding/json"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("PlanConfig", func() {
+	var plan sqs.PlanConfig
+
+	BeforeEach(func() {
+		plan = sqs.PlanConfig{}
+		Expect(json.Unmarshal([]byte(`{
+			"queue_type": "standard",
+			"dead_letter_queue": true,
+			"defaults": {"message_retention_period": 1209600},
+			"maximums": {"message_retention_period": 1209600, "maximum_message_size": 65536},
+			"access_policies": ["producer", "consumer"]
+		}`), &plan)).To(Succeed())
+	})
+
+	It("should be valid", func() {
+		Expect(plan.Validate()).To(Succeed())
+	})
+
+	It("should reject unknown queue types", func() {
+		plan.QueueType = "lifo"
+		Expect(plan.Validate()).To(MatchError(ContainSubstring("queue_type")))
+	})
+
+	It("should reject unknown access policies", func() {
+		plan.AccessPolicies = []sqs.AccessPolicy{"bananas"}
+		Expect(plan.ValidateAccessPolicies(sqs.DefaultAccessPolicies)).To(MatchError(`access_policies: unknown access policy "bananas"`))
+		plan.AccessPolicies = []sqs.AccessPolicy{sqs.AccessPolicyMonitor}
+		Expect(plan.ValidateAccessPolicies(sqs.DefaultAccessPolicies)).To(Succeed())
+	})
+
+	It("should reject defaults above the maximums", func() {
+		plan.Defaults.MaximumMessageSize = aws.Int(262144)
+		Expect(plan.Validate()).To(MatchError(ContainSubstring("maximum_message_size")))
+	})
+
+	It("should reject FIFO defaults for standard queues", func() {
+		plan.Defaults.ContentBasedDeduplication = aws.Bool(true)
+		Expect(plan.Validate()).To(HaveOccurred())
+	})
+
+	It("should reject topic defaults without a topic", func() {
+		plan.Defaults.RawMessageDelivery = aws.Bool(true)
+		Expect(plan.Validate()).To(MatchError(ContainSubstring("without a topic")))
+		plan.Topic = true
+		Expect(plan.Validate()).To(Succeed())
+	})
+
+	It("should reject broadcast plans without a topic", func() {
+		plan.Broadcast = true
+		Expect(plan.Validate()).To(MatchError(ContainSubstring("broadcast plans must have a topic")))
+		plan.Topic = true
+		Expect(plan.Validate()).To(Succeed())
+		Expect(plan.HasDeadLetterQueue()).To(BeFalse())
+	})
+
+	It("should fill in missing parameters from the defaults", func() {
+		params := plan.ApplyDefaults(sqs.QueueParams{DelaySeconds: aws.Int(5)})
+		Expect(params.DelaySeconds).To(Equal(aws.Int(5)))
+		Expect(params.MessageRetentionPeriod).To(Equal(aws.Int(1209600)))
+		Expect(params.VisibilityTimeout).To(BeNil())
+	})
+
+	It("should not override given parameters with the defaults", func() {
+		params := plan.ApplyDefaults(sqs.QueueParams{MessageRetentionPeriod: aws.Int(60)})
+		Expect(params.MessageRetentionPeriod).To(Equal(aws.Int(60)))
+	})
+
+	It("should enforce the maximums", func() {
+		Expect(plan.CheckParams(sqs.QueueParams{MaximumMessageSize: aws.Int(65536)})).To(Succeed())
+		err := plan.CheckParams(sqs.QueueParams{MaximumMessageSize: aws.Int(65537)})
+		Expect(err).To(MatchError("maximum_message_size must be at most 65536 for this plan"))
+		castErrResponse, ok := err.(*brokerapi.FailureResponse)
+		Expect(ok).To(BeTrue())
+		Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+	})
+
+	Context("without a dead letter queue", func() {
+		BeforeEach(func() {
+			plan.DeadLetterQueue = aws.Bool(false)
+		})
+
+		It("should reject redrive", func() {
+			Expect(plan.CheckParams(sqs.QueueParams{RedriveMaxReceiveCount: aws.Int(3)})).To(HaveOccurred())
+			Expect(plan.CheckParams(sqs.QueueParams{RedriveMaxReceiveCount: aws.Int(0)})).To(Succeed())
+		})
+	})
+
+	It("should only allow the listed access policies", func() {
+		Expect(plan.CheckAccessPolicy(sqs.AccessPolicyProducer)).To(Succeed())
+		Expect(plan.CheckAccessPolicy(sqs.AccessPolicyFull)).To(HaveOccurred())
+		Expect(plan.CheckAccessPolicy("")).To(HaveOccurred())
+	})
+
+	It("should allow any access policy when none are listed", func() {
+		plan.AccessPolicies = nil
+		Expect(plan.CheckAccessPolicy(sqs.AccessPolicyFull)).To(Succeed())
+	})
+
+	It("should reject unknown binding types", func() {
+		plan.BindingTypes = []sqs.BindingType{"group"}
+		Expect(plan.Validate()).To(MatchError(ContainSubstring("binding_types")))
+	})
+
+	It("should default to the first listed binding type", func() {
+		Expect(plan.DefaultBindingType()).To(Equal(sqs.BindingTypeUser))
+		Expect(plan.CheckBindingType(sqs.BindingTypeRole)).To(Succeed())
+		plan.BindingTypes = []sqs.BindingType{sqs.BindingTypeRole, sqs.BindingTypeUser}
+		Expect(plan.DefaultBindingType()).To(Equal(sqs.BindingTypeRole))
+		plan.BindingTypes = []sqs.BindingType{sqs.BindingTypeRole}
+		Expect(plan.CheckBindingType(sqs.BindingTypeUser)).To(MatchError(`binding_type "user" is not allowed for this plan`))
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strings"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/pivotal-cf/brokerapi/domain"
 	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	uuid "github.com/satori/go.uuid"
 )
 
 var (
@@ -27,10 +29,14 @@ var (
 	}
 	// NoExistErrMatch is a string to match if stack does not exist
 	NoExistErrMatch = "does not exist"
+	// NoUpdatesErrMatch is a string to match if an update would not change the stack
+	NoUpdatesErrMatch = "No updates"
 	// ErrStackNotFound returned when stack does not exist, or has been deleted
 	ErrStackNotFound = fmt.Errorf("cloudformation stack does not exist")
+	// ErrOperationDeadlineExceeded indicates that a syncronous operation took too long
+	ErrOperationDeadlineExceeded = fmt.Errorf("timeout waiting for the stack to reach a success or failed state")
 	// ErrBindingDeadlineExeceeded indicates that syncronous binding took too long
-	ErrBindingDeadlineExceeded = fmt.Errorf("timeout waiting for the binding stack to reach a success or failed state")
+	ErrBindingDeadlineExceeded = ErrOperationDeadlineExceeded
 	// PollingInterval is the duration between calls to check state when waiting for stack status to complete
 	PollingInterval      = time.Second * 5
 	ProvisionOperation   = "provision"
@@ -38,6 +44,8 @@ var (
 	UpdateOperation      = "update"
 	BindOperation        = "bind"
 	UnbindOperation      = "unbind"
+	RedriveOperation     = "redrive"
+	RotateOperation      = "rotate"
 )
 
 const (
@@ -46,38 +54,39 @@ const (
 	TagName           = "Name"
 	TagService        = "Service"
 	TagServiceId      = "ServiceID"
+	TagPlanId         = "PlanID"
+	TagInstanceId     = "InstanceID"
+	TagAccessPolicy   = "AccessPolicy"
+	TagBindingType    = "BindingType"
+	// TagBindingParameters is a hash of a binding's subscription settings
+	// on broadcast plans, set only when it has some.
+	TagBindingParameters = "BindingParameters"
 )
 
 type Provider struct {
-	Environment          string // Name of environment to tag resources with
-	Client               Client // AWS SDK compatible client
-	ResourcePrefix       string // AWS resources with be named with this prefix
-	AdditionalUserPolicy string // IAM users created on bind will have this policy attached
-	PermissionsBoundary  string // IAM users created on bind will have this boundary
-	Timeout              time.Duration
-	Logger               lager.Logger
+	Environment           string                        // Name of environment to tag resources with
+	Client                Client                        // AWS SDK compatible client
+	ResourcePrefix        string                        // AWS resources with be named with this prefix
+	AdditionalUserPolicy  string                        // IAM users created on bind will have this policy attached
+	PermissionsBoundary   string                        // IAM users created on bind will have this boundary
+	AllowedKMSKeyARNs     []string                      // existing KMS keys tenants may choose to encrypt queues with
+	AllowedSenderAccounts []string                      // AWS accounts tenants may allow to send to their queues
+	DenyInsecureTransport bool                          // queue policies deny requests not made over TLS
+	RestrictPrincipals    bool                          // queue policies deny principals other than binding users and the broker
+	BrokerPrincipalARN    string                        // the broker's own IAM role or user, exempt from RestrictPrincipals
+	Tags                  map[string]string             // added to every stack, and so every resource, alongside tenant tags
+	RoleTrustPrincipalARN string                        // may assume the IAM roles of role bindings with their external ID
+	AccessPolicies        map[string]AccessPolicyConfig // access policies bindings may request, by name, DefaultAccessPolicies if nil
+	Plans                 map[string]PlanConfig         // behaviour of each catalog plan, by plan ID
+	Catalog               []domain.Service              // catalog services, to look up the plans of binds, which only give the plan ID
+	Timeout               time.Duration                 // longest to wait for stacks when the platform does not allow async operations
+	AccessKeyMaxAge       time.Duration                 // age at which binding access keys are rotated, never if zero
+	AccessKeyGracePeriod  time.Duration                 // how long the replaced access key keeps working after rotation
+	Logger                lager.Logger
 }
 
 func (s *Provider) Provision(ctx context.Context, provisionData provideriface.ProvisionData) (*domain.ProvisionedServiceSpec, error) {
-	queueTemplate := QueueTemplateBuilder{}
-	queueTemplate.QueueName = s.getStackName(provisionData.InstanceID)
-
-	queueTemplate.Tags = map[string]string{
-		TagName:           provisionData.InstanceID,
-		TagService:        "sqs",
-		TagServiceId:      provisionData.Details.ServiceID,
-		TagEnvironment:    s.Environment,
-		TagCostAllocation: provisionData.InstanceID,
-	}
-	if provisionData.Plan.Name == "fifo" {
-		queueTemplate.FIFOQueue = true
-	}
-
-	tmpl, err := queueTemplate.Build()
-	if err != nil {
-		return nil, err
-	}
-
+	plan := s.getPlan(provisionData.Plan)
 	params := QueueParams{}
 	if provisionData.Details.RawParameters != nil {
 		decoder := json.NewDecoder(bytes.NewReader(provisionData.Details.RawParameters))
@@ -90,26 +99,114 @@ func (s *Provider) Provision(ctx context.Context, provisionData provideriface.Pr
 			)
 		}
 	}
+	if err := plan.InstanceSchema().Validate(provisionData.Details.RawParameters); err != nil {
+		return nil, err
+	}
+	if err := plan.CheckParams(params); err != nil {
+		return nil, err
+	}
+	params = plan.ApplyDefaults(params)
+	if err := s.validateEncryption(params); err != nil {
+		return nil, err
+	}
+	if err := s.validateAllowedSenders(params.AllowedSenders); err != nil {
+		return nil, err
+	}
+	if err := validateAlarms(params.Alarms); err != nil {
+		return nil, err
+	}
+	if err := s.validateTags(params.Tags); err != nil {
+		return nil, err
+	}
+	if err := validateFIFOParams(params, plan.FIFO(), nil); err != nil {
+		return nil, err
+	}
+	tmpl, err := s.buildQueueTemplate(provisionData.InstanceID, provisionData.Details.ServiceID, plan, plan.HasDeadLetterQueue(), params)
+	if err != nil {
+		return nil, err
+	}
 
+	stackName := s.getStackName(provisionData.InstanceID)
+	tags := stackTags(provisionData.Details.ServiceID, provisionData.Plan.ID)
+	token := newOperationToken(ProvisionOperation)
 	_, err = s.Client.CreateStackWithContext(ctx, &cloudformation.CreateStackInput{
-		Capabilities: capabilities,
-		TemplateBody: aws.String(tmpl),
-		StackName:    aws.String(s.getStackName(provisionData.InstanceID)),
-		Parameters:   params.CreateParams(),
+		Capabilities:       capabilities,
+		TemplateBody:       aws.String(tmpl),
+		StackName:          aws.String(stackName),
+		Parameters:         params.CreateParams(),
+		Tags:               s.propagatedTags(tags, mergeTags(s.resourceTags(provisionData.InstanceID, provisionData.Details.ServiceID, provisionData.InstanceID), provisionContextTags(provisionData.Details)), params.Tags),
+		ClientRequestToken: aws.String(token),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "AlreadyExistsException" {
-			return nil, apiresponses.ErrInstanceAlreadyExists
+			return s.existingInstance(ctx, stackName, tmpl, tags, params)
 		}
 		return nil, err
 	}
 
 	return &domain.ProvisionedServiceSpec{
-		OperationData: ProvisionOperation,
+		OperationData: token,
 		IsAsync:       true,
 	}, nil
 }
 
+// buildQueueTemplate renders the queue stack template for an instance on
+// the given plan with the named queues and filter policy in params,
+// leaving out the secondary queue unless it is needed as a dead letter
+// queue.
+func (s *Provider) buildQueueTemplate(instanceID, serviceID string, plan PlanConfig, secondaryQueue bool, params QueueParams) (string, error) {
+	queueTemplate := QueueTemplateBuilder{}
+	queueTemplate.QueueName = s.getStackName(instanceID)
+
+	queueTemplate.Tags = s.resourceTags(instanceID, serviceID, instanceID)
+	queueTemplate.FIFOQueue = plan.FIFO()
+	queueTemplate.SingleQueue = !secondaryQueue
+	queueTemplate.Queues = params.Queues
+	queueTemplate.Topic = plan.Topic
+	queueTemplate.Broadcast = plan.Broadcast
+	queueTemplate.FilterPolicy = filterPolicyJSON(params.FilterPolicy)
+	queueTemplate.AllowedSenders = params.AllowedSenders
+	queueTemplate.Alarms = params.Alarms
+	queueTemplate.DenyInsecureTransport = s.DenyInsecureTransport
+	queueTemplate.RestrictPrincipals = s.RestrictPrincipals
+	queueTemplate.ResourcePrefix = s.ResourcePrefix
+	queueTemplate.BrokerPrincipalARN = s.BrokerPrincipalARN
+	if err := validateNamedQueues(params.Queues, queueTemplate); err != nil {
+		return "", err
+	}
+
+	return queueTemplate.Build()
+}
+
+// stackTags returns the tags recorded on a queue stack so GetInstance can
+// tell which service and plan it belongs to. The stack is created with
+// these and the rest of its propagatedTags.
+func stackTags(serviceID, planID string) []*cloudformation.Tag {
+	return []*cloudformation.Tag{
+		{Key: aws.String(TagServiceId), Value: aws.String(serviceID)},
+		{Key: aws.String(TagPlanId), Value: aws.String(planID)},
+	}
+}
+
+// bindingStackTags returns the tags recorded on a binding stack so a
+// repeated bind request can be recognised. The stack is created with
+// these and the rest of its propagatedTags.
+func bindingStackTags(instanceID string, userTemplate UserTemplateBuilder) []*cloudformation.Tag {
+	accessPolicy := userTemplate.AccessPolicy
+	if accessPolicy == "" {
+		accessPolicy = AccessPolicyFull
+	}
+	tags := []*cloudformation.Tag{
+		{Key: aws.String(TagInstanceId), Value: aws.String(instanceID)},
+		{Key: aws.String(TagAccessPolicy), Value: aws.String(accessPolicy)},
+		{Key: aws.String(TagBindingType), Value: aws.String(userTemplate.BindingType)},
+	}
+	if params := userTemplate.parametersHash(); params != "" {
+		tags = append(tags, &cloudformation.Tag{Key: aws.String(TagBindingParameters), Value: aws.String(params)})
+	}
+	return tags
+}
+
 func (s *Provider) Deprovision(ctx context.Context, deprovisionData provideriface.DeprovisionData) (*domain.DeprovisionServiceSpec, error) {
 	stackName := s.getStackName(deprovisionData.InstanceID)
 	stack, err := s.getStack(ctx, stackName)
@@ -129,9 +226,12 @@ func (s *Provider) Deprovision(ctx context.Context, deprovisionData providerifac
 		return &domain.DeprovisionServiceSpec{}, nil
 	}
 	// trigger a delete unless we're already in a deleting state
+	opData := DeprovisionOperation
 	if *stack.StackStatus != cloudformation.StackStatusDeleteInProgress {
+		opData = newOperationToken(DeprovisionOperation)
 		_, err := s.Client.DeleteStackWithContext(ctx, &cloudformation.DeleteStackInput{
-			StackName: aws.String(stackName),
+			StackName:          aws.String(stackName),
+			ClientRequestToken: aws.String(opData),
 		})
 		if err != nil {
 			return nil, err
@@ -139,7 +239,7 @@ func (s *Provider) Deprovision(ctx context.Context, deprovisionData providerifac
 	}
 
 	return &domain.DeprovisionServiceSpec{
-		OperationData: DeprovisionOperation,
+		OperationData: opData,
 		IsAsync:       true,
 	}, nil
 }
@@ -156,23 +256,7 @@ func (s *Provider) Bind(ctx context.Context, bindData provideriface.BindData) (*
 		return nil, err // should this be async and checked later
 	}
 
-	userTemplate := UserTemplateBuilder{
-		BindingID:            bindData.BindingID,
-		ResourcePrefix:       s.ResourcePrefix,
-		AdditionalUserPolicy: s.AdditionalUserPolicy,
-		PermissionsBoundary:  s.PermissionsBoundary,
-		Tags: map[string]string{
-			TagName:           bindData.BindingID,
-			TagService:        "sqs",
-			TagServiceId:      bindData.Details.ServiceID,
-			TagEnvironment:    s.Environment,
-			TagCostAllocation: bindData.InstanceID,
-		},
-		PrimaryQueueARN:   getStackOutput(queueStack, OutputPrimaryQueueARN),
-		PrimaryQueueURL:   getStackOutput(queueStack, OutputPrimaryQueueURL),
-		SecondaryQueueARN: getStackOutput(queueStack, OutputSecondaryQueueARN),
-		SecondaryQueueURL: getStackOutput(queueStack, OutputSecondaryQueueURL),
-	}
+	userTemplate := s.newUserTemplate(bindData.BindingID, bindData.Details.ServiceID, bindData.InstanceID, queueStack)
 
 	if bindData.Details.RawParameters != nil {
 		decoder := json.NewDecoder(bytes.NewReader(bindData.Details.RawParameters))
@@ -185,6 +269,52 @@ func (s *Provider) Bind(ctx context.Context, bindData provideriface.BindData) (*
 			)
 		}
 	}
+	plan := s.getPlan(s.catalogPlan(bindData.Details.ServiceID, bindData.Details.PlanID))
+	if err := s.bindingSchema(plan).Validate(bindData.Details.RawParameters); err != nil {
+		return nil, err
+	}
+	// a binding that rotates the credentials of its predecessor gets the
+	// same access, so both sets of credentials work until the predecessor
+	// is unbound
+	if predecessorID := PredecessorBindingID(ctx); predecessorID != "" {
+		var rawParams map[string]json.RawMessage
+		json.Unmarshal(bindData.Details.RawParameters, &rawParams) // already checked to be an object
+		if len(rawParams) > 0 {
+			return nil, invalidParams(fmt.Errorf("parameters cannot be given when rotating a binding, those of the predecessor binding are used"))
+		}
+		accessPolicy, bindingType, err := s.predecessorAccess(ctx, bindData.InstanceID, predecessorID, plan)
+		if err != nil {
+			return nil, err
+		}
+		userTemplate.AccessPolicy = accessPolicy
+		userTemplate.BindingType = bindingType
+	}
+	if userTemplate.BindingType == "" {
+		userTemplate.BindingType = plan.DefaultBindingType()
+	}
+	if plan.Broadcast {
+		userTemplate.BroadcastQueue = s.broadcastQueue(bindData.BindingID, queueStack)
+		userTemplate.DenyInsecureTransport = s.DenyInsecureTransport
+		userTemplate.RestrictPrincipals = s.RestrictPrincipals
+		userTemplate.BrokerPrincipalARN = s.BrokerPrincipalARN
+	}
+	if err := plan.CheckAccessPolicy(userTemplate.AccessPolicy); err != nil {
+		return nil, err
+	}
+	if err := plan.CheckBindingType(userTemplate.BindingType); err != nil {
+		return nil, err
+	}
+	if userTemplate.UsesRole() {
+		if s.RoleTrustPrincipalARN == "" {
+			return nil, apiresponses.NewFailureResponse(
+				fmt.Errorf("role bindings are not enabled on this broker"),
+				http.StatusBadRequest,
+				"binding-type-not-allowed",
+			)
+		}
+		userTemplate.RoleTrustPrincipalARN = s.RoleTrustPrincipalARN
+		userTemplate.ExternalID = uuid.NewV4().String()
+	}
 
 	tmpl, err := userTemplate.Build()
 	if err != nil {
@@ -192,43 +322,74 @@ func (s *Provider) Bind(ctx context.Context, bindData provideriface.BindData) (*
 	}
 
 	bindingStackName := s.getStackName(bindData.BindingID)
+	tags := bindingStackTags(bindData.InstanceID, userTemplate)
+	token := newOperationToken(BindOperation)
 	_, err = s.Client.CreateStackWithContext(ctx, &cloudformation.CreateStackInput{
-		Capabilities: capabilities,
-		TemplateBody: aws.String(tmpl),
-		StackName:    aws.String(bindingStackName),
+		Capabilities:       capabilities,
+		TemplateBody:       aws.String(tmpl),
+		StackName:          aws.String(bindingStackName),
+		Tags:               s.propagatedTags(tags, mergeTags(userTemplate.Tags, bindContextTags(bindData.Details)), decodeTags(stackParamValues(queueStack)[ParamTags])),
+		ClientRequestToken: aws.String(token),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "AlreadyExistsException" {
-			return nil, apiresponses.ErrBindingAlreadyExists
+			return s.existingBinding(ctx, bindingStackName, tags, bindData.AsyncAllowed)
 		}
 		return nil, err
 	}
 
 	if !bindData.AsyncAllowed {
-		return s.getBindingSync(ctx, bindingStackName)
+		return s.getBindingSync(ctx, bindingStackName, token)
 	}
 
 	return &domain.Binding{
 		IsAsync:       true,
-		OperationData: BindOperation,
+		OperationData: token,
 	}, nil
 }
 
+// newUserTemplate returns the user template for a binding to the queues of
+// queueStack, before the binding's parameters are applied.
+func (s *Provider) newUserTemplate(bindingID, serviceID, instanceID string, queueStack *cloudformation.Stack) UserTemplateBuilder {
+	userTemplate := UserTemplateBuilder{
+		BindingID:            bindingID,
+		ResourcePrefix:       s.ResourcePrefix,
+		AdditionalUserPolicy: s.AdditionalUserPolicy,
+		PermissionsBoundary:  s.PermissionsBoundary,
+		Tags:                 s.resourceTags(bindingID, serviceID, instanceID),
+		PrimaryQueueARN:      getStackOutput(queueStack, OutputPrimaryQueueARN),
+		PrimaryQueueURL:      getStackOutput(queueStack, OutputPrimaryQueueURL),
+		SecondaryQueueARN:    getStackOutput(queueStack, OutputSecondaryQueueARN),
+		SecondaryQueueURL:    getStackOutput(queueStack, OutputSecondaryQueueURL),
+		KMSKeyARN:            getStackOutput(queueStack, OutputKMSKeyARN),
+		TopicARN:             getStackOutput(queueStack, OutputTopicARN),
+		AccessPolicies:       s.accessPolicies(),
+	}
+	for _, queue := range decodeNamedQueues(stackParamValues(queueStack)[ParamQueues]) {
+		if userTemplate.NamedQueueURLs == nil {
+			userTemplate.NamedQueueURLs = map[string]string{}
+		}
+		userTemplate.NamedQueueURLs[queue.Name] = getStackOutput(queueStack, queue.LogicalID()+"URL")
+		userTemplate.NamedQueueARNs = append(userTemplate.NamedQueueARNs, getStackOutput(queueStack, queue.LogicalID()+"ARN"))
+	}
+	return userTemplate
+}
+
 // getBindingSync will fetch the binding credentials for the given binding
 // cloudformation stack name. It will block until the stack reports it is
 // in either a success or failed state.
-func (s *Provider) getBindingSync(ctx context.Context, bindingStackName string) (*domain.Binding, error) {
+func (s *Provider) getBindingSync(ctx context.Context, bindingStackName string, opData string) (*domain.Binding, error) {
 
 	// catch and attempt to tidy up failed binding stacks
 	destroyFailedBinding := true
 	defer func() {
 		if destroyFailedBinding {
-			go s.tryDestroyStack(bindingStackName)
+			go s.tryDestroyStack(bindingStackName, UnbindOperation)
 		}
 	}()
 
 	// wait for the stack to settle
-	err := s.waitForBindingOperationComplete(ctx, bindingStackName)
+	err := s.waitForBindingOperationComplete(ctx, bindingStackName, opData)
 	if err != nil {
 		return nil, err
 	}
@@ -249,13 +410,27 @@ func (s *Provider) getBindingSync(ctx context.Context, bindingStackName string)
 // waitForBindingOperationComplete will block until the cloudformation
 // stack referenced by name is in a success or failed state, the context is
 // canceled or the is an error returned from cloudformation
-func (s *Provider) waitForBindingOperationComplete(ctx context.Context, stackName string) error {
+func (s *Provider) waitForBindingOperationComplete(ctx context.Context, stackName string, opData string) error {
+	return s.waitForOperation(ctx, func(ctx context.Context) (*domain.LastOperation, error) {
+		return s.lastBindingOperation(ctx, stackName, opData)
+	})
+}
+
+// waitForOperation will block until poll reports a success or failed
+// state, the context is canceled, Timeout (if set) has passed or there
+// is an error returned from cloudformation
+func (s *Provider) waitForOperation(ctx context.Context, poll func(context.Context) (*domain.LastOperation, error)) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
 	for {
 		select {
 		case <-ctx.Done():
-			return ErrBindingDeadlineExceeded
+			return ErrOperationDeadlineExceeded
 		case <-time.After(PollingInterval):
-			lastOperation, err := s.lastBindingOperation(ctx, stackName, BindOperation)
+			lastOperation, err := poll(ctx)
 			if err != nil {
 				return err
 			}
@@ -271,6 +446,35 @@ func (s *Provider) waitForBindingOperationComplete(ctx context.Context, stackNam
 	}
 }
 
+// WaitForProvision blocks until the provision operation identified by
+// opData completes, for platforms that do not allow asynchronous
+// operations. A queue stack this broker started creating is removed if
+// creation fails.
+func (s *Provider) WaitForProvision(ctx context.Context, instanceID string, opData string) error {
+	err := s.waitForOperation(ctx, func(ctx context.Context) (*domain.LastOperation, error) {
+		return s.LastOperation(ctx, provideriface.LastOperationData{
+			InstanceID:  instanceID,
+			PollDetails: domain.PollDetails{OperationData: opData},
+		})
+	})
+	if _, token := parseOperationData(opData); err != nil && token != "" {
+		go s.tryDestroyStack(s.getStackName(instanceID), DeprovisionOperation)
+	}
+	return err
+}
+
+// WaitForDeprovision blocks until the deprovision operation identified by
+// opData completes, for platforms that do not allow asynchronous
+// operations.
+func (s *Provider) WaitForDeprovision(ctx context.Context, instanceID string, opData string) error {
+	return s.waitForOperation(ctx, func(ctx context.Context) (*domain.LastOperation, error) {
+		return s.LastOperation(ctx, provideriface.LastOperationData{
+			InstanceID:  instanceID,
+			PollDetails: domain.PollDetails{OperationData: opData},
+		})
+	})
+}
+
 func (s *Provider) Unbind(ctx context.Context, unbindData provideriface.UnbindData) (*domain.UnbindSpec, error) {
 	stackName := s.getStackName(unbindData.BindingID)
 	stack, err := s.getStack(ctx, stackName)
@@ -290,50 +494,372 @@ func (s *Provider) Unbind(ctx context.Context, unbindData provideriface.UnbindDa
 		return &domain.UnbindSpec{}, nil
 	}
 	// trigger a delete unless we're already in a deleting state
+	opData := UnbindOperation
 	if *stack.StackStatus != cloudformation.StackStatusDeleteInProgress {
+		opData = newOperationToken(UnbindOperation)
 		_, err := s.Client.DeleteStackWithContext(ctx, &cloudformation.DeleteStackInput{
-			StackName: aws.String(stackName),
+			StackName:          aws.String(stackName),
+			ClientRequestToken: aws.String(opData),
 		})
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if !unbindData.AsyncAllowed {
+		if err := s.waitForBindingOperationComplete(ctx, stackName, opData); err != nil {
+			return nil, err
+		}
+		return &domain.UnbindSpec{}, nil
+	}
+
 	return &domain.UnbindSpec{
-		OperationData: UnbindOperation,
-		IsAsync:       unbindData.AsyncAllowed,
+		OperationData: opData,
+		IsAsync:       true,
 	}, nil
 }
 
 func (s *Provider) Update(ctx context.Context, updateData provideriface.UpdateData) (*domain.UpdateServiceSpec, error) {
 	params := QueueParams{}
+	redrive := RedriveParams{}
 	if updateData.Details.RawParameters != nil {
-		if err := json.Unmarshal(updateData.Details.RawParameters, &params); err != nil {
+		decoder := json.NewDecoder(bytes.NewReader(updateData.Details.RawParameters))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&struct {
+			*QueueParams
+			*RedriveParams
+		}{&params, &redrive}); err != nil {
+			return nil, apiresponses.NewFailureResponse(
+				err,
+				http.StatusBadRequest,
+				"bad-json-format",
+			)
+		}
+	}
+	plan := s.getPlan(updateData.Plan)
+	if err := plan.UpdateSchema().Validate(updateData.Details.RawParameters); err != nil {
+		return nil, err
+	}
+	redriveDLQ := aws.BoolValue(redrive.RedriveDLQ)
+	if !redriveDLQ && redrive.RedriveDLQMaxMessagesPerSecond != nil {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("redrive_dlq_max_messages_per_second can only be set with redrive_dlq"),
+			http.StatusBadRequest,
+			"invalid-parameters",
+		)
+	}
+	planChanged := updateData.Details.PreviousValues.PlanID != "" && updateData.Details.PreviousValues.PlanID != updateData.Plan.ID
+	if redriveDLQ && (!reflect.DeepEqual(params, QueueParams{}) || planChanged) {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("redrive_dlq cannot be combined with other changes to the service"),
+			http.StatusBadRequest,
+			"invalid-parameters",
+		)
+	}
+	if err := plan.CheckParams(params); err != nil {
+		return nil, err
+	}
+	if err := s.validateAllowedSenders(params.AllowedSenders); err != nil {
+		return nil, err
+	}
+	if err := validateAlarms(params.Alarms); err != nil {
+		return nil, err
+	}
+	if err := s.validateTags(params.Tags); err != nil {
+		return nil, err
+	}
+
+	stackName := s.getStackName(updateData.InstanceID)
+	stack, err := s.getStack(ctx, stackName)
+	if err == ErrStackNotFound {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	// a new KMS key can be given without repeating the encryption the
+	// instance already has
+	encryption := params
+	if encryption.Encryption == nil && encryption.KMSKeyARN != nil {
+		encryption.Encryption = QueueParamsFromStack(stackParamValues(stack)).Encryption
+	}
+	if err := s.validateEncryption(encryption); err != nil {
+		return nil, err
+	}
+	// bindings are only granted the key they were created with
+	if kmsKeyChanged(stackParamValues(stack), params) {
+		hasBindings, err := s.hasBindings(ctx, updateData.InstanceID)
+		if err != nil {
 			return nil, err
 		}
+		if hasBindings {
+			return nil, apiresponses.NewFailureResponse(
+				fmt.Errorf("cannot change the KMS key while the service has bindings: their policies only allow the key they were created with, so unbind them first"),
+				http.StatusUnprocessableEntity,
+				"kms-key-change",
+			)
+		}
+	}
+	if redriveDLQ {
+		return s.redriveDLQ(ctx, stack, redrive)
+	}
+	if err := validateFIFOParams(params, plan.FIFO(), stackParamValues(stack)); err != nil {
+		return nil, err
+	}
+
+	// changing between standard and FIFO queues requires new queues with
+	// different names, which would lose any messages in the old ones
+	if isFIFOStack(stack) != plan.FIFO() {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("cannot change between standard and FIFO queues: the queues would be replaced and their messages lost"),
+			http.StatusUnprocessableEntity,
+			"queue-type-change",
+		)
+	}
+	// the queues of a broadcast instance belong to its bindings, so
+	// changing to or from a broadcast plan would leave bindings without
+	// their queues or delete the instance's queues
+	if isBroadcastStack(stack) != plan.Broadcast {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("cannot change between broadcast and other plans: the queues would be replaced and their messages lost"),
+			http.StatusUnprocessableEntity,
+			"broadcast-change",
+		)
+	}
+	if !plan.HasDeadLetterQueue() && params.RedriveMaxReceiveCount == nil {
+		params.RedriveMaxReceiveCount = aws.Int(0)
+	}
+	// the parameters that are not being changed keep their values, so
+	// they must also be within the maximums of the new plan
+	if planChanged {
+		if err := plan.CheckParams(overlayParams(QueueParamsFromStack(stackParamValues(stack)), params)); err != nil {
+			return nil, apiresponses.NewFailureResponse(
+				fmt.Errorf("cannot change plan: %s, so it must be changed as well", err),
+				http.StatusUnprocessableEntity,
+				"plan-limit-exceeded",
+			)
+		}
 	}
 
-	_, err := s.Client.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{
-		Capabilities:        capabilities,
-		StackName:           aws.String(s.getStackName(updateData.InstanceID)),
-		Parameters:          params.UpdateParams(),
-		UsePreviousTemplate: aws.Bool(true),
+	// an existing secondary queue is kept when moving to a plan without a
+	// dead letter queue, as removing it would lose any messages in it
+	secondaryQueue := plan.HasDeadLetterQueue() || getStackOutput(stack, OutputSecondaryQueueURL) != ""
+	current := QueueParamsFromStack(stackParamValues(stack))
+	if params.Queues != nil {
+		params.Queues = plan.applyQueueDefaults(params.Queues)
+		current.Queues = params.Queues
+	}
+	if params.FilterPolicy != nil {
+		current.FilterPolicy = params.FilterPolicy
+	}
+	if params.AllowedSenders != nil {
+		current.AllowedSenders = params.AllowedSenders
+	}
+	if params.Alarms != nil {
+		current.Alarms = params.Alarms
+	}
+	if params.Tags != nil {
+		current.Tags = params.Tags
+	}
+	tmpl, err := s.buildQueueTemplate(updateData.InstanceID, updateData.Details.ServiceID, plan, secondaryQueue, current)
+	if err != nil {
+		return nil, err
+	}
+
+	token := newOperationToken(UpdateOperation)
+	_, err = s.Client.UpdateStackWithContext(ctx, &cloudformation.UpdateStackInput{
+		Capabilities:       capabilities,
+		StackName:          aws.String(stackName),
+		Parameters:         declaredParams(stack, params.UpdateParams()),
+		TemplateBody:       aws.String(tmpl),
+		Tags:               s.propagatedTags(stackTags(updateData.Details.ServiceID, updateData.Plan.ID), mergeTags(s.resourceTags(updateData.InstanceID, updateData.Details.ServiceID, updateData.InstanceID), updateContextTags(updateData.Details, stack)), current.Tags),
+		ClientRequestToken: aws.String(token),
 	})
 	if err != nil {
+		if IsNoUpdatesError(err) {
+			return &domain.UpdateServiceSpec{IsAsync: false}, nil
+		}
 		return nil, err
 	}
 
 	return &domain.UpdateServiceSpec{
-		OperationData: UpdateOperation,
+		OperationData: token,
 		IsAsync:       true,
 	}, nil
 }
 
+// declaredParams drops any parameters that would reuse a previous value
+// but which the existing stack does not declare. Stacks created before a
+// parameter was added to the queue template have no previous value for
+// it, so the template default is used instead.
+func declaredParams(stack *cloudformation.Stack, params []*cloudformation.Parameter) []*cloudformation.Parameter {
+	declared := map[string]bool{}
+	for _, p := range stack.Parameters {
+		if p.ParameterKey != nil {
+			declared[*p.ParameterKey] = true
+		}
+	}
+	filtered := []*cloudformation.Parameter{}
+	for _, p := range params {
+		if p.UsePreviousValue != nil && *p.UsePreviousValue && !declared[*p.ParameterKey] {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered
+}
+
+// stackParamValues returns the current parameter values of a stack.
+func stackParamValues(stack *cloudformation.Stack) map[string]string {
+	values := map[string]string{}
+	for _, p := range stack.Parameters {
+		if p.ParameterKey != nil && p.ParameterValue != nil {
+			values[*p.ParameterKey] = *p.ParameterValue
+		}
+	}
+	return values
+}
+
+// validateFIFOParams checks the FIFO-only parameters. They are rejected for
+// standard queues. High throughput mode needs both a perMessageGroupId
+// throughput limit and messageGroup deduplication, so settings not being
+// changed are taken from current, falling back to the template defaults.
+func validateFIFOParams(params QueueParams, fifo bool, current map[string]string) error {
+	if !fifo {
+		if params.ContentBasedDeduplication != nil || params.DeduplicationScope != nil || params.FifoThroughputLimit != nil {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("content_based_deduplication, deduplication_scope and fifo_throughput_limit are only supported for FIFO queues"),
+				http.StatusBadRequest,
+				"invalid-fifo-parameter",
+			)
+		}
+		return nil
+	}
+
+	scope := DeduplicationScopeQueue
+	if v, ok := current[ParamDeduplicationScope]; ok {
+		scope = v
+	}
+	if params.DeduplicationScope != nil {
+		scope = *params.DeduplicationScope
+		if scope != DeduplicationScopeQueue && scope != DeduplicationScopeMessageGroup {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("deduplication_scope must be one of %q or %q", DeduplicationScopeQueue, DeduplicationScopeMessageGroup),
+				http.StatusBadRequest,
+				"invalid-fifo-parameter",
+			)
+		}
+	}
+
+	limit := FifoThroughputLimitPerQueue
+	if v, ok := current[ParamFifoThroughputLimit]; ok {
+		limit = v
+	}
+	if params.FifoThroughputLimit != nil {
+		limit = *params.FifoThroughputLimit
+		if limit != FifoThroughputLimitPerQueue && limit != FifoThroughputLimitPerMessageGroupID {
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("fifo_throughput_limit must be one of %q or %q", FifoThroughputLimitPerQueue, FifoThroughputLimitPerMessageGroupID),
+				http.StatusBadRequest,
+				"invalid-fifo-parameter",
+			)
+		}
+	}
+
+	if limit == FifoThroughputLimitPerMessageGroupID && scope != DeduplicationScopeMessageGroup {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("fifo_throughput_limit %q requires deduplication_scope %q", FifoThroughputLimitPerMessageGroupID, DeduplicationScopeMessageGroup),
+			http.StatusBadRequest,
+			"invalid-fifo-parameter",
+		)
+	}
+	return nil
+}
+
+// validateEncryption checks that the requested encryption settings are
+// consistent and that any existing KMS key is on the operator's allow-list.
+func (s *Provider) validateEncryption(params QueueParams) error {
+	if params.Encryption != nil {
+		switch *params.Encryption {
+		case EncryptionSQS, EncryptionKMS:
+		default:
+			return apiresponses.NewFailureResponse(
+				fmt.Errorf("encryption must be one of %q or %q", EncryptionSQS, EncryptionKMS),
+				http.StatusBadRequest,
+				"invalid-encryption",
+			)
+		}
+	}
+	if params.KMSKeyARN == nil {
+		return nil
+	}
+	if params.Encryption == nil || *params.Encryption != EncryptionKMS {
+		return apiresponses.NewFailureResponse(
+			fmt.Errorf("kms_key_arn requires encryption to be %q", EncryptionKMS),
+			http.StatusBadRequest,
+			"invalid-encryption",
+		)
+	}
+	if *params.KMSKeyARN == "" {
+		return nil
+	}
+	for _, allowed := range s.AllowedKMSKeyARNs {
+		if allowed == *params.KMSKeyARN {
+			return nil
+		}
+	}
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("kms_key_arn %s is not permitted", *params.KMSKeyARN),
+		http.StatusBadRequest,
+		"invalid-kms-key",
+	)
+}
+
+// kmsKeyChanged returns true if the update switches the queues to KMS
+// encryption or to a different KMS key.
+func kmsKeyChanged(current map[string]string, params QueueParams) bool {
+	encryption, key := current[ParamEncryption], current[ParamKMSKeyARN]
+	if params.Encryption != nil {
+		encryption = *params.Encryption
+	}
+	if params.KMSKeyARN != nil {
+		key = *params.KMSKeyARN
+	}
+	if encryption != EncryptionKMS {
+		return false
+	}
+	return current[ParamEncryption] != EncryptionKMS || key != current[ParamKMSKeyARN]
+}
+
+// hasBindings returns true if any of this broker's binding stacks belong
+// to the instance.
+func (s *Provider) hasBindings(ctx context.Context, instanceID string) (bool, error) {
+	input := &cloudformation.DescribeStacksInput{}
+	for {
+		out, err := s.Client.DescribeStacksWithContext(ctx, input)
+		if err != nil {
+			return false, err
+		}
+		if out == nil {
+			return false, nil
+		}
+		for _, stack := range out.Stacks {
+			if s.isOwnBindingStack(stack) && findTag(stack.Tags, TagInstanceId) == instanceID {
+				return true, nil
+			}
+		}
+		if out.NextToken == nil {
+			return false, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
 func (s *Provider) LastOperation(ctx context.Context, lastOperationData provideriface.LastOperationData) (*domain.LastOperation, error) {
 	stackName := s.getStackName(lastOperationData.InstanceID)
+	opData := lastOperationData.PollDetails.OperationData
 	stack, err := s.getStack(ctx, stackName)
 	if err == ErrStackNotFound {
-		if lastOperationData.PollDetails.OperationData == DeprovisionOperation {
+		if operation, _ := parseOperationData(opData); operation == DeprovisionOperation {
 			return &domain.LastOperation{
 				State:       domain.Succeeded,
 				Description: "done",
@@ -348,17 +874,28 @@ func (s *Provider) LastOperation(ctx context.Context, lastOperationData provider
 		return nil, err
 	}
 
-	switch *stack.StackStatus {
+	if opData == RedriveOperation {
+		return s.redriveOperation(ctx, stack)
+	}
+
+	status, err := s.stackOperationStatus(ctx, stack, opData)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
 	case cloudformation.StackStatusDeleteFailed, cloudformation.StackStatusCreateFailed, cloudformation.StackStatusRollbackFailed, cloudformation.StackStatusUpdateRollbackFailed, cloudformation.StackStatusRollbackComplete, cloudformation.StackStatusUpdateRollbackComplete:
-		return &domain.LastOperation{
-			State:       domain.Failed,
-			Description: fmt.Sprintf("failed: %s", *stack.StackStatus),
-		}, nil
+		return s.failedOperation(ctx, stack, status), nil
 	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusDeleteComplete:
 		return &domain.LastOperation{
 			State:       domain.Succeeded,
 			Description: "done",
 		}, nil
+	case "":
+		return &domain.LastOperation{
+			State:       domain.Failed,
+			Description: "failed: cloudformation has no record of the operation",
+		}, nil
 	default:
 		return &domain.LastOperation{
 			State:       domain.InProgress,
@@ -375,7 +912,7 @@ func (s *Provider) LastBindingOperation(ctx context.Context, lastBindingOperatio
 func (s *Provider) lastBindingOperation(ctx context.Context, stackName string, opData string) (*domain.LastOperation, error) {
 	stack, err := s.getStack(ctx, stackName)
 	if err == ErrStackNotFound {
-		if opData == UnbindOperation {
+		if operation, _ := parseOperationData(opData); operation == UnbindOperation {
 			return &domain.LastOperation{
 				State:       domain.Succeeded,
 				Description: "done",
@@ -390,17 +927,24 @@ func (s *Provider) lastBindingOperation(ctx context.Context, stackName string, o
 		return nil, err
 	}
 
-	switch *stack.StackStatus {
+	status, err := s.stackOperationStatus(ctx, stack, opData)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
 	case cloudformation.StackStatusDeleteFailed, cloudformation.StackStatusCreateFailed, cloudformation.StackStatusRollbackFailed, cloudformation.StackStatusUpdateRollbackFailed, cloudformation.StackStatusRollbackComplete, cloudformation.StackStatusUpdateRollbackComplete:
-		return &domain.LastOperation{
-			State:       domain.Failed,
-			Description: fmt.Sprintf("failed: %s", *stack.StackStatus),
-		}, nil
+		return s.failedOperation(ctx, stack, status), nil
 	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete, cloudformation.StackStatusDeleteComplete:
 		return &domain.LastOperation{
 			State:       domain.Succeeded,
 			Description: "ready",
 		}, nil
+	case "":
+		return &domain.LastOperation{
+			State:       domain.Failed,
+			Description: "failed: cloudformation has no record of the operation",
+		}, nil
 	default:
 		return &domain.LastOperation{
 			State:       domain.InProgress,
@@ -409,6 +953,17 @@ func (s *Provider) lastBindingOperation(ctx context.Context, stackName string, o
 	}
 }
 
+// stackOperationStatus returns the status of the stack operation
+// identified by opData. OperationData without a ClientRequestToken falls
+// back to the current stack status.
+func (s *Provider) stackOperationStatus(ctx context.Context, stack *cloudformation.Stack, opData string) (string, error) {
+	_, token := parseOperationData(opData)
+	if token == "" {
+		return *stack.StackStatus, nil
+	}
+	return s.operationStatus(ctx, stack, token)
+}
+
 func (s *Provider) GetBinding(ctx context.Context, getBindingData provideriface.GetBindData) (*domain.GetBindingSpec, error) {
 	userStackName := s.getStackName(getBindingData.BindingID)
 	return s.getBinding(ctx, userStackName)
@@ -443,6 +998,57 @@ func (s *Provider) getBinding(ctx context.Context, userStackName string) (*domai
 	}, nil
 }
 
+// InstanceParameters is the live configuration of a service instance as
+// returned by GetInstance.
+type InstanceParameters struct {
+	QueueParams
+	Status  string            `json:"status"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// GetInstance rebuilds the view of a service instance from its queue
+// stack.
+func (s *Provider) GetInstance(ctx context.Context, instanceID string) (*domain.GetInstanceDetailsSpec, error) {
+	stack, err := s.getStack(ctx, s.getStackName(instanceID))
+	if err == ErrStackNotFound {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch *stack.StackStatus {
+	case cloudformation.StackStatusCreateInProgress, cloudformation.StackStatusDeleteComplete:
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	case cloudformation.StackStatusUpdateInProgress, cloudformation.StackStatusUpdateCompleteCleanupInProgress:
+		return nil, brokerapi.ErrConcurrentInstanceAccess
+	}
+
+	tags := map[string]string{}
+	for _, tag := range stack.Tags {
+		if tag.Key != nil && tag.Value != nil {
+			tags[*tag.Key] = *tag.Value
+		}
+	}
+	outputs := map[string]string{}
+	for _, output := range stack.Outputs {
+		if output.OutputKey != nil && output.OutputValue != nil {
+			outputs[*output.OutputKey] = *output.OutputValue
+		}
+	}
+
+	return &domain.GetInstanceDetailsSpec{
+		ServiceID: tags[TagServiceId],
+		PlanID:    tags[TagPlanId],
+		Parameters: InstanceParameters{
+			QueueParams: QueueParamsFromStack(stackParamValues(stack)),
+			Status:      *stack.StackStatus,
+			Tags:        tags,
+			Outputs:     outputs,
+		},
+	}, nil
+}
+
 func (s *Provider) getStack(ctx context.Context, stackName string) (*cloudformation.Stack, error) {
 	describeOutput, err := s.Client.DescribeStacksWithContext(ctx, &cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackName),
@@ -469,17 +1075,19 @@ func (s *Provider) getStack(ctx context.Context, stackName string) (*cloudformat
 	return state, nil
 }
 
-// tryDestroyStack removes the cloudformation stack by name. It does not use
-// the request's context to avoid the siutation where a timeout has been
-// reached and the stack needs to be cleaned up.
+// tryDestroyStack removes the cloudformation stack by name, as the given
+// operation so that its stack events are tagged with what was undone. It
+// does not use the request's context to avoid the siutation where a
+// timeout has been reached and the stack needs to be cleaned up.
 // It is intended to be run as a one off goroutine at a point when no error can be returned
 // so can only log any problems.
-func (s *Provider) tryDestroyStack(stackName string) {
+func (s *Provider) tryDestroyStack(stackName string, operation string) {
 	deleteCtx := context.Background()
 	deleteCtx, cancel := context.WithTimeout(deleteCtx, 60*time.Second)
 	defer cancel()
 	_, err := s.Client.DeleteStackWithContext(deleteCtx, &cloudformation.DeleteStackInput{
-		StackName: aws.String(stackName),
+		StackName:          aws.String(stackName),
+		ClientRequestToken: aws.String(newOperationToken(operation)),
 	})
 	if err != nil {
 		s.Logger.Error("try-destroy-stack", err)
@@ -501,6 +1109,15 @@ func IsNotFoundError(err error) bool {
 	return false
 }
 
+// IsNoUpdatesError returns true if cloudformation rejected an update
+// because it would not change anything.
+func IsNoUpdatesError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return strings.Contains(awsErr.Message(), NoUpdatesErrMatch)
+	}
+	return false
+}
+
 func getStackOutput(stack *cloudformation.Stack, key string) string {
 	for _, item := range stack.Outputs {
 		if item.OutputKey == nil || item.OutputValue == nil {
@@ -512,3 +1129,14 @@ func getStackOutput(stack *cloudformation.Stack, key string) string {
 	}
 	return ""
 }
+
+// findTag returns the value of the tag with the given key, or an empty
+// string if there is none.
+func findTag(tags []*cloudformation.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
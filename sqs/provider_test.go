@@ -3,8 +3,14 @@ package sqs_test
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
 
+	"code.cloudfoundry.org/lager"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	goformation "github.com/awslabs/goformation/v4"
@@ -53,6 +59,7 @@ var _ = Describe("Provider", func() {
 					ID:   "uuid-2",
 				},
 				Details: domain.ProvisionDetails{
+					ServiceID:        "uuid-1",
 					OrganizationGUID: "27b72d3f-9401-4b45-a7e7-40b17819954f",
 				},
 			}
@@ -65,7 +72,7 @@ var _ = Describe("Provider", func() {
 				spec, err := sqsProvider.Provision(context.Background(), provisionData)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(spec.DashboardURL).To(Equal(""))
-				Expect(spec.OperationData).To(Equal("provision"))
+				Expect(spec.OperationData).To(HavePrefix("provision-"))
 				Expect(spec.IsAsync).To(BeTrue())
 
 				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(1))
@@ -73,16 +80,11 @@ var _ = Describe("Provider", func() {
 				var ctx context.Context
 				ctx, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
 				Expect(ctx).ToNot(BeNil())
+				Expect(createStackInput.ClientRequestToken).To(Equal(aws.String(spec.OperationData)))
 
 				Expect(createStackInput.TemplateBody).ToNot(BeNil())
-				t, err := goformation.ParseYAML([]byte(*createStackInput.TemplateBody))
-				Expect(err).ToNot(HaveOccurred())
-
-				var ok bool
-
-				Expect(t.Resources).To(ContainElement(BeAssignableToTypeOf(&goformationsqs.Queue{})))
-				queue, ok = t.Resources[sqs.ResourcePrimaryQueue].(*goformationsqs.Queue)
-				Expect(ok).To(BeTrue())
+				t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+				queue = t.Queue(sqs.ResourcePrimaryQueue)
 
 			})
 
@@ -100,6 +102,57 @@ var _ = Describe("Provider", func() {
 				Expect(createStackInput.Parameters).To(HaveLen(0))
 			})
 
+			It("should tag the stack with the service and plan", func() {
+				Expect(createStackInput.Tags).To(ConsistOf(
+					&cloudformation.Tag{Key: aws.String(sqs.TagServiceId), Value: aws.String("uuid-1")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagPlanId), Value: aws.String("uuid-2")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagName), Value: aws.String(provisionData.InstanceID)},
+					&cloudformation.Tag{Key: aws.String(sqs.TagService), Value: aws.String("sqs")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagEnvironment), Value: aws.String("test")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagCostAllocation), Value: aws.String(provisionData.InstanceID)},
+					&cloudformation.Tag{Key: aws.String(sqs.TagOrganizationGUID), Value: aws.String("27b72d3f-9401-4b45-a7e7-40b17819954f")},
+				))
+			})
+
+			Context("when the platform sends a cloudfoundry context", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawContext = json.RawMessage(`{
+						"platform": "cloudfoundry",
+						"organization_guid": "27b72d3f-9401-4b45-a7e7-40b17819954f",
+						"organization_name": "payments",
+						"space_guid": "1a2b3c4d-9401-4b45-a7e7-40b17819954f",
+						"space_name": "prod & staging"
+					}`)
+				})
+
+				It("should tag the stack with the org and space", func() {
+					Expect(createStackInput.Tags).To(ContainElements(
+						&cloudformation.Tag{Key: aws.String(sqs.TagOrganizationGUID), Value: aws.String("27b72d3f-9401-4b45-a7e7-40b17819954f")},
+						&cloudformation.Tag{Key: aws.String(sqs.TagOrganizationName), Value: aws.String("payments")},
+						&cloudformation.Tag{Key: aws.String(sqs.TagSpaceGUID), Value: aws.String("1a2b3c4d-9401-4b45-a7e7-40b17819954f")},
+						&cloudformation.Tag{Key: aws.String(sqs.TagSpaceName), Value: aws.String("prod _ staging")},
+					))
+				})
+			})
+
+			Context("when the platform sends a kubernetes context", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawContext = json.RawMessage(`{
+						"platform": "kubernetes",
+						"namespace": "payments",
+						"clusterid": "8263feba-9b8a-4f6a-9e5b-2a2c1a3b1c1d"
+					}`)
+				})
+
+				It("should tag the stack with the namespace and cluster", func() {
+					Expect(createStackInput.Tags).To(ContainElements(
+						&cloudformation.Tag{Key: aws.String(sqs.TagNamespace), Value: aws.String("payments")},
+						&cloudformation.Tag{Key: aws.String(sqs.TagClusterID), Value: aws.String("8263feba-9b8a-4f6a-9e5b-2a2c1a3b1c1d")},
+					))
+					Expect(createStackInput.Tags).ToNot(ContainElement(HaveField("Key", aws.String(sqs.TagOrganizationGUID))))
+				})
+			})
+
 			Context("Standard queues", func() {
 				It("Should not be a FIFO queue", func() {
 					Expect(queue.FifoQueue).To(BeFalse())
@@ -125,30 +178,79 @@ var _ = Describe("Provider", func() {
 				})
 			})
 
-			XContext("when content_based_deduplication provision param set to false", func() {
+			It("should create a secondary queue", func() {
+				t := parseTemplate(*createStackInput.TemplateBody, nil)
+				Expect(t.Resources).To(HaveKey(sqs.ResourceSecondaryQueue))
+			})
+
+			Context("when the plan has no dead letter queue", func() {
 				BeforeEach(func() {
-					provisionData = provideriface.ProvisionData{
-						Details: domain.ProvisionDetails{
-							RawParameters: json.RawMessage(`{
-								"content_based_deduplication": false
-							}`),
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {
+							QueueType:       sqs.QueueTypeStandard,
+							DeadLetterQueue: aws.Bool(false),
 						},
 					}
 				})
-				It("should not set content-based-deduplication", func() {
-					Expect(queue.ContentBasedDeduplication).To(BeFalse())
+
+				It("should not create a secondary queue", func() {
+					t := parseTemplate(*createStackInput.TemplateBody, nil)
+					Expect(t.Resources).NotTo(HaveKey(sqs.ResourceSecondaryQueue))
+					Expect(t.Outputs).NotTo(HaveKey(sqs.OutputSecondaryQueueURL))
+					Expect(queue.RedrivePolicy).To(BeNil())
 				})
 			})
 
-			XContext("when content_based_deduplication provision param set to true", func() {
+			Context("when the plan is configured", func() {
 				BeforeEach(func() {
-					provisionData = provideriface.ProvisionData{
-						Details: domain.ProvisionDetails{
-							RawParameters: json.RawMessage(`{
-								"content_based_deduplication": true
-							}`),
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {
+							QueueType: sqs.QueueTypeFIFO,
+							Defaults: sqs.QueueParams{
+								MessageRetentionPeriod: aws.Int(1209600),
+								VisibilityTimeout:      aws.Int(60),
+							},
 						},
 					}
+					provisionData.Details.RawParameters = json.RawMessage(`{"visibility_timeout": 90}`)
+				})
+
+				It("should use the configured queue type", func() {
+					Expect(queue.FifoQueue).To(BeTrue())
+				})
+
+				It("should apply the plan defaults to unset params", func() {
+					Expect(createStackInput.Parameters).To(ConsistOf(
+						&cloudformation.Parameter{
+							ParameterKey:   aws.String(sqs.ParamMessageRetentionPeriod),
+							ParameterValue: aws.String("1209600"),
+						},
+						&cloudformation.Parameter{
+							ParameterKey:   aws.String(sqs.ParamVisibilityTimeout),
+							ParameterValue: aws.String("90"),
+						},
+					))
+				})
+			})
+
+			Context("when content_based_deduplication provision param set to false", func() {
+				BeforeEach(func() {
+					provisionData.Plan.Name = "fifo"
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"content_based_deduplication": false
+					}`)
+				})
+				It("should not set content-based-deduplication", func() {
+					Expect(queue.ContentBasedDeduplication).To(BeFalse())
+				})
+			})
+
+			Context("when content_based_deduplication provision param set to true", func() {
+				BeforeEach(func() {
+					provisionData.Plan.Name = "fifo"
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"content_based_deduplication": true
+					}`)
 				})
 
 				It("should set content-based-deduplication", func() {
@@ -156,6 +258,29 @@ var _ = Describe("Provider", func() {
 				})
 			})
 
+			Context("when high throughput FIFO params set", func() {
+				BeforeEach(func() {
+					provisionData.Plan.Name = "fifo"
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"deduplication_scope": "messageGroup",
+						"fifo_throughput_limit": "perMessageGroupId"
+					}`)
+				})
+
+				It("should set the deduplication scope and throughput limit", func() {
+					Expect(createStackInput.Parameters).To(ConsistOf(
+						&cloudformation.Parameter{
+							ParameterKey:   aws.String(sqs.ParamDeduplicationScope),
+							ParameterValue: aws.String("messageGroup"),
+						},
+						&cloudformation.Parameter{
+							ParameterKey:   aws.String(sqs.ParamFifoThroughputLimit),
+							ParameterValue: aws.String("perMessageGroupId"),
+						},
+					))
+				})
+			})
+
 			Context("when delay_seconds provision param set", func() {
 				BeforeEach(func() {
 					provisionData = provideriface.ProvisionData{
@@ -180,7 +305,7 @@ var _ = Describe("Provider", func() {
 					provisionData = provideriface.ProvisionData{
 						Details: domain.ProvisionDetails{
 							RawParameters: json.RawMessage(`{
-								"maximum_message_size": 10
+								"maximum_message_size": 2048
 							}`),
 						},
 					}
@@ -189,7 +314,7 @@ var _ = Describe("Provider", func() {
 				It("should set the queue max message size", func() {
 					Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
 						ParameterKey:   aws.String(sqs.ParamMaximumMessageSize),
-						ParameterValue: aws.String("10"),
+						ParameterValue: aws.String("2048"),
 					}))
 				})
 			})
@@ -199,7 +324,7 @@ var _ = Describe("Provider", func() {
 					provisionData = provideriface.ProvisionData{
 						Details: domain.ProvisionDetails{
 							RawParameters: json.RawMessage(`{
-								"message_retention_period": 3
+								"message_retention_period": 300
 							}`),
 						},
 					}
@@ -208,7 +333,7 @@ var _ = Describe("Provider", func() {
 				It("should set the queue retention period", func() {
 					Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
 						ParameterKey:   aws.String(sqs.ParamMessageRetentionPeriod),
-						ParameterValue: aws.String("3"),
+						ParameterValue: aws.String("300"),
 					}))
 				})
 			})
@@ -251,6 +376,63 @@ var _ = Describe("Provider", func() {
 				})
 			})
 
+			Context("when queues param set", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"delay_seconds": 10,
+						"queues": [{"name": "jobs", "visibility_timeout": 60}]
+					}`)
+				})
+
+				It("should store the named queues", func() {
+					Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+						ParameterKey:   aws.String(sqs.ParamQueues),
+						ParameterValue: aws.String(`[{"name":"jobs","visibility_timeout":60}]`),
+					}))
+				})
+
+				It("should create the named queues", func() {
+					t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+					jobs := t.Queue("QueueJobs")
+					Expect(jobs.QueueName).To(Equal(fmt.Sprintf("testprefix-%s-jobs", provisionData.InstanceID)))
+					Expect(jobs.DelaySeconds).To(Equal(0))
+					Expect(jobs.VisibilityTimeout).To(Equal(60))
+				})
+			})
+
+			Context("when the plan has a topic", func() {
+				BeforeEach(func() {
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {QueueType: sqs.QueueTypeStandard, Topic: true},
+					}
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"filter_policy": {"event": ["order_placed"]},
+						"raw_message_delivery": true
+					}`)
+				})
+
+				It("should subscribe the primary queue to a topic", func() {
+					t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+					Expect(t.Resources).To(HaveKey(sqs.ResourceTopic))
+					subscription := t.Resources[sqs.ResourcePrimaryQueueSubscription]
+					Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", true))
+					Expect(subscription.Properties).To(HaveKeyWithValue("FilterPolicy", HaveKey("event")))
+				})
+
+				It("should store the subscription settings", func() {
+					Expect(createStackInput.Parameters).To(ContainElements(
+						&cloudformation.Parameter{
+							ParameterKey:   aws.String(sqs.ParamFilterPolicy),
+							ParameterValue: aws.String(`{"event":["order_placed"]}`),
+						},
+						&cloudformation.Parameter{
+							ParameterKey:   aws.String(sqs.ParamRawMessageDelivery),
+							ParameterValue: aws.String("true"),
+						},
+					))
+				})
+			})
+
 			Context("when visibility_timeout param set", func() {
 				BeforeEach(func() {
 					provisionData = provideriface.ProvisionData{
@@ -270,6 +452,38 @@ var _ = Describe("Provider", func() {
 				})
 			})
 
+			Context("when encryption param set", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"encryption": "kms"
+					}`)
+				})
+
+				It("should set the encryption parameter", func() {
+					Expect(createStackInput.Parameters).To(ConsistOf(&cloudformation.Parameter{
+						ParameterKey:   aws.String(sqs.ParamEncryption),
+						ParameterValue: aws.String("kms"),
+					}))
+				})
+			})
+
+			Context("when an allowed kms_key_arn param set", func() {
+				BeforeEach(func() {
+					sqsProvider.AllowedKMSKeyARNs = []string{"arn:aws:kms:eu-west-2:123456789012:key/abcd"}
+					provisionData.Details.RawParameters = json.RawMessage(`{
+						"encryption": "kms",
+						"kms_key_arn": "arn:aws:kms:eu-west-2:123456789012:key/abcd"
+					}`)
+				})
+
+				It("should set the key parameter", func() {
+					Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+						ParameterKey:   aws.String(sqs.ParamKMSKeyARN),
+						ParameterValue: aws.String("arn:aws:kms:eu-west-2:123456789012:key/abcd"),
+					}))
+				})
+			})
+
 			It("Should set appropriate tags", func() {
 				Expect(queue.Tags).To(And(
 					ContainElement(goformationtags.Tag{
@@ -306,6 +520,296 @@ var _ = Describe("Provider", func() {
 			})
 		})
 
+		Context("when an identical request is repeated", func() {
+			var existingStack *cloudformation.Stack
+
+			BeforeEach(func() {
+				fakeCfnClient.CreateStackWithContextReturns(nil, &fakeClient.MockAWSError{
+					C: "AlreadyExistsException",
+					M: "Stack [testprefix-a5da1b66-da42-4c83-b806-f287bc589ab3] already exists",
+				})
+				existingStack = &cloudformation.Stack{
+					StackName:   aws.String("some stack"),
+					StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+					Tags: []*cloudformation.Tag{
+						{Key: aws.String(sqs.TagServiceId), Value: aws.String("uuid-1")},
+						{Key: aws.String(sqs.TagPlanId), Value: aws.String("uuid-2")},
+					},
+				}
+				for key, value := range map[string]string{
+					sqs.ParamDelaySeconds:                  "0",
+					sqs.ParamDLQDelaySeconds:               "0",
+					sqs.ParamDLQMaximumMessageSize:         "262144",
+					sqs.ParamDLQMessageRetentionPeriod:     "-1",
+					sqs.ParamDLQRedrivePermission:          "allowAll",
+					sqs.ParamDLQVisibilityTimeout:          "-1",
+					sqs.ParamEncryption:                    "sqs",
+					sqs.ParamKMSKeyARN:                     "",
+					sqs.ParamMaximumMessageSize:            "262144",
+					sqs.ParamMessageRetentionPeriod:        "345600",
+					sqs.ParamQueues:                        "[]",
+					sqs.ParamFilterPolicy:                  "",
+					sqs.ParamRawMessageDelivery:            "false",
+					sqs.ParamAllowedSenders:                "[]",
+					sqs.ParamAlarms:                        "",
+					sqs.ParamTags:                          "{}",
+					sqs.ParamReceiveMessageWaitTimeSeconds: "0",
+					sqs.ParamRedriveMaxReceiveCount:        "0",
+					sqs.ParamVisibilityTimeout:             "60",
+				} {
+					existingStack.Parameters = append(existingStack.Parameters, &cloudformation.Parameter{
+						ParameterKey:   aws.String(key),
+						ParameterValue: aws.String(value),
+					})
+				}
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{existingStack},
+				}, nil)
+				provisionData.Details.RawParameters = json.RawMessage(`{"visibility_timeout": 60}`)
+			})
+
+			It("returns the existing instance", func() {
+				spec, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(spec.AlreadyExists).To(BeTrue())
+				Expect(spec.IsAsync).To(BeFalse())
+			})
+
+			It("continues polling an instance that is still being created", func() {
+				existingStack.StackStatus = aws.String(cloudformation.StackStatusCreateInProgress)
+
+				spec, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(spec.IsAsync).To(BeTrue())
+				Expect(spec.OperationData).To(Equal(sqs.ProvisionOperation))
+			})
+
+			It("conflicts when the parameters differ", func() {
+				provisionData.Details.RawParameters = json.RawMessage(`{"visibility_timeout": 61}`)
+
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+			})
+
+			It("conflicts when the existing instance failed to create", func() {
+				existingStack.StackStatus = aws.String(cloudformation.StackStatusRollbackComplete)
+
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+			})
+		})
+
+		Context("when allowed_senders is set", func() {
+			BeforeEach(func() {
+				sqsProvider.AllowedSenderAccounts = []string{"123456789012"}
+				provisionData.Details.RawParameters = json.RawMessage(`{"allowed_senders": [
+					{"service": "events.amazonaws.com", "source_arn": "arn:aws:events:eu-west-2:123456789012:rule/orders"},
+					{"account": "123456789012"}
+				]}`)
+			})
+
+			It("stores the senders and lets them send to the primary queue", func() {
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamAllowedSenders),
+					ParameterValue: aws.String(`[{"service":"events.amazonaws.com","source_arn":"arn:aws:events:eu-west-2:123456789012:rule/orders"},{"account":"123456789012"}]`),
+				}))
+				t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+				Expect(t.Resources[sqs.ResourceQueuePolicy].Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", HaveLen(2))))
+			})
+		})
+
+		Context("when the broker hardens queue policies", func() {
+			BeforeEach(func() {
+				sqsProvider.DenyInsecureTransport = true
+				sqsProvider.RestrictPrincipals = true
+				sqsProvider.BrokerPrincipalARN = "arn:aws:iam::123456789012:role/sqs-broker"
+			})
+
+			It("adds the deny statements to the queue policy", func() {
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+				Expect(t.Resources[sqs.ResourceQueuePolicy].Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Condition", HaveKeyWithValue("Bool", HaveKeyWithValue("aws:SecureTransport", "false"))),
+					HaveKeyWithValue("Condition", HaveKeyWithValue("ArnNotLike", HaveKeyWithValue("aws:PrincipalArn", ContainElements(
+						HaveSuffix(":user/testprefix/*"),
+						"arn:aws:iam::123456789012:role/sqs-broker",
+					)))),
+				))))
+			})
+		})
+
+		Context("when alarms are set", func() {
+			BeforeEach(func() {
+				provisionData.Details.RawParameters = json.RawMessage(`{"alarms": {
+					"oldest_message_age": 3600,
+					"endpoints": [{"protocol": "email", "endpoint": "team@example.com"}]
+				}}`)
+			})
+
+			It("stores the alarms and creates them", func() {
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamAlarms),
+					ParameterValue: aws.String(`{"oldest_message_age":3600,"endpoints":[{"protocol":"email","endpoint":"team@example.com"}]}`),
+				}))
+				t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+				Expect(t.Resources).To(HaveKey(sqs.ResourceAlarmTopic))
+				Expect(t.Resources).To(HaveKey("SecondaryQueueDepthAlarm"))
+				Expect(t.Resources).To(HaveKey("PrimaryQueueAgeAlarm"))
+			})
+		})
+
+		Context("when tags are set", func() {
+			BeforeEach(func() {
+				sqsProvider.Tags = map[string]string{"cost-centre": "1234"}
+				provisionData.Details.RawParameters = json.RawMessage(`{"tags": {"team": "payments"}}`)
+			})
+
+			It("stores the tenant's tags and tags the stack with them and the operator's", func() {
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamTags),
+					ParameterValue: aws.String(`{"team":"payments"}`),
+				}))
+				Expect(createStackInput.Tags).To(ContainElements(
+					&cloudformation.Tag{Key: aws.String("cost-centre"), Value: aws.String("1234")},
+					&cloudformation.Tag{Key: aws.String("team"), Value: aws.String("payments")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagPlanId), Value: aws.String("uuid-2")},
+				))
+			})
+		})
+
+		DescribeTable("when tags cannot be set",
+			func(tags string, expectedErr string) {
+				sqsProvider.Tags = map[string]string{"cost-centre": "1234"}
+				provisionData.Details.RawParameters = json.RawMessage(`{"tags": ` + tags + `}`)
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(MatchError(expectedErr))
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			},
+			Entry("empty key", `{"": "payments"}`, `tags.: key must be 1 to 128 letters, numbers, spaces or _.:/=+-@`),
+			Entry("bad characters", `{"team": "pay<ments>"}`, `tags.team: value must be at most 256 letters, numbers, spaces or _.:/=+-@`),
+			Entry("reserved by AWS", `{"AWS:team": "payments"}`, `tags.AWS:team: is reserved for use by AWS`),
+			Entry("set by the broker", `{"chargeable_entity": "payments"}`, `tags.chargeable_entity: is set by the broker`),
+			Entry("set by the operator", `{"cost-centre": "5678"}`, `tags.cost-centre: is set by the operator`),
+			Entry("too long altogether", maxLengthTags(20), `tags: must be at most 4096 bytes altogether when encoded as JSON, not 7801`),
+		)
+
+		It("accepts tags up to the stack parameter size", func() {
+			provisionData.Details.RawParameters = json.RawMessage(`{"tags": ` + maxLengthTags(10) + `}`)
+			_, err := sqsProvider.Provision(context.Background(), provisionData)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		DescribeTable("when alarms cannot be sent",
+			func(alarms string, expectedErr string) {
+				provisionData.Details.RawParameters = json.RawMessage(`{"alarms": ` + alarms + `}`)
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(MatchError(expectedErr))
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			},
+			Entry("no endpoints", `{"queue_depth": 10}`, `alarms.endpoints: at least one endpoint is required`),
+			Entry("bad email address", `{"endpoints": [{"protocol": "email", "endpoint": "Team <team@example.com>"}]}`, `alarms.endpoints[0].endpoint: must be an email address`),
+			Entry("not https", `{"endpoints": [{"protocol": "https", "endpoint": "http://alerts.example.com"}]}`, `alarms.endpoints[0].endpoint: must be an https URL`),
+			Entry("no host", `{"endpoints": [{"protocol": "https", "endpoint": "https:///alerts"}]}`, `alarms.endpoints[0].endpoint: must be an https URL`),
+			Entry("not a URL", `{"endpoints": [{"protocol": "https", "endpoint": "https://alerts.example.com:port"}]}`, `alarms.endpoints[0].endpoint: must be an https URL`),
+			Entry("backslash", `{"endpoints": [{"protocol": "https", "endpoint": "https://alerts.example.com/\\"}]}`, `alarms.endpoints[0].endpoint: cannot contain quotes, backslashes or control characters`),
+			Entry("newline", `{"endpoints": [{"protocol": "https", "endpoint": "https://alerts.example.com/\n  Foo: bar"}]}`, `alarms.endpoints[0].endpoint: cannot contain quotes, backslashes or control characters`),
+			Entry("tab", `{"endpoints": [{"protocol": "email", "endpoint": "team@example.com\t"}]}`, `alarms.endpoints[0].endpoint: cannot contain quotes, backslashes or control characters`),
+			Entry("quoted email address", `{"endpoints": [{"protocol": "email", "endpoint": "\"te\\\"am\"@example.com"}]}`, `alarms.endpoints[0].endpoint: cannot contain quotes, backslashes or control characters`),
+		)
+
+		DescribeTable("when an allowed sender is not permitted",
+			func(senders string, expectedErr string) {
+				sqsProvider.AllowedSenderAccounts = []string{"123456789012"}
+				provisionData.Details.RawParameters = json.RawMessage(`{"allowed_senders": ` + senders + `}`)
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(MatchError(expectedErr))
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			},
+			Entry("empty", `[{}]`, `allowed_senders[0]: one of service and account is required`),
+			Entry("both", `[{"service": "s3.amazonaws.com", "account": "123456789012"}]`, `allowed_senders[0]: only one of service and account can be set`),
+			Entry("service without source", `[{"service": "s3.amazonaws.com"}]`, `allowed_senders[0].source_arn: must be an ARN`),
+			Entry("source not an ARN", `[{"service": "s3.amazonaws.com", "source_arn": "arn:uploads"}]`, `allowed_senders[0].source_arn: must be an ARN`),
+			Entry("source with a quote", `[{"service": "s3.amazonaws.com", "source_arn": "arn:aws:s3:::uploads\"\n  Admin:"}]`, `allowed_senders[0].source_arn: cannot contain quotes, backslashes or control characters`),
+			Entry("source with a newline", `[{"service": "s3.amazonaws.com", "source_arn": "arn:aws:s3:::uploads\n    Type: AWS::IAM::User"}]`, `allowed_senders[0].source_arn: cannot contain quotes, backslashes or control characters`),
+			Entry("source with a space", `[{"service": "s3.amazonaws.com", "source_arn": "arn:aws:s3:::uploads Admin"}]`, `allowed_senders[0].source_arn: must be an ARN`),
+			Entry("account with source", `[{"account": "123456789012", "source_arn": "arn:aws:s3:::uploads"}]`, `allowed_senders[0].source_arn: can only be set with service`),
+			Entry("malformed account", `[{"account": "12345"}]`, `allowed_senders[0].account: must be a 12 digit AWS account ID`),
+			Entry("account not allowed", `[{"account": "210987654321"}]`, `allowed_senders[0].account: 210987654321 is not permitted`),
+		)
+
+		Context("when the plan is a broadcast plan", func() {
+			BeforeEach(func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-2": {QueueType: sqs.QueueTypeFIFO, Topic: true, Broadcast: true},
+				}
+				provisionData.Details.RawParameters = json.RawMessage(`{"visibility_timeout": 60}`)
+			})
+
+			It("creates only the topic", func() {
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				t := parseTemplate(*createStackInput.TemplateBody, stackParams(createStackInput.Parameters))
+				Expect(t.Resources).To(HaveKey(sqs.ResourceTopic))
+				Expect(t.Resources).ToNot(HaveKey(sqs.ResourcePrimaryQueue))
+				Expect(t.Resources).ToNot(HaveKey(sqs.ResourceSecondaryQueue))
+				Expect(t.Outputs).To(HaveKey(sqs.OutputTopicARN))
+				Expect(t.Outputs).ToNot(HaveKey(sqs.OutputPrimaryQueueURL))
+			})
+
+			It("stores the queue settings for the bindings", func() {
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).ToNot(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamVisibilityTimeout),
+					ParameterValue: aws.String("60"),
+				}))
+			})
+
+			It("rejects named queues", func() {
+				provisionData.Details.RawParameters = json.RawMessage(`{"queues": [{"name": "jobs"}]}`)
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(HaveOccurred())
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			})
+		})
+
+		DescribeTable("when a named queue name cannot be used",
+			func(queues string, expectedErr string) {
+				provisionData.Details.RawParameters = json.RawMessage(`{"queues": ` + queues + `}`)
+				_, err := sqsProvider.Provision(context.Background(), provisionData)
+				Expect(err).To(MatchError(expectedErr))
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			},
+			Entry("uppercase", `[{"name": "Jobs"}]`, `queues: name "Jobs" must be lowercase letters and digits separated by hyphens or underscores`),
+			Entry("reserved", `[{"name": "sec"}]`, `queues: name "sec" is reserved`),
+			Entry("too similar", `[{"name": "mail-out"}, {"name": "mail_out"}]`, `queues: names "mail-out" and "mail_out" are too similar`),
+		)
+
 		Context("Failures", func() {
 			var errResponse error
 
@@ -316,79 +820,215 @@ var _ = Describe("Provider", func() {
 				Expect(spec).To(BeNil())
 			})
 
-			Context("when an unexpected json key is provided", func() {
+			Context("when a param exceeds the plan maximum", func() {
 				BeforeEach(func() {
-					provisionData.Details.RawParameters = json.RawMessage(`{"mango": 314, "delay_seconds": 60}`)
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {
+							QueueType: sqs.QueueTypeStandard,
+							Maximums:  sqs.QueueParams{MessageRetentionPeriod: aws.Int(86400)},
+						},
+					}
+					provisionData.Details.RawParameters = json.RawMessage(`{"message_retention_period": 86401}`)
 				})
 				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("json: unknown field \"mango\""))
-
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					Expect(errResponse).To(MatchError("message_retention_period: must be at most 86400"))
 					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
 					Expect(ok).To(BeTrue())
 					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
 				})
-				It("should not have created a stack", func() {
-					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+			})
+
+			Context("when a named queue exceeds the plan maximum", func() {
+				BeforeEach(func() {
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {
+							QueueType: sqs.QueueTypeStandard,
+							Maximums:  sqs.QueueParams{MessageRetentionPeriod: aws.Int(86400)},
+						},
+					}
+					provisionData.Details.RawParameters = json.RawMessage(`{"queues": [{"name": "jobs", "message_retention_period": 86401}]}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("queues[0].message_retention_period: must be at most 86400"))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
 				})
 			})
 
-			Context("when a json key with a mismatched type is provided", func() {
+			Context("when subscription params are provided for a plan without a topic", func() {
 				BeforeEach(func() {
-					provisionData.Details.RawParameters = json.RawMessage(`{"delay_seconds": "60"}`)
+					provisionData.Details.RawParameters = json.RawMessage(`{"raw_message_delivery": true}`)
 				})
 				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("json: cannot unmarshal string into Go struct field QueueParams.delay_seconds of type int"))
+					Expect(errResponse).To(MatchError("raw_message_delivery: unknown parameter"))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+			})
 
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+			Context("when FIFO params are provided for a standard queue", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawParameters = json.RawMessage(`{"content_based_deduplication": true}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("content_based_deduplication: unknown parameter"))
 					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
 					Expect(ok).To(BeTrue())
 					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
 				})
-				It("should not have created a stack", func() {
-					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+			})
+
+			Context("when an unknown deduplication_scope is requested", func() {
+				BeforeEach(func() {
+					provisionData.Plan.Name = "fifo"
+					provisionData.Details.RawParameters = json.RawMessage(`{"deduplication_scope": "everything"}`)
+				})
+				It("should return an appropriate error", func() {
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
 				})
 			})
 
-			Context("when the requested instance id already exists", func() {
+			Context("when a per message group throughput limit is requested with queue deduplication", func() {
 				BeforeEach(func() {
-					fakeCfnClient.CreateStackWithContextReturnsOnCall(0, nil,
-						&fakeClient.MockAWSError{
-							C: "AlreadyExistsException",
-							M: "Got one of those",
-						},
-					)
+					provisionData.Plan.Name = "fifo"
+					provisionData.Details.RawParameters = json.RawMessage(`{"fifo_throughput_limit": "perMessageGroupId"}`)
 				})
 				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("instance already exists"))
+					Expect(errResponse).To(MatchError(ContainSubstring("requires deduplication_scope")))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+			})
 
+			Context("when an unknown encryption is requested", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawParameters = json.RawMessage(`{"encryption": "rot13"}`)
+				})
+				It("should return an appropriate error", func() {
 					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
 					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
 					Expect(ok).To(BeTrue())
-					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(409))
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
 				})
 			})
-		})
-	})
-
-	DescribeTable("last operation fetches stack status",
-		func(cloudformationStatus string, expectedServiceStatus domain.LastOperationState) {
-			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
-				Stacks: []*cloudformation.Stack{
-					{
-						StackName:   aws.String("some stack"),
-						StackStatus: aws.String(cloudformationStatus),
-					},
-				},
-			}, nil)
-			lastOperationData := provideriface.LastOperationData{
-				InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
-			}
-			lastOp, err := sqsProvider.LastOperation(context.Background(), lastOperationData)
-			Expect(err).NotTo(HaveOccurred())
 
-			Expect(lastOp.State).To(Equal(expectedServiceStatus))
-		},
+			Context("when kms_key_arn is provided without kms encryption", func() {
+				BeforeEach(func() {
+					sqsProvider.AllowedKMSKeyARNs = []string{"arn:aws:kms:eu-west-2:123456789012:key/abcd"}
+					provisionData.Details.RawParameters = json.RawMessage(`{"kms_key_arn": "arn:aws:kms:eu-west-2:123456789012:key/abcd"}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError(ContainSubstring("kms_key_arn requires encryption")))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+			})
+
+			Context("when kms_key_arn is not on the allow-list", func() {
+				BeforeEach(func() {
+					sqsProvider.AllowedKMSKeyARNs = []string{"arn:aws:kms:eu-west-2:123456789012:key/abcd"}
+					provisionData.Details.RawParameters = json.RawMessage(`{"encryption": "kms", "kms_key_arn": "arn:aws:kms:eu-west-2:210987654321:key/efgh"}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError(ContainSubstring("is not permitted")))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when an unexpected json key is provided", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawParameters = json.RawMessage(`{"mango": 314, "delay_seconds": 60}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("json: unknown field \"mango\""))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when a json key with a mismatched type is provided", func() {
+				BeforeEach(func() {
+					provisionData.Details.RawParameters = json.RawMessage(`{"delay_seconds": "60"}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("json: cannot unmarshal string into Go struct field QueueParams.delay_seconds of type int"))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when the requested instance id already exists", func() {
+				BeforeEach(func() {
+					fakeCfnClient.CreateStackWithContextReturnsOnCall(0, nil,
+						&fakeClient.MockAWSError{
+							C: "AlreadyExistsException",
+							M: "Got one of those",
+						},
+					)
+					fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName:   aws.String("some stack"),
+								StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+								Tags: []*cloudformation.Tag{
+									{Key: aws.String(sqs.TagServiceId), Value: aws.String("uuid-1")},
+									{Key: aws.String(sqs.TagPlanId), Value: aws.String("uuid-3")},
+								},
+							},
+						},
+					}, nil)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("instance already exists"))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(409))
+				})
+			})
+		})
+	})
+
+	DescribeTable("last operation fetches stack status",
+		func(cloudformationStatus string, expectedServiceStatus domain.LastOperationState) {
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackName:   aws.String("some stack"),
+						StackStatus: aws.String(cloudformationStatus),
+					},
+				},
+			}, nil)
+			lastOperationData := provideriface.LastOperationData{
+				InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+			}
+			lastOp, err := sqsProvider.LastOperation(context.Background(), lastOperationData)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(lastOp.State).To(Equal(expectedServiceStatus))
+		},
 
 		Entry("delete failed",
 			cloudformation.StackStatusDeleteFailed,
@@ -444,6 +1084,240 @@ var _ = Describe("Provider", func() {
 		),
 	)
 
+	Describe("LastOperation with a ClientRequestToken", func() {
+		var (
+			events []*cloudformation.StackEvent
+			lastOp *domain.LastOperation
+		)
+
+		stackEvent := func(token, status string) *cloudformation.StackEvent {
+			return &cloudformation.StackEvent{
+				ClientRequestToken: aws.String(token),
+				LogicalResourceId:  aws.String("some-stack"),
+				ResourceType:       aws.String("AWS::CloudFormation::Stack"),
+				ResourceStatus:     aws.String(status),
+			}
+		}
+
+		BeforeEach(func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackName:   aws.String("some-stack"),
+						StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+					},
+				},
+			}, nil)
+			events = []*cloudformation.StackEvent{
+				stackEvent("update-1", cloudformation.StackStatusUpdateComplete),
+				stackEvent("update-1", cloudformation.StackStatusUpdateInProgress),
+				stackEvent("provision-1", cloudformation.StackStatusCreateComplete),
+				stackEvent("provision-1", cloudformation.StackStatusCreateInProgress),
+			}
+		})
+
+		poll := func(opData string) {
+			fakeCfnClient.DescribeStackEventsWithContextReturns(&cloudformation.DescribeStackEventsOutput{
+				StackEvents: events,
+			}, nil)
+			var err error
+			lastOp, err = sqsProvider.LastOperation(context.Background(), provideriface.LastOperationData{
+				InstanceID:  "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+				PollDetails: domain.PollDetails{OperationData: opData},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("reports the outcome of the operation with the token", func() {
+			poll("update-1")
+			Expect(lastOp.State).To(Equal(domain.Succeeded))
+		})
+
+		It("does not report success for an operation the stack has no record of", func() {
+			poll("update-2")
+			Expect(lastOp.State).To(Equal(domain.Failed))
+			Expect(lastOp.Description).To(ContainSubstring("no record of the operation"))
+		})
+
+		It("reports an operation as pending until its stack event completes", func() {
+			events = append([]*cloudformation.StackEvent{
+				stackEvent("update-2", cloudformation.StackStatusUpdateInProgress),
+			}, events...)
+			poll("update-2")
+			Expect(lastOp.State).To(Equal(domain.InProgress))
+		})
+
+		It("pages through the stack events", func() {
+			fakeCfnClient.DescribeStackEventsWithContextReturnsOnCall(0, &cloudformation.DescribeStackEventsOutput{
+				StackEvents: events[:2],
+				NextToken:   aws.String("page-2"),
+			}, nil)
+			fakeCfnClient.DescribeStackEventsWithContextReturnsOnCall(1, &cloudformation.DescribeStackEventsOutput{
+				StackEvents: events[2:],
+			}, nil)
+			poll("provision-1")
+			Expect(lastOp.State).To(Equal(domain.Succeeded))
+			_, input, _ := fakeCfnClient.DescribeStackEventsWithContextArgsForCall(1)
+			Expect(input.NextToken).To(Equal(aws.String("page-2")))
+		})
+
+		It("stops looking for an operation after ten pages of stack events", func() {
+			fakeCfnClient.DescribeStackEventsWithContextReturns(&cloudformation.DescribeStackEventsOutput{
+				StackEvents: events,
+				NextToken:   aws.String("next-page"),
+			}, nil)
+			lastOp, err := sqsProvider.LastOperation(context.Background(), provideriface.LastOperationData{
+				InstanceID:  "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+				PollDetails: domain.PollDetails{OperationData: "update-2"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOp.State).To(Equal(domain.Failed))
+			Expect(lastOp.Description).To(ContainSubstring("no record of the operation"))
+			Expect(fakeCfnClient.DescribeStackEventsWithContextCallCount()).To(Equal(10))
+		})
+
+		It("uses the stack status for operation data without a token", func() {
+			poll(sqs.UpdateOperation)
+			Expect(lastOp.State).To(Equal(domain.Succeeded))
+			Expect(fakeCfnClient.DescribeStackEventsWithContextCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("LastOperation failure descriptions", func() {
+		event := func(logicalID, resourceType, status, reason string) *cloudformation.StackEvent {
+			return &cloudformation.StackEvent{
+				LogicalResourceId:    aws.String(logicalID),
+				ResourceType:         aws.String(resourceType),
+				ResourceStatus:       aws.String(status),
+				ResourceStatusReason: aws.String(reason),
+			}
+		}
+
+		var stackStatus string
+
+		BeforeEach(func() {
+			stackStatus = cloudformation.StackStatusRollbackComplete
+			fakeCfnClient.DescribeStackEventsWithContextReturns(&cloudformation.DescribeStackEventsOutput{
+				StackEvents: []*cloudformation.StackEvent{
+					event("some-stack", "AWS::CloudFormation::Stack", "ROLLBACK_COMPLETE", ""),
+					event("SecondaryQueue", "AWS::SQS::Queue", "CREATE_FAILED", "Resource creation cancelled"),
+					event("PrimaryQueue", "AWS::SQS::Queue", "CREATE_FAILED", "Invalid value for the parameter MessageRetentionPeriod. (Service: AmazonSQS; Status Code: 400; Error Code: InvalidAttributeValue; Request ID: 1234; Proxy: null)"),
+					event("some-stack", "AWS::CloudFormation::Stack", "CREATE_IN_PROGRESS", "User Initiated"),
+					event("OldQueue", "AWS::SQS::Queue", "CREATE_FAILED", "an earlier operation"),
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackId:     aws.String("arn:aws:cloudformation:eu-west-2:123456789012:stack/some-stack/abc"),
+						StackName:   aws.String("some-stack"),
+						StackStatus: aws.String(stackStatus),
+					},
+				},
+			}, nil)
+		})
+
+		// lastOperationResponse polls the last operation through
+		// LastOperationRecovery, as the broker API does.
+		lastOperationResponse := func() string {
+			handler := sqs.LastOperationRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lastOp, err := sqsProvider.LastOperation(r.Context(), provideriface.LastOperationData{
+					InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				json.NewEncoder(w).Encode(lastOp)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/service_instances/09E1993E-62E2-4040-ADF2-4D3EC741EFE6/last_operation", nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			return rec.Body.String()
+		}
+
+		It("describes the first resource failure of the latest operation", func() {
+			lastOp, err := sqsProvider.LastOperation(context.Background(), provideriface.LastOperationData{
+				InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOp.State).To(Equal(domain.Failed))
+			Expect(lastOp.Description).To(Equal("failed: ROLLBACK_COMPLETE: PrimaryQueue: Invalid value for the parameter MessageRetentionPeriod."))
+
+			Expect(fakeCfnClient.DescribeStackEventsWithContextCallCount()).To(Equal(1))
+			_, input, _ := fakeCfnClient.DescribeStackEventsWithContextArgsForCall(0)
+			Expect(input.StackName).To(Equal(aws.String("arn:aws:cloudformation:eu-west-2:123456789012:stack/some-stack/abc")))
+		})
+
+		It("describes binding failures too", func() {
+			lastOp, err := sqsProvider.LastBindingOperation(context.Background(), provideriface.LastBindingOperationData{
+				BindingID: "c6ea1339-7ade-4952-9247-e419b59e7b67",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOp.Description).To(ContainSubstring("PrimaryQueue: Invalid value for the parameter MessageRetentionPeriod."))
+		})
+
+		Context("when an update has been rolled back", func() {
+			BeforeEach(func() {
+				stackStatus = cloudformation.StackStatusUpdateRollbackComplete
+			})
+
+			It("says the service is still usable", func() {
+				lastOp, err := sqsProvider.LastOperation(context.Background(), provideriface.LastOperationData{
+					InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOp.State).To(Equal(domain.Failed))
+				Expect(lastOp.Description).To(ContainSubstring("still usable"))
+			})
+
+			It("tells the platform the instance is usable and the update repeatable", func() {
+				Expect(lastOperationResponse()).To(MatchJSON(`{
+					"state": "failed",
+					"description": "failed: UPDATE_ROLLBACK_COMPLETE: PrimaryQueue: Invalid value for the parameter MessageRetentionPeriod. (the service is unchanged and still usable, the update can be retried)",
+					"instance_usable": true,
+					"update_repeatable": true
+				}`))
+			})
+		})
+
+		Context("when rolling back an update has failed", func() {
+			BeforeEach(func() {
+				stackStatus = cloudformation.StackStatusUpdateRollbackFailed
+			})
+
+			It("tells the platform the instance is not usable", func() {
+				Expect(lastOperationResponse()).To(MatchJSON(`{
+					"state": "failed",
+					"description": "failed: UPDATE_ROLLBACK_FAILED: PrimaryQueue: Invalid value for the parameter MessageRetentionPeriod.",
+					"instance_usable": false,
+					"update_repeatable": false
+				}`))
+			})
+		})
+
+		It("leaves out instance_usable and update_repeatable when a provision fails", func() {
+			Expect(lastOperationResponse()).To(MatchJSON(`{
+				"state": "failed",
+				"description": "failed: ROLLBACK_COMPLETE: PrimaryQueue: Invalid value for the parameter MessageRetentionPeriod."
+			}`))
+		})
+
+		Context("when the stack events cannot be read", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DescribeStackEventsWithContextReturns(nil, fmt.Errorf("throttled"))
+			})
+
+			It("falls back to the stack status", func() {
+				lastOp, err := sqsProvider.LastOperation(context.Background(), provideriface.LastOperationData{
+					InstanceID: "09E1993E-62E2-4040-ADF2-4D3EC741EFE6",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOp.Description).To(Equal("failed: ROLLBACK_COMPLETE"))
+			})
+		})
+	})
+
 	DescribeTable("last binding operation fetches stack status",
 		func(cloudformationStatus string, expectedServiceStatus domain.LastOperationState) {
 			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
@@ -518,6 +1392,104 @@ var _ = Describe("Provider", func() {
 		),
 	)
 
+	Describe("GetInstance", func() {
+		var (
+			instanceSpec *domain.GetInstanceDetailsSpec
+			instanceErr  error
+		)
+
+		JustBeforeEach(func() {
+			instanceSpec, instanceErr = sqsProvider.GetInstance(context.Background(), "instance-id")
+		})
+
+		Context("when the queue stack does not exist", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DescribeStacksWithContextReturns(nil, &fakeClient.MockAWSError{
+					C: "ValidationError",
+					M: "Stack with id testprefix-instance-id does not exist",
+				})
+			})
+			It("returns ErrInstanceDoesNotExist", func() {
+				Expect(instanceErr).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+
+		Context("when the queue stack is still being created", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{StackStatus: aws.String(cloudformation.StackStatusCreateInProgress)},
+					},
+				}, nil)
+			})
+			It("returns ErrInstanceDoesNotExist", func() {
+				Expect(instanceErr).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+
+		Context("when the queue stack is being updated", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{StackStatus: aws.String(cloudformation.StackStatusUpdateInProgress)},
+					},
+				}, nil)
+			})
+			It("returns a 422", func() {
+				castErrResponse, ok := instanceErr.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+			})
+		})
+
+		Context("when the queue stack exists", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+							Parameters: []*cloudformation.Parameter{
+								{ParameterKey: aws.String(sqs.ParamMessageRetentionPeriod), ParameterValue: aws.String("1209600")},
+								{ParameterKey: aws.String(sqs.ParamVisibilityTimeout), ParameterValue: aws.String("60")},
+								{ParameterKey: aws.String(sqs.ParamRedriveMaxReceiveCount), ParameterValue: aws.String("3")},
+								{ParameterKey: aws.String(sqs.ParamEncryption), ParameterValue: aws.String("sqs")},
+								{ParameterKey: aws.String(sqs.ParamKMSKeyARN), ParameterValue: aws.String("")},
+							},
+							Tags: []*cloudformation.Tag{
+								{Key: aws.String(sqs.TagServiceId), Value: aws.String("uuid-1")},
+								{Key: aws.String(sqs.TagPlanId), Value: aws.String("uuid-2")},
+							},
+							Outputs: []*cloudformation.Output{
+								{OutputKey: aws.String(sqs.OutputPrimaryQueueURL), OutputValue: aws.String("https://sqs.example.com/primary")},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			It("returns the service and plan from the stack tags", func() {
+				Expect(instanceErr).ToNot(HaveOccurred())
+				Expect(instanceSpec.ServiceID).To(Equal("uuid-1"))
+				Expect(instanceSpec.PlanID).To(Equal("uuid-2"))
+			})
+
+			It("returns the live queue configuration", func() {
+				Expect(instanceErr).ToNot(HaveOccurred())
+				params, err := json.Marshal(instanceSpec.Parameters)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(params).To(MatchJSON(`{
+					"message_retention_period": 1209600,
+					"visibility_timeout": 60,
+					"redrive_max_receive_count": 3,
+					"encryption": "sqs",
+					"status": "UPDATE_COMPLETE",
+					"tags": {"ServiceID": "uuid-1", "PlanID": "uuid-2"},
+					"outputs": {"PrimaryQueueURL": "https://sqs.example.com/primary"}
+				}`))
+			})
+		})
+	})
+
 	Describe("GetBinding", func() {
 		var (
 			bindingSpec *domain.GetBindingSpec
@@ -641,12 +1613,13 @@ var _ = Describe("Provider", func() {
 			}
 			spec, err := sqsProvider.Deprovision(context.Background(), deprovisionData)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(spec.OperationData).To(Equal(sqs.DeprovisionOperation))
+			Expect(spec.OperationData).To(HavePrefix(sqs.DeprovisionOperation + "-"))
 			Expect(spec.IsAsync).To(BeTrue())
 
 			Expect(fakeCfnClient.DeleteStackWithContextCallCount()).To(Equal(1))
 			ctx, input, _ := fakeCfnClient.DeleteStackWithContextArgsForCall(0)
 			Expect(ctx).ToNot(BeNil())
+			Expect(input.ClientRequestToken).To(Equal(aws.String(spec.OperationData)))
 			Expect(input.StackName).To(Equal(aws.String(fmt.Sprintf("testprefix-%s", deprovisionData.InstanceID))))
 		})
 	})
@@ -689,31 +1662,135 @@ var _ = Describe("Provider", func() {
 			}
 			spec, err := sqsProvider.Unbind(context.Background(), unbindData)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(spec.OperationData).To(Equal(sqs.UnbindOperation))
+			Expect(spec.OperationData).To(HavePrefix(sqs.UnbindOperation + "-"))
 			Expect(spec.IsAsync).To(BeTrue())
 
 			Expect(fakeCfnClient.DeleteStackWithContextCallCount()).To(Equal(1))
 			ctx, input, _ := fakeCfnClient.DeleteStackWithContextArgsForCall(0)
 			Expect(ctx).ToNot(BeNil())
+			Expect(input.ClientRequestToken).To(Equal(aws.String(spec.OperationData)))
 			Expect(input.StackName).To(Equal(aws.String(fmt.Sprintf("testprefix-%s", unbindData.BindingID))))
 		})
 	})
 
-	Describe("Bind", func() {
-		var (
-			bindData         provideriface.BindData
-			createStackInput *cloudformation.CreateStackInput
-			user             *goformationiam.User
-			policy           *goformationiam.Policy
-			arn1             = "arn-1"
-			arn2             = "arn-2"
-		)
+	Describe("waiting for operations", func() {
+		var pollingInterval time.Duration
 
 		BeforeEach(func() {
-			bindData = provideriface.BindData{
-				InstanceID:   "a5da1b66-da42-4c83-b806-f287bc589ab3",
-				BindingID:    "f56687df-e3d0-452a-9755-1a6d6d9e248f",
-				AsyncAllowed: true,
+			pollingInterval = sqs.PollingInterval
+			sqs.PollingInterval = time.Millisecond
+			sqsProvider.Logger = lager.NewLogger("test")
+		})
+
+		AfterEach(func() {
+			sqs.PollingInterval = pollingInterval
+		})
+
+		stackWithStatus := func(status string) *cloudformation.DescribeStacksOutput {
+			return &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackName:   aws.String("some stack"),
+						StackStatus: aws.String(status),
+					},
+				},
+			}
+		}
+
+		It("waits for a provision to complete", func() {
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, stackWithStatus(cloudformation.StackStatusCreateInProgress), nil)
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, stackWithStatus(cloudformation.StackStatusCreateComplete), nil)
+
+			Expect(sqsProvider.WaitForProvision(context.Background(), "instance-id", sqs.ProvisionOperation)).To(Succeed())
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+			Expect(fakeCfnClient.DeleteStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("removes the queue stack when a provision fails", func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(stackWithStatus(cloudformation.StackStatusCreateFailed), nil)
+			fakeCfnClient.DescribeStackEventsWithContextReturns(&cloudformation.DescribeStackEventsOutput{
+				StackEvents: []*cloudformation.StackEvent{
+					{
+						ClientRequestToken: aws.String("provision-1"),
+						ResourceType:       aws.String("AWS::CloudFormation::Stack"),
+						ResourceStatus:     aws.String(cloudformation.StackStatusCreateFailed),
+					},
+				},
+			}, nil)
+
+			err := sqsProvider.WaitForProvision(context.Background(), "instance-id", "provision-1")
+			Expect(err).To(MatchError(HavePrefix("failed: CREATE_FAILED")))
+			Eventually(fakeCfnClient.DeleteStackWithContextCallCount).Should(Equal(1))
+			_, input, _ := fakeCfnClient.DeleteStackWithContextArgsForCall(0)
+			Expect(input.StackName).To(Equal(aws.String("testprefix-instance-id")))
+			Expect(*input.ClientRequestToken).To(HavePrefix(sqs.DeprovisionOperation + "-"))
+		})
+
+		It("waits for a deprovision to complete", func() {
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, stackWithStatus(cloudformation.StackStatusDeleteInProgress), nil)
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, nil, &fakeClient.MockAWSError{
+				C: "ValidationError",
+				M: "Stack with id testprefix-instance-id does not exist",
+			})
+
+			Expect(sqsProvider.WaitForDeprovision(context.Background(), "instance-id", sqs.DeprovisionOperation)).To(Succeed())
+		})
+
+		It("gives up after the timeout", func() {
+			sqsProvider.Timeout = 20 * time.Millisecond
+			fakeCfnClient.DescribeStacksWithContextReturns(stackWithStatus(cloudformation.StackStatusDeleteInProgress), nil)
+
+			err := sqsProvider.WaitForDeprovision(context.Background(), "instance-id", sqs.DeprovisionOperation)
+			Expect(err).To(Equal(sqs.ErrOperationDeadlineExceeded))
+		})
+
+		It("waits for the binding stack to be deleted when unbinding synchronously", func() {
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, stackWithStatus(cloudformation.StackStatusCreateComplete), nil)
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, stackWithStatus(cloudformation.StackStatusDeleteInProgress), nil)
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(2, nil, &fakeClient.MockAWSError{
+				C: "ValidationError",
+				M: "Stack with id testprefix-binding-id does not exist",
+			})
+			fakeCfnClient.DescribeStackEventsWithContextStub = func(ctx aws.Context, input *cloudformation.DescribeStackEventsInput, opts ...request.Option) (*cloudformation.DescribeStackEventsOutput, error) {
+				_, deleteInput, _ := fakeCfnClient.DeleteStackWithContextArgsForCall(0)
+				return &cloudformation.DescribeStackEventsOutput{
+					StackEvents: []*cloudformation.StackEvent{
+						{
+							ClientRequestToken: deleteInput.ClientRequestToken,
+							ResourceType:       aws.String("AWS::CloudFormation::Stack"),
+							ResourceStatus:     aws.String(cloudformation.StackStatusDeleteInProgress),
+						},
+					},
+				}, nil
+			}
+
+			spec, err := sqsProvider.Unbind(context.Background(), provideriface.UnbindData{
+				InstanceID:   "instance-id",
+				BindingID:    "binding-id",
+				AsyncAllowed: false,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeFalse())
+			Expect(fakeCfnClient.DeleteStackWithContextCallCount()).To(Equal(1))
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(3))
+		})
+	})
+
+	Describe("Bind", func() {
+		var (
+			bindData         provideriface.BindData
+			createStackInput *cloudformation.CreateStackInput
+			user             *goformationiam.User
+			policy           *goformationiam.Policy
+			arn1             = "arn-1"
+			arn2             = "arn-2"
+		)
+
+		BeforeEach(func() {
+			bindData = provideriface.BindData{
+				InstanceID:   "a5da1b66-da42-4c83-b806-f287bc589ab3",
+				BindingID:    "f56687df-e3d0-452a-9755-1a6d6d9e248f",
+				AsyncAllowed: true,
 			}
 			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
 				Stacks: []*cloudformation.Stack{
@@ -741,7 +1818,7 @@ var _ = Describe("Provider", func() {
 			JustBeforeEach(func() {
 				spec, err := sqsProvider.Bind(context.Background(), bindData)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(spec.OperationData).To(Equal(sqs.BindOperation))
+				Expect(spec.OperationData).To(HavePrefix(sqs.BindOperation + "-"))
 				Expect(spec.IsAsync).To(BeTrue())
 
 				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(1))
@@ -749,6 +1826,7 @@ var _ = Describe("Provider", func() {
 				var ctx context.Context
 				ctx, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
 				Expect(ctx).ToNot(BeNil())
+				Expect(createStackInput.ClientRequestToken).To(Equal(aws.String(spec.OperationData)))
 
 				Expect(createStackInput.TemplateBody).ToNot(BeNil())
 				t, err := goformation.ParseYAML([]byte(*createStackInput.TemplateBody))
@@ -822,6 +1900,124 @@ var _ = Describe("Provider", func() {
 				)
 			})
 
+			Context("when the queues are encrypted with KMS", func() {
+				BeforeEach(func() {
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName:   aws.String("some stack"),
+								StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+								Outputs: []*cloudformation.Output{
+									{
+										OutputKey:   aws.String(sqs.OutputPrimaryQueueARN),
+										OutputValue: aws.String(arn1),
+									},
+									{
+										OutputKey:   aws.String(sqs.OutputSecondaryQueueARN),
+										OutputValue: aws.String(arn2),
+									},
+									{
+										OutputKey:   aws.String(sqs.OutputKMSKeyARN),
+										OutputValue: aws.String("arn-key"),
+									},
+								},
+							},
+						},
+					}, nil)
+				})
+				It("should grant use of the key", func() {
+					Expect(policy.PolicyDocument).To(
+						HaveKeyWithValue("Statement", ContainElement(And(
+							HaveKeyWithValue("Resource", ConsistOf("arn-key")),
+							HaveKeyWithValue("Action", ConsistOf("kms:Decrypt", "kms:GenerateDataKey")),
+						))),
+					)
+				})
+			})
+
+			Context("when the instance has named queues", func() {
+				BeforeEach(func() {
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName:   aws.String("some stack"),
+								StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+								Parameters: []*cloudformation.Parameter{
+									{
+										ParameterKey:   aws.String(sqs.ParamQueues),
+										ParameterValue: aws.String(`[{"name":"jobs"}]`),
+									},
+								},
+								Outputs: []*cloudformation.Output{
+									{
+										OutputKey:   aws.String(sqs.OutputPrimaryQueueARN),
+										OutputValue: aws.String(arn1),
+									},
+									{
+										OutputKey:   aws.String(sqs.OutputSecondaryQueueARN),
+										OutputValue: aws.String(arn2),
+									},
+									{
+										OutputKey:   aws.String("QueueJobsARN"),
+										OutputValue: aws.String("arn-jobs"),
+									},
+									{
+										OutputKey:   aws.String("QueueJobsURL"),
+										OutputValue: aws.String("https://sqs.eu-west-2.amazonaws.com/123456789012/jobs"),
+									},
+								},
+							},
+						},
+					}, nil)
+				})
+				It("should grant access to the named queues", func() {
+					Expect(policy.PolicyDocument).To(
+						HaveKeyWithValue("Statement", ContainElement(
+							HaveKeyWithValue("Resource", ConsistOf(arn1, arn2, "arn-jobs")),
+						)),
+					)
+				})
+				It("should put the named queue URLs in the credentials", func() {
+					Expect(*createStackInput.TemplateBody).To(ContainSubstring(
+						`"queue_urls":{"jobs":"https://sqs.eu-west-2.amazonaws.com/123456789012/jobs"}`,
+					))
+				})
+			})
+
+			Context("when the instance has a topic", func() {
+				BeforeEach(func() {
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName:   aws.String("some stack"),
+								StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+								Outputs: []*cloudformation.Output{
+									{
+										OutputKey:   aws.String(sqs.OutputPrimaryQueueARN),
+										OutputValue: aws.String(arn1),
+									},
+									{
+										OutputKey:   aws.String(sqs.OutputTopicARN),
+										OutputValue: aws.String("arn-topic"),
+									},
+								},
+							},
+						},
+					}, nil)
+				})
+				It("should grant access to the topic", func() {
+					Expect(policy.PolicyDocument).To(
+						HaveKeyWithValue("Statement", ContainElement(And(
+							HaveKeyWithValue("Resource", ConsistOf("arn-topic", "arn-topic:*")),
+							HaveKeyWithValue("Action", ContainElement("sns:Publish")),
+						))),
+					)
+				})
+				It("should put the topic ARN in the credentials", func() {
+					Expect(*createStackInput.TemplateBody).To(ContainSubstring(`"topic_arn":"arn-topic"`))
+				})
+			})
+
 			Context("when permission boundary is provided", func() {
 				BeforeEach(func() {
 					sqsProvider.PermissionsBoundary = "arn:fake:permission:boundary"
@@ -858,138 +2054,659 @@ var _ = Describe("Provider", func() {
 					)
 				})
 			})
+
+			Context("when an access_policy from the broker config is provided", func() {
+				BeforeEach(func() {
+					sqsProvider.AccessPolicies = map[string]sqs.AccessPolicyConfig{
+						"autoscaler": {
+							Actions:    []string{"sqs:GetQueueAttributes"},
+							Statements: []sqs.PolicyStatement{{Actions: []string{"cloudwatch:GetMetricData"}}},
+						},
+					}
+					bindData.Details.RawParameters = json.RawMessage(`{"access_policy": "autoscaler"}`)
+				})
+				It("should create user with that access policy", func() {
+					Expect(policy.PolicyDocument).To(
+						HaveKeyWithValue("Statement", ConsistOf(
+							HaveKeyWithValue("Action", ConsistOf("sqs:GetQueueAttributes")),
+							HaveKeyWithValue("Action", ConsistOf("cloudwatch:GetMetricData")),
+						)),
+					)
+				})
+			})
 		})
 
-		Context("Failures", func() {
-			var errResponse error
+		Context("when the instance has tags", func() {
+			BeforeEach(func() {
+				sqsProvider.Tags = map[string]string{"cost-centre": "1234"}
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Parameters: []*cloudformation.Parameter{
+								{
+									ParameterKey:   aws.String(sqs.ParamTags),
+									ParameterValue: aws.String(`{"team":"payments"}`),
+								},
+							},
+						},
+					},
+				}, nil)
+			})
 
-			JustBeforeEach(func() {
-				var spec *domain.Binding
-				spec, errResponse = sqsProvider.Bind(context.Background(), bindData)
-				Expect(errResponse).To(HaveOccurred())
-				Expect(spec).To(BeNil())
+			It("tags the binding stack with them and the operator's tags", func() {
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Tags).To(ContainElements(
+					&cloudformation.Tag{Key: aws.String("cost-centre"), Value: aws.String("1234")},
+					&cloudformation.Tag{Key: aws.String("team"), Value: aws.String("payments")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagName), Value: aws.String(bindData.BindingID)},
+					&cloudformation.Tag{Key: aws.String(sqs.TagInstanceId), Value: aws.String(bindData.InstanceID)},
+				))
 			})
+		})
 
-			Context("when an unexpected json key is provided", func() {
-				BeforeEach(func() {
-					bindData.Details.RawParameters = json.RawMessage(`{"pineapple": 123, "access_policy": "full"}`)
-				})
-				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("json: unknown field \"pineapple\""))
+		Context("when rotating a binding", func() {
+			var (
+				ctx               context.Context
+				predecessorStack  *cloudformation.Stack
+				predecessorBindID = "0e1ba1ac-5d2c-4b8a-9d6e-6a5c2b3f8f10"
+			)
 
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
-					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
-					Expect(ok).To(BeTrue())
-					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
-				})
-				It("should not have created a stack", func() {
-					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
-				})
+			BeforeEach(func() {
+				ctx = sqs.WithPredecessorBindingID(context.Background(), predecessorBindID)
+				predecessorStack = &cloudformation.Stack{
+					StackName:   aws.String("predecessor stack"),
+					StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+					Tags: []*cloudformation.Tag{
+						{Key: aws.String(sqs.TagInstanceId), Value: aws.String(bindData.InstanceID)},
+						{Key: aws.String(sqs.TagAccessPolicy), Value: aws.String(sqs.AccessPolicyConsumer)},
+					},
+				}
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{predecessorStack},
+				}, nil)
 			})
 
-			Context("when a non-string access_policy is provided", func() {
-				BeforeEach(func() {
-					bindData.Details.RawParameters = json.RawMessage(`{"access_policy":[123]}`)
-				})
-				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("json: cannot unmarshal array into Go struct field UserTemplateBuilder.access_policy of type string"))
+			It("creates a new binding with the predecessor's access policy", func() {
+				_, err := sqsProvider.Bind(ctx, bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, input, _ := fakeCfnClient.DescribeStacksWithContextArgsForCall(1)
+				Expect(input.StackName).To(Equal(aws.String("testprefix-" + predecessorBindID)))
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.StackName).To(Equal(aws.String("testprefix-" + bindData.BindingID)))
+				Expect(createStackInput.Tags).To(ContainElement(
+					&cloudformation.Tag{Key: aws.String(sqs.TagAccessPolicy), Value: aws.String(sqs.AccessPolicyConsumer)},
+				))
+				t := parseTemplate(*createStackInput.TemplateBody, nil)
+				Expect(t.Resources[sqs.ResourcePolicy].Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ContainElement(
+					HaveKeyWithValue("Action", ContainElement("sqs:ReceiveMessage")),
+				))))
+				Expect(t.Resources[sqs.ResourcePolicy].Properties).ToNot(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ContainElement(
+					HaveKeyWithValue("Action", ContainElement("sqs:SendMessage")),
+				))))
+			})
 
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
-					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+			It("rejects parameters", func() {
+				bindData.Details.RawParameters = json.RawMessage(`{"access_policy": "full"}`)
+				_, err := sqsProvider.Bind(ctx, bindData)
+				Expect(err).To(MatchError(ContainSubstring("parameters cannot be given when rotating a binding")))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			})
+
+			DescribeTable("when the predecessor cannot be rotated",
+				func(change func(), expectedErr string) {
+					change()
+					_, err := sqsProvider.Bind(ctx, bindData)
+					Expect(err).To(MatchError(expectedErr))
+					castErrResponse, ok := err.(*brokerapi.FailureResponse)
 					Expect(ok).To(BeTrue())
-					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
-				})
-				It("should not have created a stack", func() {
-					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
-				})
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+				},
+				Entry("it does not exist", func() {
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, nil, &fakeClient.MockAWSError{
+						C: "ValidationError",
+						M: "Stack with id testprefix-" + predecessorBindID + " does not exist",
+					})
+				}, "predecessor binding "+predecessorBindID+" does not exist"),
+				Entry("it belongs to another instance", func() {
+					predecessorStack.Tags[0].Value = aws.String("another-instance")
+				}, "predecessor binding "+predecessorBindID+" does not belong to this service instance"),
+				Entry("it is still being created", func() {
+					predecessorStack.StackStatus = aws.String(cloudformation.StackStatusCreateInProgress)
+				}, "predecessor binding "+predecessorBindID+" is not ready to be rotated"),
+				Entry("its access policy is not recorded", func() {
+					predecessorStack.Tags = predecessorStack.Tags[:1]
+				}, "predecessor binding "+predecessorBindID+" cannot be rotated as its access policy is not recorded"),
+				Entry("the plan is a broadcast plan", func() {
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {QueueType: sqs.QueueTypeStandard, Topic: true, Broadcast: true},
+					}
+					bindData.Details.PlanID = "uuid-2"
+				}, "bindings on broadcast plans cannot be rotated: each binding has its own queue"),
+			)
+		})
+
+		Context("when the binding is for an IAM role", func() {
+			BeforeEach(func() {
+				sqsProvider.RoleTrustPrincipalARN = "arn:aws:iam::123456789012:role/platform"
+				sqsProvider.PermissionsBoundary = "arn:aws:iam::123456789012:policy/boundary"
+				bindData.Details.RawParameters = json.RawMessage(`{"binding_type": "role", "access_policy": "producer"}`)
 			})
 
-			Context("when an unknown access_policy is provided", func() {
-				BeforeEach(func() {
-					bindData.Details.RawParameters = json.RawMessage(`{"access_policy": "whatever"}`)
-				})
-				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("unknown access policy \"whatever\""))
+			It("creates a role the trusted principal can assume with an external ID", func() {
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Tags).To(ContainElement(
+					&cloudformation.Tag{Key: aws.String(sqs.TagBindingType), Value: aws.String(sqs.BindingTypeRole)},
+				))
+				t, err := goformation.ParseYAML([]byte(*createStackInput.TemplateBody))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(t.Resources).ToNot(HaveKey(sqs.ResourceUser))
+				role, err := t.GetIAMRoleWithName(sqs.ResourceRole)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(role.PermissionsBoundary).To(Equal("arn:aws:iam::123456789012:policy/boundary"))
+				Expect(role.AssumeRolePolicyDocument).To(HaveKeyWithValue("Statement", ConsistOf(And(
+					HaveKeyWithValue("Principal", HaveKeyWithValue("AWS", "arn:aws:iam::123456789012:role/platform")),
+					HaveKeyWithValue("Condition", HaveKeyWithValue("StringEquals", HaveKeyWithValue("sts:ExternalId", Not(BeEmpty())))),
+				))))
+				policy, err := t.GetIAMPolicyWithName(sqs.ResourcePolicy)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(policy.PolicyDocument).To(HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Action", ContainElement("sqs:SendMessage")),
+				)))
+			})
 
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
-					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
-					Expect(ok).To(BeTrue())
-					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
-				})
-				It("should not have created a stack", func() {
-					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
-				})
+			It("uses the plan's binding type by default", func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-2": {QueueType: sqs.QueueTypeStandard, BindingTypes: []sqs.BindingType{sqs.BindingTypeRole}},
+				}
+				bindData.Details.PlanID = "uuid-2"
+				bindData.Details.RawParameters = nil
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Tags).To(ContainElement(
+					&cloudformation.Tag{Key: aws.String(sqs.TagBindingType), Value: aws.String(sqs.BindingTypeRole)},
+				))
+				Expect(parseTemplate(*createStackInput.TemplateBody, nil).Resources).To(HaveKey(sqs.ResourceRole))
 			})
 
-			Context("when a nonexistent queue stack is specified", func() {
-				BeforeEach(func() {
-					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, nil,
-						&fakeClient.MockAWSError{
-							C: "ValidationError",
-							M: "Something something does not exist something",
-						},
-					)
-				})
-				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("instance does not exist"))
+			It("rejects binding types the plan does not allow", func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-2": {QueueType: sqs.QueueTypeStandard, BindingTypes: []sqs.BindingType{sqs.BindingTypeUser}},
+				}
+				bindData.Details.PlanID = "uuid-2"
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).To(MatchError("binding_type: must be one of user"))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
+			})
 
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
-					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
-					Expect(ok).To(BeTrue())
-					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(410))
-				})
-				It("should not have created a stack", func() {
-					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
-				})
+			It("rejects role bindings when no principal is trusted", func() {
+				sqsProvider.RoleTrustPrincipalARN = ""
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).To(MatchError("role bindings are not enabled on this broker"))
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(Equal(0))
 			})
 
-			Context("when the requested binding id already exists", func() {
-				BeforeEach(func() {
-					fakeCfnClient.CreateStackWithContextReturnsOnCall(0, nil,
-						&fakeClient.MockAWSError{
-							C: "AlreadyExistsException",
-							M: "Got one of those",
+			It("gives a rotated binding the predecessor's binding type", func() {
+				bindData.Details.RawParameters = nil
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{{
+						StackName:   aws.String("predecessor stack"),
+						StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+						Tags: []*cloudformation.Tag{
+							{Key: aws.String(sqs.TagInstanceId), Value: aws.String(bindData.InstanceID)},
+							{Key: aws.String(sqs.TagAccessPolicy), Value: aws.String(sqs.AccessPolicyFull)},
+							{Key: aws.String(sqs.TagBindingType), Value: aws.String(sqs.BindingTypeRole)},
 						},
-					)
-				})
-				It("should return an appropriate error", func() {
-					Expect(errResponse).To(MatchError("binding already exists"))
-
-					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
-					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
-					Expect(ok).To(BeTrue())
-					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(409))
-				})
+					}},
+				}, nil)
+				_, err := sqsProvider.Bind(sqs.WithPredecessorBindingID(context.Background(), "predecessor"), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(parseTemplate(*createStackInput.TemplateBody, nil).Resources).To(HaveKey(sqs.ResourceRole))
 			})
 		})
-	})
 
-	Context("Update", func() {
-		var (
-			updateData       provideriface.UpdateData
-			updateStackInput *cloudformation.UpdateStackInput
-		)
+		Context("when the platform names the app being bound", func() {
+			BeforeEach(func() {
+				bindData.Details.BindResource = &domain.BindResource{AppGuid: "a1b2c3d4-9401-4b45-a7e7-40b17819954f"}
+			})
 
-		BeforeEach(func() {
-			updateData = provideriface.UpdateData{
-				InstanceID: "a5da1b66-da42-4c83-b806-f287bc589ab3",
-				Plan: domain.ServicePlan{
-					Name: "standard",
-					ID:   "uuid-2",
-				},
-				Details: domain.UpdateDetails{
-					ServiceID:     "27b72d3f-9401-4b45-a7e7-40b17819954f",
-					RawParameters: json.RawMessage(`{}`),
-				},
-			}
-			updateStackInput = nil
+			It("tags the binding stack with the app", func() {
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				Expect(createStackInput.Tags).To(ContainElement(
+					&cloudformation.Tag{Key: aws.String(sqs.TagAppGUID), Value: aws.String("a1b2c3d4-9401-4b45-a7e7-40b17819954f")},
+				))
+			})
 		})
 
-		JustBeforeEach(func() {
+		Context("when the plan is a broadcast plan", func() {
+			var t parsedTemplate
+
+			BeforeEach(func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-2": {QueueType: sqs.QueueTypeFIFO, Topic: true, Broadcast: true},
+				}
+				bindData.Details.PlanID = "uuid-2"
+				bindData.Details.RawParameters = json.RawMessage(`{
+					"filter_policy": {"event": ["order_placed"]},
+					"raw_message_delivery": true
+				}`)
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Parameters: []*cloudformation.Parameter{
+								{
+									ParameterKey:   aws.String(sqs.ParamVisibilityTimeout),
+									ParameterValue: aws.String("60"),
+								},
+							},
+							Outputs: []*cloudformation.Output{
+								{
+									OutputKey:   aws.String(sqs.OutputTopicARN),
+									OutputValue: aws.String("arn-topic.fifo"),
+								},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			JustBeforeEach(func() {
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				t = parseTemplate(*createStackInput.TemplateBody, nil)
+			})
+
+			It("creates a queue for the binding with the instance's settings", func() {
+				queue := t.Queue(sqs.ResourceBindingQueue)
+				Expect(queue.QueueName).To(Equal(fmt.Sprintf("testprefix-%s.fifo", bindData.BindingID)))
+				Expect(queue.FifoQueue).To(BeTrue())
+				Expect(queue.VisibilityTimeout).To(Equal(60))
+			})
+
+			It("subscribes the queue to the topic with the binding's settings", func() {
+				subscription := t.Resources[sqs.ResourceBindingQueueSubscription]
+				Expect(subscription.Properties).To(HaveKeyWithValue("TopicArn", "arn-topic.fifo"))
+				Expect(subscription.Properties).To(HaveKeyWithValue("FilterPolicy", HaveKey("event")))
+				Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", true))
+			})
+		})
+
+		Context("when the plan is an unconfigured catalog plan named fifo", func() {
+			BeforeEach(func() {
+				sqsProvider.Catalog = []domain.Service{{
+					ID:    "uuid-1",
+					Plans: []domain.ServicePlan{{ID: "uuid-7", Name: "fifo"}},
+				}}
+				bindData.Details.ServiceID = "uuid-1"
+				bindData.Details.PlanID = "uuid-7"
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Outputs: []*cloudformation.Output{
+								{
+									OutputKey:   aws.String(sqs.OutputPrimaryQueueARN),
+									OutputValue: aws.String("arn-1.fifo"),
+								},
+								{
+									OutputKey:   aws.String(sqs.OutputSecondaryQueueARN),
+									OutputValue: aws.String("arn-2.fifo"),
+								},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			It("binds to the FIFO queues as it does on provision", func() {
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).NotTo(HaveOccurred())
+				_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+				t := parseTemplate(*createStackInput.TemplateBody, nil)
+				Expect(t.Resources).ToNot(HaveKey(sqs.ResourceBindingQueue))
+				policyDocument := t.Resources[sqs.ResourcePolicy].Properties["PolicyDocument"]
+				Expect(policyDocument).To(HaveKeyWithValue("Statement", ContainElement(
+					HaveKeyWithValue("Resource", ConsistOf("arn-1.fifo", "arn-2.fifo")),
+				)))
+			})
+		})
+
+		Context("when an identical request is repeated", func() {
+			BeforeEach(func() {
+				fakeCfnClient.CreateStackWithContextReturns(nil, &fakeClient.MockAWSError{
+					C: "AlreadyExistsException",
+					M: "Stack already exists",
+				})
+				bindingStack := &cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Tags: []*cloudformation.Tag{
+								{Key: aws.String(sqs.TagInstanceId), Value: aws.String(bindData.InstanceID)},
+								{Key: aws.String(sqs.TagAccessPolicy), Value: aws.String(sqs.AccessPolicyFull)},
+							},
+							Outputs: []*cloudformation.Output{
+								{
+									OutputKey:   aws.String(sqs.OutputCredentialsARN),
+									OutputValue: aws.String("arn:to:creds"),
+								},
+							},
+						},
+					},
+				}
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, bindingStack, nil)
+				fakeCfnClient.DescribeStacksWithContextReturnsOnCall(2, bindingStack, nil)
+				fakeCfnClient.GetSecretValueWithContextReturns(&secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(`{"aws_access_key_id": "key"}`),
+				}, nil)
+			})
+
+			It("returns the existing credentials", func() {
+				spec, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(spec.AlreadyExists).To(BeTrue())
+				Expect(spec.Credentials).To(Equal(map[string]interface{}{"aws_access_key_id": "key"}))
+			})
+
+			It("conflicts when the access policy differs", func() {
+				bindData.Details.RawParameters = json.RawMessage(`{"access_policy": "producer"}`)
+
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+			})
+
+			It("conflicts when the binding type differs", func() {
+				sqsProvider.RoleTrustPrincipalARN = "arn:aws:iam::123456789012:role/platform"
+				bindData.Details.RawParameters = json.RawMessage(`{"binding_type": "role"}`)
+
+				_, err := sqsProvider.Bind(context.Background(), bindData)
+				Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+			})
+
+			Context("on a broadcast plan", func() {
+				var bindingTags []*cloudformation.Tag
+
+				BeforeEach(func() {
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {QueueType: sqs.QueueTypeStandard, Topic: true, Broadcast: true},
+					}
+					bindData.Details.PlanID = "uuid-2"
+					bindData.Details.RawParameters = json.RawMessage(`{"filter_policy": {"event": ["order_placed"]}, "raw_message_delivery": true}`)
+					fakeCfnClient.DescribeStacksWithContextStub = func(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+						if aws.StringValue(input.StackName) == "testprefix-"+bindData.BindingID {
+							return &cloudformation.DescribeStacksOutput{
+								Stacks: []*cloudformation.Stack{
+									{
+										StackName:   input.StackName,
+										StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+										Tags:        bindingTags,
+										Outputs: []*cloudformation.Output{
+											{OutputKey: aws.String(sqs.OutputCredentialsARN), OutputValue: aws.String("arn:to:creds")},
+										},
+									},
+								},
+							}, nil
+						}
+						return &cloudformation.DescribeStacksOutput{
+							Stacks: []*cloudformation.Stack{
+								{
+									StackName:   input.StackName,
+									StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+									Outputs: []*cloudformation.Output{
+										{OutputKey: aws.String(sqs.OutputTopicARN), OutputValue: aws.String("arn-topic")},
+									},
+								},
+							},
+						}, nil
+					}
+					// the binding stack was created by an earlier request
+					fakeCfnClient.CreateStackWithContextReturnsOnCall(0, nil, nil)
+					_, err := sqsProvider.Bind(context.Background(), bindData)
+					Expect(err).ToNot(HaveOccurred())
+					_, createStackInput, _ = fakeCfnClient.CreateStackWithContextArgsForCall(0)
+					bindingTags = createStackInput.Tags
+					Expect(bindingTags).To(ContainElement(HaveField("Key", aws.String(sqs.TagBindingParameters))))
+				})
+
+				It("returns the existing credentials when the settings are the same", func() {
+					spec, err := sqsProvider.Bind(context.Background(), bindData)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(spec.AlreadyExists).To(BeTrue())
+				})
+
+				It("conflicts when the filter policy differs", func() {
+					bindData.Details.RawParameters = json.RawMessage(`{"filter_policy": {"event": ["order_cancelled"]}, "raw_message_delivery": true}`)
+
+					_, err := sqsProvider.Bind(context.Background(), bindData)
+					Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				})
+
+				It("conflicts when raw message delivery differs", func() {
+					bindData.Details.RawParameters = json.RawMessage(`{"filter_policy": {"event": ["order_placed"]}}`)
+
+					_, err := sqsProvider.Bind(context.Background(), bindData)
+					Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				})
+
+				It("conflicts when the settings are left out", func() {
+					bindData.Details.RawParameters = nil
+
+					_, err := sqsProvider.Bind(context.Background(), bindData)
+					Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				})
+			})
+		})
+
+		Context("Failures", func() {
+			var errResponse error
+
+			JustBeforeEach(func() {
+				var spec *domain.Binding
+				spec, errResponse = sqsProvider.Bind(context.Background(), bindData)
+				Expect(errResponse).To(HaveOccurred())
+				Expect(spec).To(BeNil())
+			})
+
+			Context("when an unexpected json key is provided", func() {
+				BeforeEach(func() {
+					bindData.Details.RawParameters = json.RawMessage(`{"pineapple": 123, "access_policy": "full"}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("json: unknown field \"pineapple\""))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when a non-string access_policy is provided", func() {
+				BeforeEach(func() {
+					bindData.Details.RawParameters = json.RawMessage(`{"access_policy":[123]}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("json: cannot unmarshal array into Go struct field UserTemplateBuilder.access_policy of type string"))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when an unknown access_policy is provided", func() {
+				BeforeEach(func() {
+					bindData.Details.RawParameters = json.RawMessage(`{"access_policy": "whatever"}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("access_policy: must be one of consumer, full, monitor, producer"))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when the plan does not allow the access_policy", func() {
+				BeforeEach(func() {
+					sqsProvider.Plans = map[string]sqs.PlanConfig{
+						"uuid-2": {
+							QueueType:      sqs.QueueTypeStandard,
+							AccessPolicies: []sqs.AccessPolicy{sqs.AccessPolicyConsumer},
+						},
+					}
+					bindData.Details.PlanID = "uuid-2"
+					bindData.Details.RawParameters = json.RawMessage(`{"access_policy": "producer"}`)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("access_policy: must be one of consumer"))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when a nonexistent queue stack is specified", func() {
+				BeforeEach(func() {
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, nil,
+						&fakeClient.MockAWSError{
+							C: "ValidationError",
+							M: "Something something does not exist something",
+						},
+					)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("instance does not exist"))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(410))
+				})
+				It("should not have created a stack", func() {
+					Expect(fakeCfnClient.CreateStackWithContextCallCount()).To(BeZero())
+				})
+			})
+
+			Context("when the requested binding id already exists", func() {
+				BeforeEach(func() {
+					fakeCfnClient.CreateStackWithContextReturnsOnCall(0, nil,
+						&fakeClient.MockAWSError{
+							C: "AlreadyExistsException",
+							M: "Got one of those",
+						},
+					)
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName:   aws.String("some stack"),
+								StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+								Tags: []*cloudformation.Tag{
+									{Key: aws.String(sqs.TagInstanceId), Value: aws.String(bindData.InstanceID)},
+									{Key: aws.String(sqs.TagAccessPolicy), Value: aws.String(sqs.AccessPolicyConsumer)},
+								},
+							},
+						},
+					}, nil)
+				})
+				It("should return an appropriate error", func() {
+					Expect(errResponse).To(MatchError("binding already exists"))
+
+					Expect(errResponse).To(BeAssignableToTypeOf(&brokerapi.FailureResponse{}))
+					castErrResponse, ok := errResponse.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(409))
+				})
+			})
+		})
+	})
+
+	Context("Update", func() {
+		var (
+			updateData       provideriface.UpdateData
+			updateStackInput *cloudformation.UpdateStackInput
+			stack            *cloudformation.Stack
+		)
+
+		BeforeEach(func() {
+			updateData = provideriface.UpdateData{
+				InstanceID: "a5da1b66-da42-4c83-b806-f287bc589ab3",
+				Plan: domain.ServicePlan{
+					Name: "standard",
+					ID:   "uuid-2",
+				},
+				Details: domain.UpdateDetails{
+					ServiceID:     "27b72d3f-9401-4b45-a7e7-40b17819954f",
+					RawParameters: json.RawMessage(`{}`),
+				},
+			}
+			updateStackInput = nil
+			stackParams := []*cloudformation.Parameter{}
+			for _, key := range []string{
+				sqs.ParamContentBasedDeduplication,
+				sqs.ParamDeduplicationScope,
+				sqs.ParamDelaySeconds,
+				sqs.ParamEncryption,
+				sqs.ParamFifoThroughputLimit,
+				sqs.ParamKMSKeyARN,
+				sqs.ParamMaximumMessageSize,
+				sqs.ParamMessageRetentionPeriod,
+				sqs.ParamReceiveMessageWaitTimeSeconds,
+				sqs.ParamRedriveMaxReceiveCount,
+				sqs.ParamVisibilityTimeout,
+			} {
+				stackParams = append(stackParams, &cloudformation.Parameter{
+					ParameterKey:   aws.String(key),
+					ParameterValue: aws.String("0"),
+				})
+			}
+			stack = &cloudformation.Stack{
+				StackName:   aws.String("some stack"),
+				StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+				Parameters:  stackParams,
+			}
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{stack},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
 			spec, err := sqsProvider.Update(context.Background(), updateData)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(spec.DashboardURL).To(Equal(""))
-			Expect(spec.OperationData).To(Equal(sqs.UpdateOperation))
+			Expect(spec.OperationData).To(HavePrefix(sqs.UpdateOperation + "-"))
 			Expect(spec.IsAsync).To(BeTrue())
 
 			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
@@ -997,6 +2714,7 @@ var _ = Describe("Provider", func() {
 			var ctx context.Context
 			ctx, updateStackInput, _ = fakeCfnClient.UpdateStackWithContextArgsForCall(0)
 			Expect(ctx).ToNot(BeNil())
+			Expect(updateStackInput.ClientRequestToken).To(Equal(aws.String(spec.OperationData)))
 		})
 
 		It("should have sensible default params", func() {
@@ -1081,21 +2799,1039 @@ var _ = Describe("Provider", func() {
 			ItSetsParam(sqs.ParamVisibilityTimeout, "28")
 		})
 
-		It("does not change the template itself", func() {
-			Expect(*updateStackInput.UsePreviousTemplate).To(BeTrue())
-			Expect(updateStackInput.TemplateBody).To(BeNil())
-		})
-
-		It("should have CAPABILITY_NAMED_IAM", func() {
-			Expect(updateStackInput.Capabilities).To(ConsistOf(
-				aws.String("CAPABILITY_NAMED_IAM"),
-			))
+		Context("updating dlq_message_retention_period", func() {
+			BeforeEach(func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"dlq_message_retention_period": 1209600}`)
+			})
+			ItSetsParam(sqs.ParamDLQMessageRetentionPeriod, "1209600")
 		})
 
-		It("should use the correct stack prefix", func() {
-			Expect(updateStackInput.StackName).To(Equal(aws.String(fmt.Sprintf("testprefix-%s", updateData.InstanceID))))
+		Context("updating dlq_redrive_permission", func() {
+			BeforeEach(func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"dlq_redrive_permission": "byQueue"}`)
+			})
+			ItSetsParam(sqs.ParamDLQRedrivePermission, sqs.DLQRedrivePermissionByQueue)
 		})
 
-	})
+		Context("updating fifo_throughput_limit on a FIFO queue", func() {
+			BeforeEach(func() {
+				updateData.Plan = domain.ServicePlan{ID: "uuid-3", Name: "fifo"}
+				updateData.Details.RawParameters = json.RawMessage(`{"deduplication_scope": "messageGroup", "fifo_throughput_limit": "perMessageGroupId"}`)
+				stack.Outputs = []*cloudformation.Output{
+					{
+						OutputKey:   aws.String(sqs.OutputPrimaryQueueURL),
+						OutputValue: aws.String("https://sqs.eu-west-2.amazonaws.com/123456789012/testprefix-a5da1b66.fifo"),
+					},
+				}
+			})
+			ItSetsParam(sqs.ParamFifoThroughputLimit, "perMessageGroupId")
+			ItSetsParam(sqs.ParamDeduplicationScope, "messageGroup")
+
+			It("keeps the queues FIFO", func() {
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Queue(sqs.ResourcePrimaryQueue).FifoQueue).To(BeTrue())
+				Expect(t.Queue(sqs.ResourceSecondaryQueue).FifoQueue).To(BeTrue())
+			})
+		})
+
+		Context("updating encryption", func() {
+			BeforeEach(func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"encryption": "kms"}`)
+			})
+			ItSetsParam(sqs.ParamEncryption, "kms")
+		})
+
+		Context("when the stack predates the encryption parameters", func() {
+			BeforeEach(func() {
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Parameters: []*cloudformation.Parameter{
+								{
+									ParameterKey:   aws.String(sqs.ParamDelaySeconds),
+									ParameterValue: aws.String("0"),
+								},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			It("only reuses previous values the stack declares", func() {
+				Expect(updateStackInput.Parameters).To(ConsistOf(&cloudformation.Parameter{
+					ParameterKey:     aws.String(sqs.ParamDelaySeconds),
+					UsePreviousValue: aws.Bool(true),
+				}))
+			})
+
+			Context("and encryption is changed", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"encryption": "kms"}`)
+				})
+				ItSetsParam(sqs.ParamEncryption, "kms")
+			})
+		})
+
+		Context("updating queues", func() {
+			BeforeEach(func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"queues": [{"name": "jobs", "delay_seconds": 5}]}`)
+			})
+			ItSetsParam(sqs.ParamQueues, `[{"name":"jobs","delay_seconds":5}]`)
+
+			It("should create the named queues", func() {
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources).To(HaveKey("QueueJobs"))
+			})
+		})
+
+		Context("when the instance has named queues", func() {
+			BeforeEach(func() {
+				stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamQueues),
+					ParameterValue: aws.String(`[{"name":"jobs"}]`),
+				})
+			})
+
+			It("keeps them when queues is not set", func() {
+				Expect(updateStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:     aws.String(sqs.ParamQueues),
+					UsePreviousValue: aws.Bool(true),
+				}))
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources).To(HaveKey("QueueJobs"))
+			})
+		})
+
+		Context("updating allowed_senders", func() {
+			BeforeEach(func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"allowed_senders": [{"service": "s3.amazonaws.com", "source_arn": "arn:aws:s3:::uploads"}]}`)
+			})
+			ItSetsParam(sqs.ParamAllowedSenders, `[{"service":"s3.amazonaws.com","source_arn":"arn:aws:s3:::uploads"}]`)
+
+			It("should add them to the queue policy", func() {
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources).To(HaveKey(sqs.ResourceQueuePolicy))
+			})
+		})
+
+		Context("when the broker denies insecure transport", func() {
+			BeforeEach(func() {
+				sqsProvider.DenyInsecureTransport = true
+			})
+
+			It("adds the queue policy to existing queues", func() {
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources[sqs.ResourceQueuePolicy].Properties).To(HaveKeyWithValue("Queues", HaveLen(2)))
+			})
+		})
+
+		Context("when the instance has alarms", func() {
+			BeforeEach(func() {
+				stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamAlarms),
+					ParameterValue: aws.String(`{"queue_depth":10,"endpoints":[{"protocol":"email","endpoint":"team@example.com"}]}`),
+				})
+			})
+
+			It("keeps them when alarms is not set", func() {
+				Expect(updateStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:     aws.String(sqs.ParamAlarms),
+					UsePreviousValue: aws.Bool(true),
+				}))
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources).To(HaveKey("PrimaryQueueDepthAlarm"))
+			})
+
+			Context("and they are removed", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"alarms": {}}`)
+				})
+				ItSetsParam(sqs.ParamAlarms, "")
+
+				It("removes the alarms", func() {
+					t := parseTemplate(*updateStackInput.TemplateBody, nil)
+					Expect(t.Resources).ToNot(HaveKey(sqs.ResourceAlarmTopic))
+					Expect(t.Resources).ToNot(HaveKey("PrimaryQueueDepthAlarm"))
+				})
+			})
+		})
+
+		Context("when the instance was tagged with its org and space", func() {
+			BeforeEach(func() {
+				stack.Tags = []*cloudformation.Tag{
+					{Key: aws.String(sqs.TagOrganizationName), Value: aws.String("payments")},
+					{Key: aws.String(sqs.TagSpaceName), Value: aws.String("prod")},
+				}
+			})
+
+			It("keeps the tags when the platform sends no context", func() {
+				Expect(updateStackInput.Tags).To(ContainElements(
+					&cloudformation.Tag{Key: aws.String(sqs.TagOrganizationName), Value: aws.String("payments")},
+					&cloudformation.Tag{Key: aws.String(sqs.TagSpaceName), Value: aws.String("prod")},
+				))
+			})
+
+			Context("and the space is renamed", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = nil
+					updateData.Details.RawContext = json.RawMessage(`{
+						"platform": "cloudfoundry",
+						"organization_guid": "27b72d3f-9401-4b45-a7e7-40b17819954f",
+						"organization_name": "payments",
+						"space_guid": "1a2b3c4d-9401-4b45-a7e7-40b17819954f",
+						"space_name": "production"
+					}`)
+				})
+
+				It("retags the stack from the context", func() {
+					Expect(updateStackInput.Tags).To(ContainElements(
+						&cloudformation.Tag{Key: aws.String(sqs.TagOrganizationName), Value: aws.String("payments")},
+						&cloudformation.Tag{Key: aws.String(sqs.TagSpaceName), Value: aws.String("production")},
+						&cloudformation.Tag{Key: aws.String(sqs.TagSpaceGUID), Value: aws.String("1a2b3c4d-9401-4b45-a7e7-40b17819954f")},
+					))
+					Expect(updateStackInput.Tags).ToNot(ContainElement(
+						&cloudformation.Tag{Key: aws.String(sqs.TagSpaceName), Value: aws.String("prod")},
+					))
+				})
+			})
+		})
+
+		Context("when the instance has tags", func() {
+			BeforeEach(func() {
+				sqsProvider.Tags = map[string]string{"cost-centre": "1234"}
+				stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamTags),
+					ParameterValue: aws.String(`{"team":"payments"}`),
+				})
+			})
+
+			It("keeps them on the stack when tags is not set", func() {
+				Expect(updateStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:     aws.String(sqs.ParamTags),
+					UsePreviousValue: aws.Bool(true),
+				}))
+				Expect(updateStackInput.Tags).To(ContainElements(
+					&cloudformation.Tag{Key: aws.String("cost-centre"), Value: aws.String("1234")},
+					&cloudformation.Tag{Key: aws.String("team"), Value: aws.String("payments")},
+				))
+			})
+
+			Context("and they are replaced", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"tags": {"team": "billing"}}`)
+				})
+				ItSetsParam(sqs.ParamTags, `{"team":"billing"}`)
+
+				It("retags the stack", func() {
+					Expect(updateStackInput.Tags).To(ContainElement(
+						&cloudformation.Tag{Key: aws.String("team"), Value: aws.String("billing")},
+					))
+					Expect(updateStackInput.Tags).ToNot(ContainElement(
+						&cloudformation.Tag{Key: aws.String("team"), Value: aws.String("payments")},
+					))
+				})
+			})
+
+			Context("and they are removed", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"tags": {}}`)
+				})
+				ItSetsParam(sqs.ParamTags, "{}")
+
+				It("only keeps the broker's and operator's tags", func() {
+					Expect(updateStackInput.Tags).To(ContainElement(
+						&cloudformation.Tag{Key: aws.String("cost-centre"), Value: aws.String("1234")},
+					))
+					Expect(updateStackInput.Tags).ToNot(ContainElement(
+						HaveField("Key", aws.String("team")),
+					))
+				})
+			})
+		})
+
+		Context("when the instance has allowed senders", func() {
+			BeforeEach(func() {
+				stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamAllowedSenders),
+					ParameterValue: aws.String(`[{"service":"s3.amazonaws.com","source_arn":"arn:aws:s3:::uploads"}]`),
+				})
+			})
+
+			It("keeps them when allowed_senders is not set", func() {
+				Expect(updateStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:     aws.String(sqs.ParamAllowedSenders),
+					UsePreviousValue: aws.Bool(true),
+				}))
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources).To(HaveKey(sqs.ResourceQueuePolicy))
+			})
+
+			Context("and they are removed", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"allowed_senders": []}`)
+				})
+				ItSetsParam(sqs.ParamAllowedSenders, "[]")
+
+				It("removes the queue policy", func() {
+					t := parseTemplate(*updateStackInput.TemplateBody, nil)
+					Expect(t.Resources).ToNot(HaveKey(sqs.ResourceQueuePolicy))
+				})
+			})
+		})
+
+		Context("when the plan has a topic", func() {
+			BeforeEach(func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-2": {QueueType: sqs.QueueTypeStandard, Topic: true},
+				}
+				stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamFilterPolicy),
+					ParameterValue: aws.String(`{"event":["a"]}`),
+				})
+			})
+
+			It("keeps the filter policy when it is not set", func() {
+				Expect(updateStackInput.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:     aws.String(sqs.ParamFilterPolicy),
+					UsePreviousValue: aws.Bool(true),
+				}))
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources[sqs.ResourcePrimaryQueueSubscription].Properties).To(HaveKeyWithValue("FilterPolicy", HaveKey("event")))
+			})
+
+			Context("and the filter policy is removed", func() {
+				BeforeEach(func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"filter_policy": {}}`)
+				})
+				ItSetsParam(sqs.ParamFilterPolicy, "")
+
+				It("removes it from the subscription", func() {
+					t := parseTemplate(*updateStackInput.TemplateBody, nil)
+					Expect(t.Resources[sqs.ResourcePrimaryQueueSubscription].Properties).ToNot(HaveKey("FilterPolicy"))
+				})
+			})
+		})
+
+		Context("changing to a plan without a dead letter queue", func() {
+			BeforeEach(func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-4": {
+						QueueType:       sqs.QueueTypeStandard,
+						DeadLetterQueue: aws.Bool(false),
+					},
+				}
+				updateData.Plan = domain.ServicePlan{ID: "uuid-4", Name: "no-dlq"}
+			})
+			ItSetsParam(sqs.ParamRedriveMaxReceiveCount, "0")
+
+			It("should not create a secondary queue", func() {
+				t := parseTemplate(*updateStackInput.TemplateBody, nil)
+				Expect(t.Resources).NotTo(HaveKey(sqs.ResourceSecondaryQueue))
+			})
+
+			Context("and the instance already has a secondary queue", func() {
+				BeforeEach(func() {
+					stack.Outputs = []*cloudformation.Output{
+						{
+							OutputKey:   aws.String(sqs.OutputSecondaryQueueURL),
+							OutputValue: aws.String("https://sqs.eu-west-2.amazonaws.com/123456789012/q-sec"),
+						},
+					}
+				})
+
+				It("keeps the secondary queue", func() {
+					t := parseTemplate(*updateStackInput.TemplateBody, nil)
+					Expect(t.Resources).To(HaveKey(sqs.ResourceSecondaryQueue))
+				})
+			})
+		})
+
+		It("adds a secondary queue when the plan has a dead letter queue", func() {
+			t := parseTemplate(*updateStackInput.TemplateBody, nil)
+			Expect(t.Resources).To(HaveKey(sqs.ResourceSecondaryQueue))
+			Expect(t.Outputs).To(HaveKey(sqs.OutputSecondaryQueueURL))
+		})
+
+		It("regenerates the template for the plan", func() {
+			Expect(updateStackInput.UsePreviousTemplate).To(BeNil())
+			Expect(updateStackInput.TemplateBody).ToNot(BeNil())
+			t := parseTemplate(*updateStackInput.TemplateBody, nil)
+			Expect(t.Queue(sqs.ResourcePrimaryQueue).FifoQueue).To(BeFalse())
+			Expect(t.Queue(sqs.ResourcePrimaryQueue).Tags).To(ContainElement(
+				goformationtags.Tag{Key: sqs.TagServiceId, Value: updateData.Details.ServiceID},
+			))
+		})
+
+		It("should tag the stack with the target plan", func() {
+			Expect(updateStackInput.Tags).To(ContainElement(
+				&cloudformation.Tag{Key: aws.String(sqs.TagPlanId), Value: aws.String("uuid-2")},
+			))
+		})
+
+		It("should have CAPABILITY_NAMED_IAM", func() {
+			Expect(updateStackInput.Capabilities).To(ConsistOf(
+				aws.String("CAPABILITY_NAMED_IAM"),
+			))
+		})
+
+		It("should use the correct stack prefix", func() {
+			Expect(updateStackInput.StackName).To(Equal(aws.String(fmt.Sprintf("testprefix-%s", updateData.InstanceID))))
+		})
+
+	})
+
+	Describe("Redriving the dead letter queue", func() {
+		var (
+			updateData provideriface.UpdateData
+			stack      *cloudformation.Stack
+		)
+
+		BeforeEach(func() {
+			updateData = provideriface.UpdateData{
+				InstanceID: "a5da1b66-da42-4c83-b806-f287bc589ab3",
+				Plan:       domain.ServicePlan{ID: "uuid-2", Name: "standard"},
+				Details: domain.UpdateDetails{
+					RawParameters: json.RawMessage(`{"redrive_dlq": true, "redrive_dlq_max_messages_per_second": 50}`),
+				},
+			}
+			stack = &cloudformation.Stack{
+				StackName:   aws.String("some stack"),
+				StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+				Parameters: []*cloudformation.Parameter{
+					{ParameterKey: aws.String(sqs.ParamRedriveMaxReceiveCount), ParameterValue: aws.String("5")},
+				},
+				Outputs: []*cloudformation.Output{
+					{OutputKey: aws.String(sqs.OutputPrimaryQueueARN), OutputValue: aws.String("arn:aws:sqs:eu-west-2:123456789012:q-pri")},
+					{OutputKey: aws.String(sqs.OutputSecondaryQueueARN), OutputValue: aws.String("arn:aws:sqs:eu-west-2:123456789012:q-sec")},
+				},
+			}
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{stack},
+			}, nil)
+		})
+
+		It("starts moving messages from the secondary queue back to their source queues", func() {
+			spec, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spec.IsAsync).To(BeTrue())
+			Expect(spec.OperationData).To(Equal(sqs.RedriveOperation))
+
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			Expect(fakeCfnClient.StartMessageMoveTaskWithContextCallCount()).To(Equal(1))
+			_, input, _ := fakeCfnClient.StartMessageMoveTaskWithContextArgsForCall(0)
+			Expect(input.SourceArn).To(Equal(aws.String("arn:aws:sqs:eu-west-2:123456789012:q-sec")))
+			Expect(input.DestinationArn).To(BeNil())
+			Expect(input.MaxNumberOfMessagesPerSecond).To(Equal(aws.Int64(50)))
+		})
+
+		It("redrives when only the named queues use the secondary queue", func() {
+			stack.Parameters[0].ParameterValue = aws.String("0")
+			stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+				ParameterKey:   aws.String(sqs.ParamQueues),
+				ParameterValue: aws.String(`[{"name":"refunds","redrive_max_receive_count":3}]`),
+			})
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeCfnClient.StartMessageMoveTaskWithContextCallCount()).To(Equal(1))
+			_, input, _ := fakeCfnClient.StartMessageMoveTaskWithContextArgsForCall(0)
+			Expect(input.SourceArn).To(Equal(aws.String("arn:aws:sqs:eu-west-2:123456789012:q-sec")))
+			Expect(input.DestinationArn).To(BeNil())
+		})
+
+		It("rejects redrive combined with other changes", func() {
+			updateData.Details.RawParameters = json.RawMessage(`{"redrive_dlq": true, "visibility_timeout": 60}`)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+			Expect(fakeCfnClient.StartMessageMoveTaskWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rejects a rate without redrive", func() {
+			updateData.Details.RawParameters = json.RawMessage(`{"redrive_dlq_max_messages_per_second": 50}`)
 
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError(ContainSubstring("can only be set with redrive_dlq")))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rejects redrive when no queue redrives to the secondary queue", func() {
+			stack.Parameters[0].ParameterValue = aws.String("0")
+			stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+				ParameterKey:   aws.String(sqs.ParamQueues),
+				ParameterValue: aws.String(`[{"name":"refunds","redrive_max_receive_count":0}]`),
+			})
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+			Expect(fakeCfnClient.StartMessageMoveTaskWithContextCallCount()).To(Equal(0))
+		})
+
+		DescribeTable("reporting progress",
+			func(task *sqs.MessageMoveTask, state domain.LastOperationState, description string) {
+				fakeCfnClient.ListMessageMoveTasksWithContextReturns(&sqs.ListMessageMoveTasksOutput{
+					Results: []*sqs.MessageMoveTask{task},
+				}, nil)
+
+				lastOperation, err := sqsProvider.LastOperation(context.Background(), provideriface.LastOperationData{
+					InstanceID:  updateData.InstanceID,
+					PollDetails: domain.PollDetails{OperationData: sqs.RedriveOperation},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(state))
+				Expect(lastOperation.Description).To(Equal(description))
+
+				_, input, _ := fakeCfnClient.ListMessageMoveTasksWithContextArgsForCall(0)
+				Expect(input.SourceArn).To(Equal(aws.String("arn:aws:sqs:eu-west-2:123456789012:q-sec")))
+				Expect(fakeCfnClient.DescribeStackEventsWithContextCallCount()).To(Equal(0))
+			},
+			Entry("running", &sqs.MessageMoveTask{
+				Status:                            aws.String(sqs.MessageMoveTaskStatusRunning),
+				ApproximateNumberOfMessagesMoved:  aws.Int64(3),
+				ApproximateNumberOfMessagesToMove: aws.Int64(10),
+			}, domain.InProgress, "pending: moved 3 of 10 messages"),
+			Entry("completed", &sqs.MessageMoveTask{
+				Status:                           aws.String(sqs.MessageMoveTaskStatusCompleted),
+				ApproximateNumberOfMessagesMoved: aws.Int64(10),
+			}, domain.Succeeded, "done: moved 10 messages"),
+			Entry("failed", &sqs.MessageMoveTask{
+				Status:                           aws.String(sqs.MessageMoveTaskStatusFailed),
+				ApproximateNumberOfMessagesMoved: aws.Int64(4),
+				FailureReason:                    aws.String("AWS.SimpleQueueService.NonExistentQueue"),
+			}, domain.Failed, "failed: AWS.SimpleQueueService.NonExistentQueue after moving 4 messages"),
+			Entry("cancelled", &sqs.MessageMoveTask{
+				Status:                           aws.String(sqs.MessageMoveTaskStatusCancelled),
+				ApproximateNumberOfMessagesMoved: aws.Int64(2),
+			}, domain.Failed, "failed: cancelled after moving 2 messages"),
+		)
+	})
+
+	Context("Update failures", func() {
+		var updateData provideriface.UpdateData
+
+		BeforeEach(func() {
+			updateData = provideriface.UpdateData{
+				InstanceID: "a5da1b66-da42-4c83-b806-f287bc589ab3",
+				Details: domain.UpdateDetails{
+					RawParameters: json.RawMessage(`{"encryption": "kms"}`),
+				},
+			}
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackName:   aws.String("some stack"),
+						StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+					},
+				},
+			}, nil)
+		})
+
+		It("rejects changing a standard queue to a FIFO queue", func() {
+			updateData.Plan = domain.ServicePlan{ID: "uuid-3", Name: "fifo"}
+			updateData.Details.RawParameters = nil
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError(ContainSubstring("cannot change between standard and FIFO queues")))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rejects changing to a broadcast plan", func() {
+			sqsProvider.Plans = map[string]sqs.PlanConfig{
+				"uuid-5": {QueueType: sqs.QueueTypeStandard, Topic: true, Broadcast: true},
+			}
+			updateData.Plan = domain.ServicePlan{ID: "uuid-5", Name: "broadcast"}
+			updateData.Details.RawParameters = nil
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError(ContainSubstring("cannot change between broadcast and other plans")))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		Context("when changing to a plan with lower maximums", func() {
+			BeforeEach(func() {
+				sqsProvider.Plans = map[string]sqs.PlanConfig{
+					"uuid-6": {QueueType: sqs.QueueTypeStandard, Maximums: sqs.QueueParams{DelaySeconds: aws.Int(60)}},
+				}
+				updateData.Plan = domain.ServicePlan{ID: "uuid-6", Name: "small"}
+				updateData.Details.PreviousValues = domain.PreviousValues{PlanID: "uuid-2"}
+				updateData.Details.RawParameters = nil
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Parameters: []*cloudformation.Parameter{
+								{ParameterKey: aws.String(sqs.ParamDelaySeconds), ParameterValue: aws.String("300")},
+								{ParameterKey: aws.String(sqs.ParamQueues), ParameterValue: aws.String(`[{"name":"refunds","delay_seconds":120}]`)},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			It("rejects keeping values above the new plan's maximums", func() {
+				_, err := sqsProvider.Update(context.Background(), updateData)
+				Expect(err).To(MatchError("cannot change plan: delay_seconds must be at most 60 for this plan, so it must be changed as well"))
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			})
+
+			It("allows the plan change when the values are changed as well", func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"delay_seconds": 60, "queues": [{"name": "refunds", "delay_seconds": 60}]}`)
+
+				_, err := sqsProvider.Update(context.Background(), updateData)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			})
+
+			It("checks the named queues as well", func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"delay_seconds": 60}`)
+
+				_, err := sqsProvider.Update(context.Background(), updateData)
+				castErrResponse, ok := err.(*brokerapi.FailureResponse)
+				Expect(ok).To(BeTrue())
+				Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			})
+		})
+
+		It("succeeds synchronously when nothing would change", func() {
+			fakeCfnClient.UpdateStackWithContextReturns(nil, fakeClient.NoUpdateRequiredException)
+
+			spec, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(spec.IsAsync).To(BeFalse())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+		})
+
+		It("rejects FIFO parameters for standard queues", func() {
+			updateData.Plan.Name = "standard"
+			updateData.Details.RawParameters = json.RawMessage(`{"content_based_deduplication": true}`)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("checks high throughput against the current deduplication scope", func() {
+			updateData.Plan.Name = "fifo"
+			updateData.Details.RawParameters = json.RawMessage(`{"fifo_throughput_limit": "perMessageGroupId"}`)
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{
+					{
+						StackName:   aws.String("some stack"),
+						StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+						Parameters: []*cloudformation.Parameter{
+							{
+								ParameterKey:   aws.String(sqs.ParamDeduplicationScope),
+								ParameterValue: aws.String("queue"),
+							},
+							{
+								ParameterKey:   aws.String(sqs.ParamFifoThroughputLimit),
+								ParameterValue: aws.String("perQueue"),
+							},
+						},
+					},
+				},
+			}, nil)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError(ContainSubstring("requires deduplication_scope")))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rejects unknown parameters", func() {
+			updateData.Details.RawParameters = json.RawMessage(`{"mango": 314}`)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError("json: unknown field \"mango\""))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rejects values outside the schema", func() {
+			updateData.Details.RawParameters = json.RawMessage(`{"delay_seconds": 901}`)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError("delay_seconds: must be at most 900"))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+		})
+
+		It("enforces the plan maximums", func() {
+			sqsProvider.Plans = map[string]sqs.PlanConfig{
+				"uuid-2": {
+					QueueType: sqs.QueueTypeStandard,
+					Maximums:  sqs.QueueParams{VisibilityTimeout: aws.Int(60)},
+				},
+			}
+			updateData.Plan.ID = "uuid-2"
+			updateData.Details.RawParameters = json.RawMessage(`{"visibility_timeout": 61}`)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(MatchError("visibility_timeout: must be at most 60"))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rejects kms keys that are not allowed", func() {
+			updateData.Details.RawParameters = json.RawMessage(`{"encryption": "kms", "kms_key_arn": "arn:aws:kms:eu-west-2:210987654321:key/efgh"}`)
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		Context("when the instance is already encrypted with kms", func() {
+			BeforeEach(func() {
+				sqsProvider.AllowedKMSKeyARNs = []string{"arn:aws:kms:eu-west-2:123456789012:key/abcd"}
+				fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+					Stacks: []*cloudformation.Stack{
+						{
+							StackName:   aws.String("some stack"),
+							StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+							Parameters: []*cloudformation.Parameter{
+								{ParameterKey: aws.String(sqs.ParamEncryption), ParameterValue: aws.String(sqs.EncryptionKMS)},
+								{ParameterKey: aws.String(sqs.ParamKMSKeyARN), ParameterValue: aws.String("")},
+							},
+						},
+					},
+				}, nil)
+			})
+
+			It("changes the kms key without repeating the encryption", func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"kms_key_arn": "arn:aws:kms:eu-west-2:123456789012:key/abcd"}`)
+
+				_, err := sqsProvider.Update(context.Background(), updateData)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+				_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(0)
+				Expect(input.Parameters).To(ContainElement(&cloudformation.Parameter{
+					ParameterKey:   aws.String(sqs.ParamKMSKeyARN),
+					ParameterValue: aws.String("arn:aws:kms:eu-west-2:123456789012:key/abcd"),
+				}))
+			})
+
+			It("still checks the key is allowed", func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"kms_key_arn": "arn:aws:kms:eu-west-2:210987654321:key/efgh"}`)
+
+				_, err := sqsProvider.Update(context.Background(), updateData)
+				Expect(err).To(MatchError(ContainSubstring("is not permitted")))
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			})
+
+			Context("when the instance has bindings", func() {
+				BeforeEach(func() {
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(1, &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName: aws.String("testprefix-other-binding"),
+								Tags:      []*cloudformation.Tag{{Key: aws.String(sqs.TagInstanceId), Value: aws.String("other-instance")}},
+							},
+						},
+						NextToken: aws.String("page-2"),
+					}, nil)
+					fakeCfnClient.DescribeStacksWithContextReturnsOnCall(2, &cloudformation.DescribeStacksOutput{
+						Stacks: []*cloudformation.Stack{
+							{
+								StackName: aws.String("testprefix-binding-id"),
+								Tags:      []*cloudformation.Tag{{Key: aws.String(sqs.TagInstanceId), Value: aws.String(updateData.InstanceID)}},
+							},
+						},
+					}, nil)
+				})
+
+				It("rejects a key change, as the bindings are only allowed the old key", func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"kms_key_arn": "arn:aws:kms:eu-west-2:123456789012:key/abcd"}`)
+
+					_, err := sqsProvider.Update(context.Background(), updateData)
+					Expect(err).To(MatchError(ContainSubstring("cannot change the KMS key while the service has bindings")))
+					castErrResponse, ok := err.(*brokerapi.FailureResponse)
+					Expect(ok).To(BeTrue())
+					Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(422))
+					Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(3))
+					Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+				})
+
+				It("allows changing back to sqs encryption", func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"encryption": "sqs"}`)
+
+					_, err := sqsProvider.Update(context.Background(), updateData)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+				})
+
+				It("allows other changes", func() {
+					updateData.Details.RawParameters = json.RawMessage(`{"kms_key_arn": "", "visibility_timeout": 60}`)
+
+					_, err := sqsProvider.Update(context.Background(), updateData)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+				})
+			})
+
+			It("rejects a kms key when changing to sqs encryption", func() {
+				updateData.Details.RawParameters = json.RawMessage(`{"encryption": "sqs", "kms_key_arn": "arn:aws:kms:eu-west-2:123456789012:key/abcd"}`)
+
+				_, err := sqsProvider.Update(context.Background(), updateData)
+				Expect(err).To(MatchError(ContainSubstring("kms_key_arn requires encryption")))
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			})
+		})
+
+		It("returns ErrInstanceDoesNotExist when the stack is missing", func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(nil, &fakeClient.MockAWSError{
+				C: "ValidationError",
+				M: "Stack with id testprefix-a5da1b66-da42-4c83-b806-f287bc589ab3 does not exist",
+			})
+
+			_, err := sqsProvider.Update(context.Background(), updateData)
+			Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+		})
+	})
+
+	Describe("Rotating access keys", func() {
+		var (
+			now   time.Time
+			stack *cloudformation.Stack
+		)
+
+		BeforeEach(func() {
+			now = time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+			sqsProvider.AccessKeyMaxAge = 90 * 24 * time.Hour
+			sqsProvider.AccessKeyGracePeriod = 24 * time.Hour
+			sqsProvider.Logger = lager.NewLogger("test")
+			stack = &cloudformation.Stack{
+				StackName:    aws.String("testprefix-binding-1"),
+				StackStatus:  aws.String(cloudformation.StackStatusCreateComplete),
+				CreationTime: aws.Time(now.Add(-91 * 24 * time.Hour)),
+				Parameters: []*cloudformation.Parameter{
+					{ParameterKey: aws.String(sqs.ParamActiveAccessKey), ParameterValue: aws.String("1")},
+					{ParameterKey: aws.String(sqs.ParamRetiringAccessKey), ParameterValue: aws.String("")},
+					{ParameterKey: aws.String(sqs.ParamAccessKeyRotatedAt), ParameterValue: aws.String("")},
+				},
+				Tags: []*cloudformation.Tag{
+					{Key: aws.String(sqs.TagInstanceId), Value: aws.String("instance-1")},
+				},
+			}
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{stack},
+			}, nil)
+		})
+
+		rotatedParams := func() map[string]string {
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(0)
+			Expect(input.StackName).To(Equal(aws.String("testprefix-binding-1")))
+			Expect(input.UsePreviousTemplate).To(Equal(aws.Bool(true)))
+			Expect(input.Capabilities).To(ConsistOf(aws.String("CAPABILITY_NAMED_IAM")))
+			Expect(input.Tags).To(BeNil())
+			Expect(aws.StringValue(input.ClientRequestToken)).To(HavePrefix(sqs.RotateOperation + "-"))
+			return stackParams(input.Parameters)
+		}
+
+		It("adds a new access key to bindings whose key is too old", func() {
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(rotatedParams()).To(Equal(map[string]string{
+				sqs.ParamActiveAccessKey:    "2",
+				sqs.ParamRetiringAccessKey:  "1",
+				sqs.ParamAccessKeyRotatedAt: "2026-06-01T12:00:00Z",
+			}))
+		})
+
+		It("leaves newer access keys alone", func() {
+			stack.Parameters[2].ParameterValue = aws.String(now.Add(-89 * 24 * time.Hour).Format(time.RFC3339))
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("retires the old access key after the grace period", func() {
+			stack.StackStatus = aws.String(cloudformation.StackStatusUpdateComplete)
+			stack.Parameters[0].ParameterValue = aws.String("2")
+			stack.Parameters[1].ParameterValue = aws.String("1")
+			stack.Parameters[2].ParameterValue = aws.String("2026-05-31T11:00:00Z")
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(rotatedParams()).To(Equal(map[string]string{
+				sqs.ParamActiveAccessKey:    "2",
+				sqs.ParamRetiringAccessKey:  "",
+				sqs.ParamAccessKeyRotatedAt: "2026-05-31T11:00:00Z",
+			}))
+		})
+
+		It("keeps the old access key during the grace period", func() {
+			stack.Parameters[0].ParameterValue = aws.String("2")
+			stack.Parameters[1].ParameterValue = aws.String("1")
+			stack.Parameters[2].ParameterValue = aws.String("2026-05-31T13:00:00Z")
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("switches back to the first access key on the next rotation", func() {
+			stack.Parameters[0].ParameterValue = aws.String("2")
+			stack.Parameters[2].ParameterValue = aws.String("2026-03-01T12:00:00Z")
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			params := rotatedParams()
+			Expect(params).To(HaveKeyWithValue(sqs.ParamActiveAccessKey, "1"))
+			Expect(params).To(HaveKeyWithValue(sqs.ParamRetiringAccessKey, "2"))
+		})
+
+		Context("when a binding was created before access keys could be rotated", func() {
+			var queueStack *cloudformation.Stack
+
+			BeforeEach(func() {
+				stack.Parameters = nil
+				stack.Tags = append(stack.Tags, &cloudformation.Tag{Key: aws.String(sqs.TagAccessPolicy), Value: aws.String(sqs.AccessPolicyProducer)})
+				queueStack = &cloudformation.Stack{
+					StackName:   aws.String("testprefix-instance-1"),
+					StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+					Tags: []*cloudformation.Tag{
+						{Key: aws.String(sqs.TagServiceId), Value: aws.String("uuid-1")},
+					},
+					Outputs: []*cloudformation.Output{
+						{OutputKey: aws.String(sqs.OutputPrimaryQueueARN), OutputValue: aws.String("arn-1")},
+						{OutputKey: aws.String(sqs.OutputPrimaryQueueURL), OutputValue: aws.String("https://queue-1")},
+					},
+				}
+				fakeCfnClient.DescribeStacksWithContextStub = func(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+					if aws.StringValue(input.StackName) == "testprefix-instance-1" {
+						return &cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{queueStack}}, nil
+					}
+					return &cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{stack}}, nil
+				}
+			})
+
+			It("migrates it to the current user template, keeping its access key", func() {
+				Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+				_, input, _ := fakeCfnClient.UpdateStackWithContextArgsForCall(0)
+				Expect(input.StackName).To(Equal(aws.String("testprefix-binding-1")))
+				Expect(input.UsePreviousTemplate).To(BeNil())
+				Expect(input.Capabilities).To(ConsistOf(aws.String("CAPABILITY_NAMED_IAM")))
+				Expect(aws.StringValue(input.ClientRequestToken)).To(HavePrefix(sqs.RotateOperation + "-"))
+				Expect(stackParams(input.Parameters)).To(Equal(map[string]string{
+					sqs.ParamActiveAccessKey: "1",
+				}))
+
+				t := parseTemplate(*input.TemplateBody, stackParams(input.Parameters))
+				Expect(t.Resources).To(HaveKey(sqs.ResourceAccessKey))
+				Expect(t.Resources[sqs.ResourceAccessKey].Properties).To(HaveKeyWithValue("Serial", BeNumerically("==", 1)))
+				Expect(t.Resources).ToNot(HaveKey(sqs.ResourceAccessKey2))
+				Expect(t.Resources[sqs.ResourceUser].Properties).To(HaveKeyWithValue("UserName", "binding-binding-1"))
+				policyDocument := t.Resources[sqs.ResourcePolicy].Properties["PolicyDocument"]
+				Expect(policyDocument).To(HaveKeyWithValue("Statement", ContainElement(And(
+					HaveKeyWithValue("Action", ContainElement("sqs:SendMessage")),
+					HaveKeyWithValue("Action", Not(ContainElement("sqs:ReceiveMessage"))),
+					HaveKeyWithValue("Resource", ConsistOf("arn-1")),
+				))))
+			})
+
+			It("leaves role bindings alone, as they have no access keys", func() {
+				stack.Tags = append(stack.Tags, &cloudformation.Tag{Key: aws.String(sqs.TagBindingType), Value: aws.String(sqs.BindingTypeRole)})
+				Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			})
+
+			It("leaves bindings on broadcast plans alone", func() {
+				queueStack.Outputs = []*cloudformation.Output{
+					{OutputKey: aws.String(sqs.OutputTopicARN), OutputValue: aws.String("arn-topic")},
+				}
+				Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+			})
+		})
+
+		It("skips queue stacks, other brokers' stacks and stacks being changed", func() {
+			queueStack := *stack
+			queueStack.Tags = nil
+			otherStack := *stack
+			otherStack.StackName = aws.String("otherprefix-binding-1")
+			busyStack := *stack
+			busyStack.StackStatus = aws.String(cloudformation.StackStatusUpdateInProgress)
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{&queueStack, &otherStack, &busyStack},
+			}, nil)
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(0))
+		})
+
+		It("rotates the bindings on every page of stacks", func() {
+			fakeCfnClient.DescribeStacksWithContextReturnsOnCall(0, &cloudformation.DescribeStacksOutput{
+				Stacks:    []*cloudformation.Stack{},
+				NextToken: aws.String("page-2"),
+			}, nil)
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(fakeCfnClient.DescribeStacksWithContextCallCount()).To(Equal(2))
+			_, input, _ := fakeCfnClient.DescribeStacksWithContextArgsForCall(1)
+			Expect(input.NextToken).To(Equal(aws.String("page-2")))
+			Expect(input.StackName).To(BeNil())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+		})
+
+		It("carries on after a binding fails to rotate", func() {
+			second := *stack
+			second.StackName = aws.String("testprefix-binding-2")
+			fakeCfnClient.DescribeStacksWithContextReturns(&cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{stack, &second},
+			}, nil)
+			fakeCfnClient.UpdateStackWithContextReturnsOnCall(0, nil, fmt.Errorf("throttled"))
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+			Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(2))
+		})
+
+		DescribeTable("skips bindings another broker instance is rotating",
+			func(updateErr error) {
+				fakeCfnClient.UpdateStackWithContextReturns(nil, updateErr)
+				Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(Succeed())
+				Expect(fakeCfnClient.UpdateStackWithContextCallCount()).To(Equal(1))
+			},
+			Entry("stack being updated", &fakeClient.MockAWSError{
+				C: "ValidationError",
+				M: "Stack:arn:aws:cloudformation:eu-west-2:123456789012:stack/testprefix-binding-1/abcd is in UPDATE_IN_PROGRESS state and can not be updated.",
+			}),
+			Entry("request token used", &fakeClient.MockAWSError{
+				C: cloudformation.ErrCodeTokenAlreadyExistsException,
+				M: "Another UpdateStack request with the same token is in progress",
+			}),
+			Entry("already rotated", &fakeClient.MockAWSError{
+				C: "ValidationError",
+				M: "No updates are to be performed.",
+			}),
+		)
+
+		It("returns errors listing the stacks", func() {
+			fakeCfnClient.DescribeStacksWithContextReturns(nil, fmt.Errorf("access denied"))
+			Expect(sqsProvider.RotateAccessKeys(context.Background(), now)).To(MatchError("access denied"))
+		})
+	})
 })
+
+// maxLengthTags returns a JSON object of tags with the longest keys and
+// values AWS allows.
+func maxLengthTags(n int) string {
+	tags := map[string]string{}
+	for i := 0; i < n; i++ {
+		tags[fmt.Sprintf("%02d", i)+strings.Repeat("k", 126)] = strings.Repeat("v", 256)
+	}
+	data, _ := json.Marshal(tags)
+	return string(data)
+}
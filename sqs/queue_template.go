@@ -2,6 +2,7 @@ package sqs
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"text/template"
@@ -11,7 +12,17 @@ import (
 )
 
 const (
+	ParamContentBasedDeduplication     = "ContentBasedDeduplication"
+	ParamDeduplicationScope            = "DeduplicationScope"
 	ParamDelaySeconds                  = "DelaySeconds"
+	ParamDLQDelaySeconds               = "DLQDelaySeconds"
+	ParamDLQMaximumMessageSize         = "DLQMaximumMessageSize"
+	ParamDLQMessageRetentionPeriod     = "DLQMessageRetentionPeriod"
+	ParamDLQRedrivePermission          = "DLQRedrivePermission"
+	ParamDLQVisibilityTimeout          = "DLQVisibilityTimeout"
+	ParamEncryption                    = "Encryption"
+	ParamFifoThroughputLimit           = "FifoThroughputLimit"
+	ParamKMSKeyARN                     = "KmsKeyArn"
 	ParamMaximumMessageSize            = "MaximumMessageSize"
 	ParamMessageRetentionPeriod        = "MessageRetentionPeriod"
 	ParamReceiveMessageWaitTimeSeconds = "ReceiveMessageWaitTimeSeconds"
@@ -20,12 +31,17 @@ const (
 )
 
 const (
-	ConditionShouldNotUseDLQ = "ShouldNotUseDLQ"
+	ConditionShouldNotUseDLQ                    = "ShouldNotUseDLQ"
+	ConditionShouldRestrictDLQRedrive           = "ShouldRestrictDLQRedrive"
+	ConditionShouldUseKMS                       = "ShouldUseKMS"
+	ConditionShouldCreateKMSKey                 = "ShouldCreateKMSKey"
+	ConditionShouldUseContentBasedDeduplication = "ShouldUseContentBasedDeduplication"
 )
 
 const (
 	ResourcePrimaryQueue   = "PrimaryQueue"
 	ResourceSecondaryQueue = "SecondaryQueue"
+	ResourceQueueKey       = "QueueKey"
 )
 
 const (
@@ -33,6 +49,27 @@ const (
 	OutputPrimaryQueueARN   = "PrimaryQueueARN"
 	OutputSecondaryQueueURL = "SecondaryQueueURL"
 	OutputSecondaryQueueARN = "SecondaryQueueARN"
+	OutputKMSKeyARN         = "KMSKeyARN"
+)
+
+const (
+	EncryptionSQS = "sqs"
+	EncryptionKMS = "kms"
+)
+
+const (
+	DeduplicationScopeQueue        = "queue"
+	DeduplicationScopeMessageGroup = "messageGroup"
+)
+
+const (
+	FifoThroughputLimitPerQueue          = "perQueue"
+	FifoThroughputLimitPerMessageGroupID = "perMessageGroupId"
+)
+
+const (
+	DLQRedrivePermissionAllowAll = "allowAll"
+	DLQRedrivePermissionByQueue  = "byQueue"
 )
 
 const (
@@ -46,7 +83,37 @@ const (
 type QueueTemplateBuilder struct {
 	QueueName string
 	FIFOQueue bool
-	Tags      map[string]string
+	// SingleQueue leaves out the secondary queue, so messages cannot
+	// be redriven to a dead letter queue.
+	SingleQueue bool
+	// Queues are additional named queues to create alongside the
+	// primary and secondary queues.
+	Queues []NamedQueue
+	// Topic adds an SNS topic that the primary and named queues are
+	// subscribed to, so every queue gets the messages published to it.
+	Topic bool
+	// Broadcast leaves out the queues, which are created for each
+	// binding instead, so the stack only has the topic.
+	Broadcast bool
+	// FilterPolicy is the filter policy of the primary queue's
+	// subscription to the topic, as single line JSON.
+	FilterPolicy string
+	// AllowedSenders are added to the primary queue's policy.
+	AllowedSenders []AllowedSender
+	// DenyInsecureTransport adds a statement to the queue policy that
+	// denies requests not made over TLS.
+	DenyInsecureTransport bool
+	// RestrictPrincipals adds a statement to the queue policy that denies
+	// any principal in the account other than the binding users and
+	// roles, which are under the ResourcePrefix path, and the broker
+	// itself.
+	RestrictPrincipals bool
+	ResourcePrefix     string
+	BrokerPrincipalARN string
+	// Alarms adds CloudWatch alarms on the queues, and a topic for their
+	// notifications.
+	Alarms *Alarms
+	Tags   map[string]string
 }
 
 // PrimaryQueueName builds the name for the primary queue
@@ -59,6 +126,17 @@ func (params *QueueTemplateBuilder) SecondaryQueueName() string {
 	return fmt.Sprintf("%s-sec%s", params.QueueName, params.ext())
 }
 
+// NamedQueueName builds the name for a named queue
+func (params *QueueTemplateBuilder) NamedQueueName(queue NamedQueue) string {
+	return fmt.Sprintf("%s-%s%s", params.QueueName, queue.Name, params.ext())
+}
+
+// HardenedQueuePolicy returns true if the queue policy restricts access
+// to the queues, so it must cover all of them.
+func (params *QueueTemplateBuilder) HardenedQueuePolicy() bool {
+	return params.DenyInsecureTransport || params.RestrictPrincipals
+}
+
 // ext returns the suffix for the queue names. this is important because
 // FIFO queues require a sepecific suffix
 func (params *QueueTemplateBuilder) ext() string {
@@ -92,21 +170,43 @@ func (params *QueueTemplateBuilder) Build() (string, error) {
 // then it should be in QueueParams so that CloudFormation
 // can keep track of its value across updates.
 type QueueParams struct {
+	// ContentBasedDeduplication enables content-based deduplication on
+	// FIFO queues. SQS uses a SHA-256 hash of the message body to
+	// generate the deduplication ID when the sender does not provide one.
+	ContentBasedDeduplication *bool `json:"content_based_deduplication,omitempty" fifo:"true"`
+	// DeduplicationScope specifies whether message deduplication occurs
+	// at the "messageGroup" or "queue" level. FIFO queues only.
+	DeduplicationScope *string `json:"deduplication_scope,omitempty" enum:"queue,messageGroup" fifo:"true"`
 	// DelaySeconds The time in seconds for which the delivery of all messages
 	// in the queue is delayed. You can specify an integer value of 0 to 900
 	// (15 minutes).
-	DelaySeconds *int `json:"delay_seconds,omitempty"`
+	DelaySeconds *int `json:"delay_seconds,omitempty" minimum:"0" maximum:"900"`
+	// Encryption selects the server-side encryption used for both
+	// queues: "sqs" for SQS-managed encryption keys (SSE-SQS) or "kms"
+	// for a customer-managed AWS KMS key (SSE-KMS). The default is
+	// "sqs".
+	Encryption *string `json:"encryption,omitempty" enum:"sqs,kms"`
+	// KMSKeyARN is the ARN of an existing KMS key to encrypt the
+	// queues with when Encryption is "kms". It must be one of the keys
+	// allowed by the operator. If it is empty a dedicated key, with
+	// automatic key rotation turned on, is created alongside the queues.
+	KMSKeyARN *string `json:"kms_key_arn,omitempty"`
+	// FifoThroughputLimit specifies whether the FIFO queue throughput
+	// quota applies to the entire queue ("perQueue") or per message group
+	// ("perMessageGroupId"). High throughput mode requires
+	// "perMessageGroupId" with a DeduplicationScope of "messageGroup".
+	FifoThroughputLimit *string `json:"fifo_throughput_limit,omitempty" enum:"perQueue,perMessageGroupId" fifo:"true"`
 	// MaximumMessageSize is the limit of how many bytes that a message can
 	// contain before Amazon SQS rejects it. You can specify an integer value
 	// from 1,024 bytes (1 KiB) to 262,144 bytes (256 KiB). The default value
 	// is 262,144 (256 KiB).
-	MaximumMessageSize *int `json:"maximum_message_size,omitempty"`
+	MaximumMessageSize *int `json:"maximum_message_size,omitempty" minimum:"1024" maximum:"262144"`
 	// MessageRetentionPeriod The number of seconds
 	// that Amazon SQS retains a message. You can
 	// specify an integer value from 60 seconds (1
 	// minute) to 1,209,600 seconds (14 days). The
 	// default value is 345,600 seconds (4 days).
-	MessageRetentionPeriod *int `json:"message_retention_period,omitempty"`
+	MessageRetentionPeriod *int `json:"message_retention_period,omitempty" minimum:"60" maximum:"1209600"`
 	// ReceiveMessageWaitTimeSeconds Specifies the
 	// duration, in seconds, that the ReceiveMessage
 	// action call waits until a message is in the
@@ -116,11 +216,11 @@ type QueueParams struct {
 	// integer from 1 to 20. Short polling is used as
 	// the default or when you specify 0 for this
 	// property.
-	ReceiveMessageWaitTimeSeconds *int `json:"receive_message_wait_time_seconds,omitempty"`
+	ReceiveMessageWaitTimeSeconds *int `json:"receive_message_wait_time_seconds,omitempty" minimum:"0" maximum:"20"`
 	// RedriveMaxReceiveCount  The number of times a
 	// message is delivered to the source queue before
 	// being moved to the dead-letter queue.
-	RedriveMaxReceiveCount *int `json:"redrive_max_receive_count,omitempty"`
+	RedriveMaxReceiveCount *int `json:"redrive_max_receive_count,omitempty" minimum:"0" maximum:"1000"`
 	// VisibilityTimeout The length of time during
 	// which a message will be unavailable after a
 	// message is delivered from the queue. This blocks
@@ -128,16 +228,75 @@ type QueueParams struct {
 	// and gives the initial component time to process
 	// and delete the message from the queue.
 	// Values must be from 0 to 43,200 seconds (12 hours). If you don't specify a value, AWS CloudFormation uses the default value of 30 seconds.
-	VisibilityTimeout *int `json:"visibility_timeout,omitempty"`
+	VisibilityTimeout *int `json:"visibility_timeout,omitempty" minimum:"0" maximum:"43200"`
+	// DLQDelaySeconds is the delivery delay of the secondary queue. The
+	// default is 0.
+	DLQDelaySeconds *int `json:"dlq_delay_seconds,omitempty" minimum:"0" maximum:"900" dlq:"true"`
+	// DLQMaximumMessageSize is the maximum message size of the secondary
+	// queue. The default is 262,144 (256 KiB).
+	DLQMaximumMessageSize *int `json:"dlq_maximum_message_size,omitempty" minimum:"1024" maximum:"262144" dlq:"true"`
+	// DLQMessageRetentionPeriod is the number of seconds the secondary
+	// queue retains a message. The default is to use the
+	// MessageRetentionPeriod of the primary queue.
+	DLQMessageRetentionPeriod *int `json:"dlq_message_retention_period,omitempty" minimum:"60" maximum:"1209600" dlq:"true"`
+	// DLQRedrivePermission controls which queues may use the secondary
+	// queue as their dead letter queue: "allowAll", the default, or
+	// "byQueue" to only allow the primary queue.
+	DLQRedrivePermission *string `json:"dlq_redrive_permission,omitempty" enum:"allowAll,byQueue" dlq:"true"`
+	// DLQVisibilityTimeout is the visibility timeout of the secondary
+	// queue. The default is to use the VisibilityTimeout of the primary
+	// queue.
+	DLQVisibilityTimeout *int `json:"dlq_visibility_timeout,omitempty" minimum:"0" maximum:"43200" dlq:"true"`
+	// Queues are additional named queues, each with their own settings.
+	// The queues are replaced by the list given on update, so queues
+	// left out are deleted along with their messages. There can be at
+	// most 9, so the secondary queue's redrive allow policy can list
+	// every queue that may redrive to it.
+	Queues []NamedQueue `json:"queues,omitempty" maxItems:"9"`
+	// FilterPolicy is an SNS filter policy limiting the messages published
+	// to the topic that are delivered to the primary queue. Plans with a
+	// topic only. An empty object removes the filter policy.
+	FilterPolicy json.RawMessage `json:"filter_policy,omitempty" topic:"true"`
+	// RawMessageDelivery delivers messages published to the topic to the
+	// primary queue as they were published, rather than wrapped in an SNS
+	// JSON envelope. Plans with a topic only.
+	RawMessageDelivery *bool `json:"raw_message_delivery,omitempty" topic:"true"`
+	// AllowedSenders are AWS services and accounts that may send
+	// messages to the primary queue, alongside the service's bindings.
+	// The senders are replaced by the list given on update.
+	AllowedSenders []AllowedSender `json:"allowed_senders,omitempty" maxItems:"20"`
+	// Alarms creates CloudWatch alarms on the queues that notify the
+	// given email addresses or HTTPS endpoints. The alarms are replaced
+	// by the object given on update, and an empty object removes them.
+	Alarms *Alarms `json:"alarms,omitempty"`
+	// Tags are added to the queue stack, and so to every resource in
+	// it, and to the stacks of new bindings. They are replaced by the
+	// object given on update, and an empty object removes them.
+	Tags map[string]string `json:"tags,omitempty" maxProperties:"20"`
 }
 
 // CreateParams returns a set of cloudformation.Parameter suitable for
 // passing to CreateStackWithContext().
 func (params *QueueParams) CreateParams() []*cloudformation.Parameter {
 	stackParams := []*cloudformation.Parameter{}
+	if params.ContentBasedDeduplication != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamContentBasedDeduplication, strconv.FormatBool(*params.ContentBasedDeduplication)))
+	}
+	if params.DeduplicationScope != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamDeduplicationScope, *params.DeduplicationScope))
+	}
 	if params.DelaySeconds != nil {
 		stackParams = append(stackParams, mkParameter(ParamDelaySeconds, *params.DelaySeconds))
 	}
+	if params.Encryption != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamEncryption, *params.Encryption))
+	}
+	if params.KMSKeyARN != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamKMSKeyARN, *params.KMSKeyARN))
+	}
+	if params.FifoThroughputLimit != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamFifoThroughputLimit, *params.FifoThroughputLimit))
+	}
 	if params.MaximumMessageSize != nil {
 		stackParams = append(stackParams, mkParameter(ParamMaximumMessageSize, *params.MaximumMessageSize))
 	}
@@ -153,13 +312,113 @@ func (params *QueueParams) CreateParams() []*cloudformation.Parameter {
 	if params.VisibilityTimeout != nil {
 		stackParams = append(stackParams, mkParameter(ParamVisibilityTimeout, *params.VisibilityTimeout))
 	}
+	if params.DLQDelaySeconds != nil {
+		stackParams = append(stackParams, mkParameter(ParamDLQDelaySeconds, *params.DLQDelaySeconds))
+	}
+	if params.DLQMaximumMessageSize != nil {
+		stackParams = append(stackParams, mkParameter(ParamDLQMaximumMessageSize, *params.DLQMaximumMessageSize))
+	}
+	if params.DLQMessageRetentionPeriod != nil {
+		stackParams = append(stackParams, mkParameter(ParamDLQMessageRetentionPeriod, *params.DLQMessageRetentionPeriod))
+	}
+	if params.DLQRedrivePermission != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamDLQRedrivePermission, *params.DLQRedrivePermission))
+	}
+	if params.DLQVisibilityTimeout != nil {
+		stackParams = append(stackParams, mkParameter(ParamDLQVisibilityTimeout, *params.DLQVisibilityTimeout))
+	}
+	if params.Queues != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamQueues, encodeNamedQueues(params.Queues)))
+	}
+	if params.FilterPolicy != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamFilterPolicy, filterPolicyJSON(params.FilterPolicy)))
+	}
+	if params.RawMessageDelivery != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamRawMessageDelivery, strconv.FormatBool(*params.RawMessageDelivery)))
+	}
+	if params.AllowedSenders != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamAllowedSenders, encodeAllowedSenders(params.AllowedSenders)))
+	}
+	if params.Alarms != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamAlarms, encodeAlarms(params.Alarms)))
+	}
+	if params.Tags != nil {
+		stackParams = append(stackParams, mkStringParameter(ParamTags, encodeTags(params.Tags)))
+	}
 	return stackParams
 }
 
+// QueueParamsFromStack rebuilds the parameters a queue stack was created
+// or last updated with from its parameter values.
+func QueueParamsFromStack(values map[string]string) QueueParams {
+	return QueueParams{
+		ContentBasedDeduplication:     stackBoolParameter(values, ParamContentBasedDeduplication),
+		DeduplicationScope:            stackStringParameter(values, ParamDeduplicationScope),
+		DelaySeconds:                  stackIntParameter(values, ParamDelaySeconds),
+		Encryption:                    stackStringParameter(values, ParamEncryption),
+		FifoThroughputLimit:           stackStringParameter(values, ParamFifoThroughputLimit),
+		KMSKeyARN:                     stackStringParameter(values, ParamKMSKeyARN),
+		MaximumMessageSize:            stackIntParameter(values, ParamMaximumMessageSize),
+		MessageRetentionPeriod:        stackIntParameter(values, ParamMessageRetentionPeriod),
+		ReceiveMessageWaitTimeSeconds: stackIntParameter(values, ParamReceiveMessageWaitTimeSeconds),
+		RedriveMaxReceiveCount:        stackIntParameter(values, ParamRedriveMaxReceiveCount),
+		VisibilityTimeout:             stackIntParameter(values, ParamVisibilityTimeout),
+		DLQDelaySeconds:               stackIntParameter(values, ParamDLQDelaySeconds),
+		DLQMaximumMessageSize:         stackIntParameter(values, ParamDLQMaximumMessageSize),
+		DLQMessageRetentionPeriod:     stackIntParameter(values, ParamDLQMessageRetentionPeriod),
+		DLQRedrivePermission:          stackStringParameter(values, ParamDLQRedrivePermission),
+		DLQVisibilityTimeout:          stackIntParameter(values, ParamDLQVisibilityTimeout),
+		Queues:                        decodeNamedQueues(values[ParamQueues]),
+		FilterPolicy:                  stackJSONParameter(values, ParamFilterPolicy),
+		RawMessageDelivery:            stackBoolParameter(values, ParamRawMessageDelivery),
+		AllowedSenders:                decodeAllowedSenders(values[ParamAllowedSenders]),
+		Alarms:                        decodeAlarms(values[ParamAlarms]),
+		Tags:                          decodeTags(values[ParamTags]),
+	}
+}
+
+func stackStringParameter(values map[string]string, name string) *string {
+	value, ok := values[name]
+	if !ok || value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
+
+// stackIntParameter treats negative values as unset, as the template
+// uses -1 to inherit a value from the primary queue.
+func stackIntParameter(values map[string]string, name string) *int {
+	value, err := strconv.Atoi(values[name])
+	if err != nil || value < 0 {
+		return nil
+	}
+	return aws.Int(value)
+}
+
+func stackJSONParameter(values map[string]string, name string) json.RawMessage {
+	value, ok := values[name]
+	if !ok || value == "" {
+		return nil
+	}
+	return json.RawMessage(value)
+}
+
+func stackBoolParameter(values map[string]string, name string) *bool {
+	value, err := strconv.ParseBool(values[name])
+	if err != nil {
+		return nil
+	}
+	return aws.Bool(value)
+}
+
 func mkParameter(name string, value int) *cloudformation.Parameter {
+	return mkStringParameter(name, strconv.Itoa(value))
+}
+
+func mkStringParameter(name string, value string) *cloudformation.Parameter {
 	return &cloudformation.Parameter{
 		ParameterKey:   aws.String(name),
-		ParameterValue: aws.String(strconv.Itoa(value)),
+		ParameterValue: aws.String(value),
 	}
 }
 
@@ -169,25 +428,89 @@ func mkParameter(name string, value int) *cloudformation.Parameter {
 // UsePreviousValue set to true.
 func (params *QueueParams) UpdateParams() []*cloudformation.Parameter {
 	return []*cloudformation.Parameter{
+		mkOptionalBoolParameter(ParamContentBasedDeduplication, params.ContentBasedDeduplication),
+		mkOptionalStringParameter(ParamDeduplicationScope, params.DeduplicationScope),
 		mkOptionalParameter(ParamDelaySeconds, params.DelaySeconds),
+		mkOptionalStringParameter(ParamEncryption, params.Encryption),
+		mkOptionalStringParameter(ParamKMSKeyARN, params.KMSKeyARN),
+		mkOptionalStringParameter(ParamFifoThroughputLimit, params.FifoThroughputLimit),
 		mkOptionalParameter(ParamMaximumMessageSize, params.MaximumMessageSize),
 		mkOptionalParameter(ParamMessageRetentionPeriod, params.MessageRetentionPeriod),
 		mkOptionalParameter(ParamReceiveMessageWaitTimeSeconds, params.ReceiveMessageWaitTimeSeconds),
 		mkOptionalParameter(ParamRedriveMaxReceiveCount, params.RedriveMaxReceiveCount),
 		mkOptionalParameter(ParamVisibilityTimeout, params.VisibilityTimeout),
+		mkOptionalParameter(ParamDLQDelaySeconds, params.DLQDelaySeconds),
+		mkOptionalParameter(ParamDLQMaximumMessageSize, params.DLQMaximumMessageSize),
+		mkOptionalParameter(ParamDLQMessageRetentionPeriod, params.DLQMessageRetentionPeriod),
+		mkOptionalStringParameter(ParamDLQRedrivePermission, params.DLQRedrivePermission),
+		mkOptionalParameter(ParamDLQVisibilityTimeout, params.DLQVisibilityTimeout),
+		mkOptionalQueuesParameter(params.Queues),
+		mkOptionalFilterPolicyParameter(params.FilterPolicy),
+		mkOptionalBoolParameter(ParamRawMessageDelivery, params.RawMessageDelivery),
+		mkOptionalAllowedSendersParameter(params.AllowedSenders),
+		mkOptionalAlarmsParameter(params.Alarms),
+		mkOptionalTagsParameter(params.Tags),
+	}
+}
+
+func mkOptionalFilterPolicyParameter(policy json.RawMessage) *cloudformation.Parameter {
+	if policy == nil {
+		return mkOptionalStringParameter(ParamFilterPolicy, nil)
+	}
+	return mkStringParameter(ParamFilterPolicy, filterPolicyJSON(policy))
+}
+
+func mkOptionalAllowedSendersParameter(senders []AllowedSender) *cloudformation.Parameter {
+	if senders == nil {
+		return mkOptionalStringParameter(ParamAllowedSenders, nil)
+	}
+	return mkStringParameter(ParamAllowedSenders, encodeAllowedSenders(senders))
+}
+
+func mkOptionalAlarmsParameter(alarms *Alarms) *cloudformation.Parameter {
+	if alarms == nil {
+		return mkOptionalStringParameter(ParamAlarms, nil)
+	}
+	return mkStringParameter(ParamAlarms, encodeAlarms(alarms))
+}
+
+func mkOptionalTagsParameter(tags map[string]string) *cloudformation.Parameter {
+	if tags == nil {
+		return mkOptionalStringParameter(ParamTags, nil)
+	}
+	return mkStringParameter(ParamTags, encodeTags(tags))
+}
+
+func mkOptionalQueuesParameter(queues []NamedQueue) *cloudformation.Parameter {
+	if queues == nil {
+		return mkOptionalStringParameter(ParamQueues, nil)
 	}
+	return mkStringParameter(ParamQueues, encodeNamedQueues(queues))
 }
 
 func mkOptionalParameter(name string, value *int) *cloudformation.Parameter {
+	if value == nil {
+		return mkOptionalStringParameter(name, nil)
+	} else {
+		return mkStringParameter(name, strconv.Itoa(*value))
+	}
+}
+
+func mkOptionalBoolParameter(name string, value *bool) *cloudformation.Parameter {
+	if value == nil {
+		return mkOptionalStringParameter(name, nil)
+	} else {
+		return mkStringParameter(name, strconv.FormatBool(*value))
+	}
+}
+
+func mkOptionalStringParameter(name string, value *string) *cloudformation.Parameter {
 	if value == nil {
 		return &cloudformation.Parameter{
 			ParameterKey:     aws.String(name),
 			UsePreviousValue: aws.Bool(true),
 		}
 	} else {
-		return &cloudformation.Parameter{
-			ParameterKey:   aws.String(name),
-			ParameterValue: aws.String(strconv.Itoa(*value)),
-		}
+		return mkStringParameter(name, *value)
 	}
 }
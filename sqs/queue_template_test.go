@@ -1,8 +1,11 @@
 package sqs_test
 
 import (
+	"path"
+
 	"github.com/alphagov/paas-sqs-broker/sqs"
-	goformation "github.com/awslabs/goformation/v4"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/awslabs/goformation/v4"
 	goformationsqs "github.com/awslabs/goformation/v4/cloudformation/sqs"
 	goformationtags "github.com/awslabs/goformation/v4/cloudformation/tags"
 	. "github.com/onsi/ginkgo/v2"
@@ -13,23 +16,51 @@ var _ = Describe("QueueTemplateBuilder", func() {
 	var primaryQueue *goformationsqs.Queue
 	var secondaryQueue *goformationsqs.Queue
 	var builder sqs.QueueTemplateBuilder
+	var params map[string]string
+	var t parsedTemplate
 
 	BeforeEach(func() {
 		builder = sqs.QueueTemplateBuilder{}
+		params = map[string]string{}
 	})
 
 	JustBeforeEach(func() {
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
-		t, err := goformation.ParseYAML([]byte(text))
-		Expect(err).ToNot(HaveOccurred())
+		t = parseTemplate(text, params)
 
-		Expect(t.Resources).To(ContainElement(BeAssignableToTypeOf(&goformationsqs.Queue{})))
-		var ok bool
-		primaryQueue, ok = t.Resources[sqs.ResourcePrimaryQueue].(*goformationsqs.Queue)
-		Expect(ok).To(BeTrue())
-		secondaryQueue, ok = t.Resources[sqs.ResourceSecondaryQueue].(*goformationsqs.Queue)
-		Expect(ok).To(BeTrue())
+		primaryQueue = nil
+		if !builder.Broadcast {
+			primaryQueue = t.Queue(sqs.ResourcePrimaryQueue)
+		}
+		secondaryQueue = nil
+		if !builder.SingleQueue {
+			secondaryQueue = t.Queue(sqs.ResourceSecondaryQueue)
+		}
+	})
+
+	Context("when SingleQueue is set", func() {
+		BeforeEach(func() {
+			builder.QueueName = "q-name-a"
+			builder.SingleQueue = true
+		})
+		It("should not create a secondary queue", func() {
+			Expect(t.Resources).To(HaveKey(sqs.ResourcePrimaryQueue))
+			Expect(t.Resources).NotTo(HaveKey(sqs.ResourceSecondaryQueue))
+		})
+		It("should not output the secondary queue", func() {
+			Expect(t.Outputs).To(HaveKey(sqs.OutputPrimaryQueueURL))
+			Expect(t.Outputs).NotTo(HaveKey(sqs.OutputSecondaryQueueURL))
+			Expect(t.Outputs).NotTo(HaveKey(sqs.OutputSecondaryQueueARN))
+		})
+		Context("and a redrive count is set", func() {
+			BeforeEach(func() {
+				params[sqs.ParamRedriveMaxReceiveCount] = "5"
+			})
+			It("should not set a redrive policy", func() {
+				Expect(primaryQueue.RedrivePolicy).To(BeNil())
+			})
+		})
 	})
 
 	Context("when QueueName is set for a non-FIFO queue", func() {
@@ -139,14 +170,41 @@ var _ = Describe("QueueTemplateBuilder", func() {
 			Expect(primaryQueue.FifoQueue).To(BeTrue())
 			Expect(secondaryQueue.FifoQueue).To(BeTrue())
 		})
+		It("should default to standard FIFO deduplication and throughput", func() {
+			Expect(primaryQueue.ContentBasedDeduplication).To(BeFalse())
+			Expect(t.Resources[sqs.ResourcePrimaryQueue].Properties).To(And(
+				HaveKeyWithValue("DeduplicationScope", sqs.DeduplicationScopeQueue),
+				HaveKeyWithValue("FifoThroughputLimit", sqs.FifoThroughputLimitPerQueue),
+			))
+		})
+
+		Context("with content-based deduplication and high throughput", func() {
+			BeforeEach(func() {
+				params[sqs.ParamContentBasedDeduplication] = "true"
+				params[sqs.ParamDeduplicationScope] = sqs.DeduplicationScopeMessageGroup
+				params[sqs.ParamFifoThroughputLimit] = sqs.FifoThroughputLimitPerMessageGroupID
+			})
+			It("should configure both queues", func() {
+				for _, name := range []string{sqs.ResourcePrimaryQueue, sqs.ResourceSecondaryQueue} {
+					Expect(t.Queue(name).ContentBasedDeduplication).To(BeTrue())
+					Expect(t.Resources[name].Properties).To(And(
+						HaveKeyWithValue("DeduplicationScope", sqs.DeduplicationScopeMessageGroup),
+						HaveKeyWithValue("FifoThroughputLimit", sqs.FifoThroughputLimitPerMessageGroupID),
+					))
+				}
+			})
+		})
+	})
+
+	It("should not set FIFO properties on standard queues", func() {
+		Expect(t.Resources[sqs.ResourcePrimaryQueue].Properties).ToNot(Or(
+			HaveKey("ContentBasedDeduplication"),
+			HaveKey("DeduplicationScope"),
+			HaveKey("FifoThroughputLimit"),
+		))
 	})
 
 	It("should have outputs for connection details", func() {
-		builder := &sqs.QueueTemplateBuilder{}
-		text, err := builder.Build()
-		Expect(err).ToNot(HaveOccurred())
-		t, err := goformation.ParseYAML([]byte(text))
-		Expect(err).ToNot(HaveOccurred())
 		Expect(t.Outputs).To(And(
 			HaveKey(sqs.OutputPrimaryQueueARN),
 			HaveKey(sqs.OutputPrimaryQueueURL),
@@ -154,4 +212,505 @@ var _ = Describe("QueueTemplateBuilder", func() {
 			HaveKey(sqs.OutputSecondaryQueueURL),
 		))
 	})
+	Context("when the primary queue retention and visibility are set", func() {
+		BeforeEach(func() {
+			params[sqs.ParamMessageRetentionPeriod] = "3600"
+			params[sqs.ParamVisibilityTimeout] = "90"
+		})
+
+		It("should give the secondary queue the same values by default", func() {
+			Expect(secondaryQueue.MessageRetentionPeriod).To(Equal(3600))
+			Expect(secondaryQueue.VisibilityTimeout).To(Equal(90))
+			Expect(secondaryQueue.DelaySeconds).To(Equal(0))
+			Expect(secondaryQueue.MaximumMessageSize).To(Equal(262144))
+			Expect(t.Resources[sqs.ResourceSecondaryQueue].Properties).To(HaveKeyWithValue("RedriveAllowPolicy", BeNil()))
+		})
+	})
+
+	Context("when the dead letter queue is configured", func() {
+		BeforeEach(func() {
+			builder.QueueName = "q-name-a"
+			params[sqs.ParamMessageRetentionPeriod] = "3600"
+			params[sqs.ParamDLQDelaySeconds] = "5"
+			params[sqs.ParamDLQMaximumMessageSize] = "2048"
+			params[sqs.ParamDLQMessageRetentionPeriod] = "1209600"
+			params[sqs.ParamDLQRedrivePermission] = sqs.DLQRedrivePermissionByQueue
+			params[sqs.ParamDLQVisibilityTimeout] = "120"
+		})
+
+		It("should configure the secondary queue independently", func() {
+			Expect(secondaryQueue.DelaySeconds).To(Equal(5))
+			Expect(secondaryQueue.MaximumMessageSize).To(Equal(2048))
+			Expect(secondaryQueue.MessageRetentionPeriod).To(Equal(1209600))
+			Expect(secondaryQueue.VisibilityTimeout).To(Equal(120))
+			Expect(primaryQueue.MessageRetentionPeriod).To(Equal(3600))
+		})
+
+		It("should only allow the primary queue to redrive to the secondary queue", func() {
+			Expect(t.Resources[sqs.ResourceSecondaryQueue].Properties).To(HaveKeyWithValue("RedriveAllowPolicy", And(
+				HaveKeyWithValue("redrivePermission", sqs.DLQRedrivePermissionByQueue),
+				HaveKeyWithValue("sourceQueueArns", ConsistOf(HaveSuffix(":q-name-a-pri"))),
+			)))
+		})
+	})
+
+	Context("when named queues are set", func() {
+		BeforeEach(func() {
+			builder.QueueName = "q-name-a"
+			builder.Queues = []sqs.NamedQueue{
+				{Name: "jobs", DelaySeconds: aws.Int(5), RedriveMaxReceiveCount: aws.Int(3)},
+				{Name: "mail-out", VisibilityTimeout: aws.Int(60), RedriveMaxReceiveCount: aws.Int(0)},
+			}
+			params[sqs.ParamDLQRedrivePermission] = sqs.DLQRedrivePermissionByQueue
+		})
+
+		It("should create a queue for each", func() {
+			jobs := t.Queue("QueueJobs")
+			Expect(jobs.QueueName).To(Equal("q-name-a-jobs"))
+			Expect(jobs.DelaySeconds).To(Equal(5))
+			mail := t.Queue("QueueMailOut")
+			Expect(mail.QueueName).To(Equal("q-name-a-mail-out"))
+			Expect(mail.VisibilityTimeout).To(Equal(60))
+			Expect(t.Resources["QueueMailOut"].Properties).ToNot(HaveKey("DelaySeconds"))
+		})
+
+		It("should tag each queue with its name", func() {
+			Expect(t.Queue("QueueMailOut").Tags).To(ContainElements(
+				goformationtags.Tag{Key: "QueueType", Value: "Named"},
+				goformationtags.Tag{Key: "QueueName", Value: "mail-out"},
+			))
+		})
+
+		It("should only redrive queues with a receive count to the secondary queue", func() {
+			Expect(t.Queue("QueueJobs").RedrivePolicy).To(Equal(map[string]interface{}{
+				"deadLetterTargetArn": "SecondaryQueue.Arn",
+				"maxReceiveCount":     float64(3),
+			}))
+			Expect(t.Queue("QueueMailOut").RedrivePolicy).To(BeNil())
+		})
+
+		It("should allow the named queues to redrive to the secondary queue", func() {
+			Expect(t.Resources[sqs.ResourceSecondaryQueue].Properties).To(HaveKeyWithValue("RedriveAllowPolicy",
+				HaveKeyWithValue("sourceQueueArns", ConsistOf(
+					HaveSuffix(":q-name-a-pri"),
+					HaveSuffix(":q-name-a-jobs"),
+					HaveSuffix(":q-name-a-mail-out"),
+				)),
+			))
+		})
+
+		It("should output the URL and ARN of each", func() {
+			Expect(t.Outputs).To(HaveKey("QueueJobsURL"))
+			Expect(t.Outputs).To(HaveKeyWithValue("QueueJobsARN", HaveKeyWithValue("Value", "QueueJobs.Arn")))
+			Expect(t.Outputs).To(HaveKey("QueueMailOutURL"))
+			Expect(t.Outputs).To(HaveKey("QueueMailOutARN"))
+		})
+
+		Context("for a FIFO queue", func() {
+			BeforeEach(func() {
+				builder.FIFOQueue = true
+			})
+
+			It("should make the named queues FIFO", func() {
+				jobs := t.Queue("QueueJobs")
+				Expect(jobs.QueueName).To(Equal("q-name-a-jobs.fifo"))
+				Expect(jobs.FifoQueue).To(BeTrue())
+			})
+		})
+	})
+
+	It("should not create a topic by default", func() {
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceTopic))
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceQueuePolicy))
+		Expect(t.Outputs).ToNot(HaveKey(sqs.OutputTopicARN))
+	})
+
+	Context("when Topic is set", func() {
+		BeforeEach(func() {
+			builder.QueueName = "q-name-a"
+			builder.Topic = true
+			builder.Queues = []sqs.NamedQueue{
+				{Name: "jobs", FilterPolicy: []byte(`{"event": ["job"]}`), RawMessageDelivery: aws.Bool(true)},
+			}
+		})
+
+		It("should create a topic", func() {
+			Expect(t.Resources).To(HaveKey(sqs.ResourceTopic))
+			topic := t.Resources[sqs.ResourceTopic]
+			Expect(topic.Type).To(Equal("AWS::SNS::Topic"))
+			Expect(topic.Properties).To(HaveKeyWithValue("TopicName", "q-name-a"))
+			Expect(topic.Properties).ToNot(HaveKey("FifoTopic"))
+			Expect(topic.Properties).To(HaveKeyWithValue("KmsMasterKeyId", BeNil()))
+		})
+
+		It("should output the topic ARN", func() {
+			Expect(t.Outputs).To(HaveKey(sqs.OutputTopicARN))
+		})
+
+		It("should subscribe the primary queue", func() {
+			subscription := t.Resources[sqs.ResourcePrimaryQueueSubscription]
+			Expect(subscription.Type).To(Equal("AWS::SNS::Subscription"))
+			Expect(subscription.Properties).To(HaveKeyWithValue("Protocol", "sqs"))
+			Expect(subscription.Properties).To(HaveKeyWithValue("Endpoint", "PrimaryQueue.Arn"))
+			Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", false))
+			Expect(subscription.Properties).ToNot(HaveKey("FilterPolicy"))
+		})
+
+		It("should subscribe the named queues with their own settings", func() {
+			subscription := t.Resources["QueueJobsSubscription"]
+			Expect(subscription.Type).To(Equal("AWS::SNS::Subscription"))
+			Expect(subscription.Properties).To(HaveKeyWithValue("Endpoint", "QueueJobs.Arn"))
+			Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", true))
+			Expect(subscription.Properties).To(HaveKeyWithValue("FilterPolicy", map[string]interface{}{
+				"event": []interface{}{"job"},
+			}))
+		})
+
+		It("should let the topic send to the subscribed queues", func() {
+			policy := t.Resources[sqs.ResourceQueuePolicy]
+			Expect(policy.Type).To(Equal("AWS::SQS::QueuePolicy"))
+			Expect(policy.Properties).To(HaveKeyWithValue("Queues", HaveLen(2)))
+			Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(And(
+				HaveKeyWithValue("Action", "sqs:SendMessage"),
+				HaveKeyWithValue("Principal", HaveKeyWithValue("Service", "sns.amazonaws.com")),
+				HaveKeyWithValue("Resource", ConsistOf("PrimaryQueue.Arn", "QueueJobs.Arn")),
+				HaveKeyWithValue("Condition", HaveKey("ArnEquals")),
+			)))))
+		})
+
+		Context("and raw message delivery is set", func() {
+			BeforeEach(func() {
+				params[sqs.ParamRawMessageDelivery] = "true"
+				builder.FilterPolicy = `{"store":["example_corp"]}`
+			})
+
+			It("should configure the primary queue subscription", func() {
+				subscription := t.Resources[sqs.ResourcePrimaryQueueSubscription]
+				Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", true))
+				Expect(subscription.Properties).To(HaveKeyWithValue("FilterPolicy", map[string]interface{}{
+					"store": []interface{}{"example_corp"},
+				}))
+			})
+		})
+
+		Context("for a FIFO queue", func() {
+			BeforeEach(func() {
+				builder.FIFOQueue = true
+			})
+
+			It("should create a FIFO topic", func() {
+				topic := t.Resources[sqs.ResourceTopic]
+				Expect(topic.Properties).To(HaveKeyWithValue("TopicName", "q-name-a.fifo"))
+				Expect(topic.Properties).To(HaveKeyWithValue("FifoTopic", true))
+			})
+		})
+
+		Context("with KMS encryption", func() {
+			BeforeEach(func() {
+				params[sqs.ParamEncryption] = sqs.EncryptionKMS
+			})
+
+			It("should encrypt the topic with the queue key", func() {
+				Expect(t.Resources[sqs.ResourceTopic].Properties).To(HaveKeyWithValue("KmsMasterKeyId", "QueueKey.Arn"))
+			})
+
+			It("should let SNS use the key to deliver to the queues", func() {
+				Expect(t.Resources[sqs.ResourceQueueKey].Properties).To(HaveKeyWithValue("KeyPolicy", HaveKeyWithValue("Statement", ContainElement(And(
+					HaveKeyWithValue("Principal", HaveKeyWithValue("Service", "sns.amazonaws.com")),
+					HaveKeyWithValue("Action", ConsistOf("kms:Decrypt", "kms:GenerateDataKey")),
+				)))))
+			})
+		})
+	})
+
+	Context("when Broadcast is set", func() {
+		BeforeEach(func() {
+			builder.QueueName = "q-name-a"
+			builder.Topic = true
+			builder.Broadcast = true
+			builder.SingleQueue = true
+		})
+
+		It("should only create the topic", func() {
+			Expect(t.Resources).To(HaveKey(sqs.ResourceTopic))
+			Expect(t.Resources).ToNot(HaveKey(sqs.ResourcePrimaryQueue))
+			Expect(t.Resources).ToNot(HaveKey(sqs.ResourcePrimaryQueueSubscription))
+			Expect(t.Resources).ToNot(HaveKey(sqs.ResourceQueuePolicy))
+		})
+
+		It("should only output the topic", func() {
+			Expect(t.Outputs).To(HaveKey(sqs.OutputTopicARN))
+			Expect(t.Outputs).ToNot(HaveKey(sqs.OutputPrimaryQueueURL))
+			Expect(t.Outputs).ToNot(HaveKey(sqs.OutputPrimaryQueueARN))
+		})
+
+		Context("with KMS encryption", func() {
+			BeforeEach(func() {
+				params[sqs.ParamEncryption] = sqs.EncryptionKMS
+			})
+
+			It("should create a key for the topic and binding queues", func() {
+				Expect(t.Resources[sqs.ResourceQueueKey].Properties).To(HaveKeyWithValue("Description", "Encryption key for q-name-a and its binding queues"))
+				Expect(t.Outputs).To(HaveKey(sqs.OutputKMSKeyARN))
+			})
+		})
+	})
+
+	Context("when AllowedSenders are set", func() {
+		BeforeEach(func() {
+			builder.Queues = []sqs.NamedQueue{{Name: "jobs"}}
+			builder.AllowedSenders = []sqs.AllowedSender{
+				{Service: "s3.amazonaws.com", SourceARN: "arn:aws:s3:::uploads"},
+				{Account: "123456789012"},
+			}
+		})
+
+		It("should let the senders send to the primary queue", func() {
+			policy := t.Resources[sqs.ResourceQueuePolicy]
+			Expect(policy.Type).To(Equal("AWS::SQS::QueuePolicy"))
+			Expect(policy.Properties).To(HaveKeyWithValue("Queues", HaveLen(1)))
+			Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(
+				And(
+					HaveKeyWithValue("Action", "sqs:SendMessage"),
+					HaveKeyWithValue("Principal", HaveKeyWithValue("Service", "s3.amazonaws.com")),
+					HaveKeyWithValue("Resource", ConsistOf("PrimaryQueue.Arn")),
+					HaveKeyWithValue("Condition", HaveKeyWithValue("ArnEquals", HaveKeyWithValue("aws:SourceArn", "arn:aws:s3:::uploads"))),
+				),
+				And(
+					HaveKeyWithValue("Action", "sqs:SendMessage"),
+					HaveKeyWithValue("Principal", HaveKeyWithValue("AWS", HaveSuffix(":iam::123456789012:root"))),
+					HaveKeyWithValue("Resource", ConsistOf("PrimaryQueue.Arn")),
+					Not(HaveKey("Condition")),
+				),
+			))))
+		})
+
+		Context("and Topic is set", func() {
+			BeforeEach(func() {
+				builder.Topic = true
+			})
+
+			It("should add the senders to the topic's statement", func() {
+				policy := t.Resources[sqs.ResourceQueuePolicy]
+				Expect(policy.Properties).To(HaveKeyWithValue("Queues", HaveLen(2)))
+				Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", HaveLen(3))))
+			})
+		})
+	})
+
+	Context("when DenyInsecureTransport is set", func() {
+		BeforeEach(func() {
+			builder.DenyInsecureTransport = true
+			builder.Queues = []sqs.NamedQueue{{Name: "jobs"}}
+		})
+
+		It("should deny requests to every queue without TLS", func() {
+			policy := t.Resources[sqs.ResourceQueuePolicy]
+			Expect(policy.Properties).To(HaveKeyWithValue("Queues", HaveLen(3)))
+			Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(And(
+				HaveKeyWithValue("Effect", "Deny"),
+				HaveKeyWithValue("Principal", "*"),
+				HaveKeyWithValue("Action", "sqs:*"),
+				HaveKeyWithValue("Resource", ConsistOf("PrimaryQueue.Arn", "SecondaryQueue.Arn", "QueueJobs.Arn")),
+				HaveKeyWithValue("Condition", HaveKeyWithValue("Bool", HaveKeyWithValue("aws:SecureTransport", "false"))),
+			)))))
+		})
+
+		Context("and SingleQueue is set", func() {
+			BeforeEach(func() {
+				builder.SingleQueue = true
+			})
+
+			It("should leave out the secondary queue", func() {
+				policy := t.Resources[sqs.ResourceQueuePolicy]
+				Expect(policy.Properties).To(HaveKeyWithValue("Queues", HaveLen(2)))
+			})
+		})
+	})
+
+	Context("when RestrictPrincipals is set", func() {
+		BeforeEach(func() {
+			builder.RestrictPrincipals = true
+			builder.ResourcePrefix = "sqs-broker"
+			builder.BrokerPrincipalARN = "arn:aws:iam::123456789012:role/sqs-broker"
+		})
+
+		It("should deny principals in the account other than binding users and roles and the broker", func() {
+			policy := t.Resources[sqs.ResourceQueuePolicy]
+			Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(And(
+				HaveKeyWithValue("Effect", "Deny"),
+				HaveKeyWithValue("Principal", "*"),
+				HaveKeyWithValue("Resource", ConsistOf("PrimaryQueue.Arn", "SecondaryQueue.Arn")),
+				HaveKeyWithValue("Condition", And(
+					HaveKeyWithValue("ArnNotLike", HaveKeyWithValue("aws:PrincipalArn", ConsistOf(
+						HaveSuffix(":user/sqs-broker/*"),
+						HaveSuffix(":role/sqs-broker/*"),
+						"arn:aws:iam::123456789012:role/sqs-broker",
+					))),
+					HaveKeyWithValue("StringEquals", HaveKey("aws:PrincipalAccount")),
+				)),
+			)))))
+		})
+
+		It("should allow the role of a role binding", func() {
+			userTemplate, err := sqs.UserTemplateBuilder{
+				BindingID:             "binding-id",
+				ResourcePrefix:        builder.ResourcePrefix,
+				PrimaryQueueARN:       "arn:aws:sqs:eu-west-2:123456789012:q-pri",
+				AccessPolicy:          sqs.AccessPolicyConsumer,
+				BindingType:           sqs.BindingTypeRole,
+				RoleTrustPrincipalARN: "arn:aws:iam::123456789012:role/platform",
+				ExternalID:            "5a1e3c1e-1b7a-4bb0-9d0c-2f8e0a7f6c11",
+			}.Build()
+			Expect(err).ToNot(HaveOccurred())
+			roleTemplate, err := goformation.ParseYAML([]byte(userTemplate))
+			Expect(err).ToNot(HaveOccurred())
+			role, err := roleTemplate.GetIAMRoleWithName(sqs.ResourceRole)
+			Expect(err).ToNot(HaveOccurred())
+			// The parsed template resolves AWS::Partition to an empty string.
+			roleARN := "arn::iam::123456789012:role" + role.Path + role.RoleName
+
+			policy := t.Resources[sqs.ResourceQueuePolicy]
+			statement := policy.Properties["PolicyDocument"].(map[string]interface{})["Statement"].([]interface{})[0]
+			allowed := statement.(map[string]interface{})["Condition"].(map[string]interface{})["ArnNotLike"].(map[string]interface{})["aws:PrincipalArn"]
+			Expect(allowed).To(ContainElement(WithTransform(func(pattern string) bool {
+				matched, err := path.Match(pattern, roleARN)
+				return err == nil && matched
+			}, BeTrue())))
+		})
+	})
+
+	It("should not create alarms by default", func() {
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceAlarmTopic))
+	})
+
+	Context("when Alarms are set", func() {
+		BeforeEach(func() {
+			builder.QueueName = "q-name-a"
+			builder.Queues = []sqs.NamedQueue{{Name: "jobs"}}
+			builder.Alarms = &sqs.Alarms{
+				OldestMessageAge: aws.Int(600),
+				QueueDepth:       aws.Int(1000),
+				Endpoints: []sqs.AlarmEndpoint{
+					{Protocol: sqs.AlarmProtocolEmail, Endpoint: "team@example.com"},
+					{Protocol: sqs.AlarmProtocolHTTPS, Endpoint: "https://alerts.example.com/sqs"},
+				},
+			}
+		})
+
+		It("should create a topic for the notifications", func() {
+			topic := t.Resources[sqs.ResourceAlarmTopic]
+			Expect(topic.Type).To(Equal("AWS::SNS::Topic"))
+			Expect(topic.Properties).To(HaveKeyWithValue("TopicName", "q-name-a-alarms"))
+		})
+
+		It("should subscribe the endpoints to the topic", func() {
+			Expect(t.Resources["AlarmTopicSubscription0"].Properties).To(And(
+				HaveKeyWithValue("Protocol", "email"),
+				HaveKeyWithValue("Endpoint", "team@example.com"),
+			))
+			Expect(t.Resources["AlarmTopicSubscription1"].Properties).To(And(
+				HaveKeyWithValue("Protocol", "https"),
+				HaveKeyWithValue("Endpoint", "https://alerts.example.com/sqs"),
+			))
+		})
+
+		It("should alarm when the secondary queue has messages", func() {
+			alarm := t.Resources["SecondaryQueueDepthAlarm"]
+			Expect(alarm.Type).To(Equal("AWS::CloudWatch::Alarm"))
+			Expect(alarm.Properties).To(And(
+				HaveKeyWithValue("MetricName", "ApproximateNumberOfMessagesVisible"),
+				HaveKeyWithValue("ComparisonOperator", "GreaterThanThreshold"),
+				HaveKeyWithValue("Threshold", BeNumerically("==", 0)),
+				HaveKeyWithValue("Dimensions", ConsistOf(HaveKeyWithValue("Value", "q-name-a-sec"))),
+			))
+		})
+
+		It("should alarm on the age of the oldest message in each queue", func() {
+			for name, queueName := range map[string]string{"PrimaryQueueAgeAlarm": "q-name-a-pri", "QueueJobsAgeAlarm": "q-name-a-jobs"} {
+				Expect(t.Resources[name].Properties).To(And(
+					HaveKeyWithValue("MetricName", "ApproximateAgeOfOldestMessage"),
+					HaveKeyWithValue("Threshold", BeNumerically("==", 600)),
+					HaveKeyWithValue("Dimensions", ConsistOf(HaveKeyWithValue("Value", queueName))),
+				), name)
+			}
+		})
+
+		It("should alarm on the depth of each queue", func() {
+			for name, queueName := range map[string]string{"PrimaryQueueDepthAlarm": "q-name-a-pri", "QueueJobsDepthAlarm": "q-name-a-jobs"} {
+				Expect(t.Resources[name].Properties).To(And(
+					HaveKeyWithValue("MetricName", "ApproximateNumberOfMessagesVisible"),
+					HaveKeyWithValue("Threshold", BeNumerically("==", 1000)),
+					HaveKeyWithValue("AlarmActions", HaveLen(1)),
+					HaveKeyWithValue("Dimensions", ConsistOf(HaveKeyWithValue("Value", queueName))),
+				), name)
+			}
+		})
+
+		Context("and the DLQ alarm is turned off", func() {
+			BeforeEach(func() {
+				builder.Alarms.DLQDepth = aws.Bool(false)
+				builder.Alarms.QueueDepth = nil
+			})
+
+			It("should only create the requested alarms", func() {
+				Expect(t.Resources).ToNot(HaveKey("SecondaryQueueDepthAlarm"))
+				Expect(t.Resources).ToNot(HaveKey("PrimaryQueueDepthAlarm"))
+				Expect(t.Resources).To(HaveKey("PrimaryQueueAgeAlarm"))
+			})
+		})
+	})
+
+	It("should use SQS-managed encryption by default", func() {
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceQueueKey))
+		for _, name := range []string{sqs.ResourcePrimaryQueue, sqs.ResourceSecondaryQueue} {
+			Expect(t.Resources[name].Properties).To(HaveKeyWithValue("SqsManagedSseEnabled", true))
+			Expect(t.Resources[name].Properties).To(HaveKeyWithValue("KmsMasterKeyId", BeNil()))
+		}
+		Expect(t.Outputs).ToNot(HaveKey(sqs.OutputKMSKeyARN))
+	})
+
+	Context("when KMS encryption is requested", func() {
+		BeforeEach(func() {
+			params[sqs.ParamEncryption] = sqs.EncryptionKMS
+		})
+
+		It("should create a dedicated key with rotation", func() {
+			Expect(t.Resources).To(HaveKey(sqs.ResourceQueueKey))
+			key := t.Resources[sqs.ResourceQueueKey]
+			Expect(key.Type).To(Equal("AWS::KMS::Key"))
+			Expect(key.Properties).To(HaveKeyWithValue("EnableKeyRotation", true))
+		})
+
+		It("should encrypt both queues with the dedicated key", func() {
+			Expect(primaryQueue.KmsMasterKeyId).To(Equal("QueueKey.Arn"))
+			Expect(secondaryQueue.KmsMasterKeyId).To(Equal("QueueKey.Arn"))
+			for _, name := range []string{sqs.ResourcePrimaryQueue, sqs.ResourceSecondaryQueue} {
+				Expect(t.Resources[name].Properties).To(HaveKeyWithValue("SqsManagedSseEnabled", false))
+			}
+		})
+
+		It("should output the key ARN", func() {
+			Expect(t.Outputs).To(HaveKeyWithValue(sqs.OutputKMSKeyARN, HaveKeyWithValue("Value", "QueueKey.Arn")))
+		})
+
+		Context("with an existing key", func() {
+			BeforeEach(func() {
+				params[sqs.ParamKMSKeyARN] = "arn:aws:kms:eu-west-2:123456789012:key/abcd"
+			})
+
+			It("should not create a key", func() {
+				Expect(t.Resources).ToNot(HaveKey(sqs.ResourceQueueKey))
+			})
+
+			It("should encrypt both queues with the existing key", func() {
+				Expect(primaryQueue.KmsMasterKeyId).To(Equal("arn:aws:kms:eu-west-2:123456789012:key/abcd"))
+				Expect(secondaryQueue.KmsMasterKeyId).To(Equal("arn:aws:kms:eu-west-2:123456789012:key/abcd"))
+			})
+
+			It("should output the key ARN", func() {
+				Expect(t.Outputs).To(HaveKeyWithValue(sqs.OutputKMSKeyARN, HaveKeyWithValue("Value", "arn:aws:kms:eu-west-2:123456789012:key/abcd")))
+			})
+		})
+	})
 })
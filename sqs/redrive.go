@@ -0,0 +1,111 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// RedriveParams are update parameters that move messages from the
+// secondary queue back to the queues they failed on rather than changing
+// the queue stack.
+type RedriveParams struct {
+	// RedriveDLQ starts moving the messages in the secondary queue back
+	// to the primary or named queue each came from.
+	RedriveDLQ *bool `json:"redrive_dlq,omitempty" dlq:"true"`
+	// RedriveDLQMaxMessagesPerSecond limits the rate messages are moved
+	// at. SQS picks the rate when it is not set.
+	RedriveDLQMaxMessagesPerSecond *int `json:"redrive_dlq_max_messages_per_second,omitempty" minimum:"1" maximum:"500" dlq:"true"`
+}
+
+// redriveDLQ starts a message move task from the secondary queue. The
+// task has no destination, so SQS returns each message to the primary or
+// named queue it was dead-lettered from. Its progress is reported by
+// LastOperation.
+func (s *Provider) redriveDLQ(ctx context.Context, stack *cloudformation.Stack, redrive RedriveParams) (*domain.UpdateServiceSpec, error) {
+	sourceARN := getStackOutput(stack, OutputSecondaryQueueARN)
+	if sourceARN == "" || !redrivesToSecondaryQueue(stackParamValues(stack)) {
+		return nil, apiresponses.NewFailureResponse(
+			fmt.Errorf("redrive_dlq requires a queue with a dead letter queue, set redrive_max_receive_count first"),
+			http.StatusUnprocessableEntity,
+			"no-dead-letter-queue",
+		)
+	}
+
+	input := &StartMessageMoveTaskInput{
+		SourceArn: aws.String(sourceARN),
+	}
+	if redrive.RedriveDLQMaxMessagesPerSecond != nil {
+		input.MaxNumberOfMessagesPerSecond = aws.Int64(int64(*redrive.RedriveDLQMaxMessagesPerSecond))
+	}
+	if _, err := s.Client.StartMessageMoveTaskWithContext(ctx, input); err != nil {
+		return nil, err
+	}
+
+	return &domain.UpdateServiceSpec{
+		OperationData: RedriveOperation,
+		IsAsync:       true,
+	}, nil
+}
+
+// redrivesToSecondaryQueue returns true if the primary queue or any of
+// the named queues dead-letter into the secondary queue.
+func redrivesToSecondaryQueue(values map[string]string) bool {
+	if values[ParamRedriveMaxReceiveCount] != "0" {
+		return true
+	}
+	for _, queue := range decodeNamedQueues(values[ParamQueues]) {
+		if queue.HasRedrive() {
+			return true
+		}
+	}
+	return false
+}
+
+// redriveOperation reports on the most recent message move task from the
+// secondary queue.
+func (s *Provider) redriveOperation(ctx context.Context, stack *cloudformation.Stack) (*domain.LastOperation, error) {
+	out, err := s.Client.ListMessageMoveTasksWithContext(ctx, &ListMessageMoveTasksInput{
+		SourceArn:  aws.String(getStackOutput(stack, OutputSecondaryQueueARN)),
+		MaxResults: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Results) == 0 {
+		return &domain.LastOperation{
+			State:       domain.Failed,
+			Description: "failed: sqs has no record of the redrive",
+		}, nil
+	}
+
+	task := out.Results[0]
+	moved := aws.Int64Value(task.ApproximateNumberOfMessagesMoved)
+	switch aws.StringValue(task.Status) {
+	case MessageMoveTaskStatusCompleted:
+		return &domain.LastOperation{
+			State:       domain.Succeeded,
+			Description: fmt.Sprintf("done: moved %d messages", moved),
+		}, nil
+	case MessageMoveTaskStatusFailed:
+		return &domain.LastOperation{
+			State:       domain.Failed,
+			Description: fmt.Sprintf("failed: %s after moving %d messages", aws.StringValue(task.FailureReason), moved),
+		}, nil
+	case MessageMoveTaskStatusCancelling, MessageMoveTaskStatusCancelled:
+		return &domain.LastOperation{
+			State:       domain.Failed,
+			Description: fmt.Sprintf("failed: cancelled after moving %d messages", moved),
+		}, nil
+	default:
+		return &domain.LastOperation{
+			State:       domain.InProgress,
+			Description: fmt.Sprintf("pending: moved %d of %d messages", moved, aws.Int64Value(task.ApproximateNumberOfMessagesToMove)),
+		}, nil
+	}
+}
@@ -0,0 +1,178 @@
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+type contextKey string
+
+const predecessorBindingIDKey contextKey = "predecessor_binding_id"
+
+// WithPredecessorBindingID returns a context for a bind request that
+// rotates the credentials of the predecessor binding.
+func WithPredecessorBindingID(ctx context.Context, bindingID string) context.Context {
+	return context.WithValue(ctx, predecessorBindingIDKey, bindingID)
+}
+
+// PredecessorBindingID returns the binding a bind request rotates, if
+// any.
+func PredecessorBindingID(ctx context.Context) string {
+	bindingID, _ := ctx.Value(predecessorBindingIDKey).(string)
+	return bindingID
+}
+
+// BindingRotation adds OSB binding rotation to the broker API, which the
+// API library does not support. It passes the predecessor_binding_id of
+// bind requests to the provider through the request context, and
+// advertises binding_rotatable in the catalog for every plan except
+// broadcast plans, whose bindings each have their own queue.
+func BindingRotation(next http.Handler, provider *Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v2/catalog":
+			rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			body := rec.body.Bytes()
+			if rec.status == http.StatusOK {
+				if rotatable, err := provider.rotatableCatalog(body); err == nil {
+					body = rotatable
+				}
+			}
+			rec.writeTo(w, body)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/service_bindings/"):
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			var details struct {
+				PredecessorBindingID string `json:"predecessor_binding_id"`
+			}
+			if json.Unmarshal(body, &details) == nil && details.PredecessorBindingID != "" {
+				r = r.WithContext(WithPredecessorBindingID(r.Context(), details.PredecessorBindingID))
+			}
+			next.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rotatableCatalog marks the plans in a catalog response as
+// binding_rotatable.
+func (s *Provider) rotatableCatalog(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var catalog map[string]interface{}
+	if err := decoder.Decode(&catalog); err != nil {
+		return nil, err
+	}
+	services, _ := catalog["services"].([]interface{})
+	for _, svc := range services {
+		service, ok := svc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		plans, _ := service["plans"].([]interface{})
+		for _, p := range plans {
+			plan, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := plan["id"].(string)
+			name, _ := plan["name"].(string)
+			if !s.getPlan(domain.ServicePlan{ID: id, Name: name}).Broadcast {
+				plan["binding_rotatable"] = true
+			}
+		}
+	}
+	return json.Marshal(catalog)
+}
+
+// responseRecorder holds on to a response so it can be changed before
+// it is written.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	return rec.body.Write(data)
+}
+
+// writeTo writes the recorded response to w with the given body.
+func (rec *responseRecorder) writeTo(w http.ResponseWriter, body []byte) {
+	for key, values := range rec.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(rec.status)
+	w.Write(body)
+}
+
+// predecessorAccess returns the access policy and binding type of the
+// binding being rotated, which the new binding is given. The predecessor
+// must be a binding to the same instance. Bindings from before binding
+// types were recorded are for IAM users.
+func (s *Provider) predecessorAccess(ctx context.Context, instanceID, predecessorID string, plan PlanConfig) (AccessPolicy, BindingType, error) {
+	if plan.Broadcast {
+		return "", "", rotationFailure(fmt.Errorf("bindings on broadcast plans cannot be rotated: each binding has its own queue"))
+	}
+	stack, err := s.getStack(ctx, s.getStackName(predecessorID))
+	if err == ErrStackNotFound {
+		return "", "", rotationFailure(fmt.Errorf("predecessor binding %s does not exist", predecessorID))
+	} else if err != nil {
+		return "", "", err
+	}
+	tags := map[string]string{}
+	for _, tag := range stack.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	if tags[TagInstanceId] != instanceID {
+		return "", "", rotationFailure(fmt.Errorf("predecessor binding %s does not belong to this service instance", predecessorID))
+	}
+	switch aws.StringValue(stack.StackStatus) {
+	case cloudformation.StackStatusCreateComplete, cloudformation.StackStatusUpdateComplete:
+	default:
+		return "", "", rotationFailure(fmt.Errorf("predecessor binding %s is not ready to be rotated", predecessorID))
+	}
+	accessPolicy, ok := tags[TagAccessPolicy]
+	if !ok {
+		return "", "", rotationFailure(fmt.Errorf("predecessor binding %s cannot be rotated as its access policy is not recorded", predecessorID))
+	}
+	bindingType, ok := tags[TagBindingType]
+	if !ok {
+		bindingType = BindingTypeUser
+	}
+	return accessPolicy, bindingType, nil
+}
+
+func rotationFailure(err error) error {
+	return apiresponses.NewFailureResponse(
+		err,
+		http.StatusUnprocessableEntity,
+		"binding-rotation",
+	)
+}
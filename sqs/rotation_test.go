@@ -0,0 +1,72 @@
+package sqs_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BindingRotation", func() {
+	var (
+		provider    *sqs.Provider
+		predecessor string
+		handler     http.Handler
+	)
+
+	BeforeEach(func() {
+		provider = &sqs.Provider{
+			Plans: map[string]sqs.PlanConfig{
+				"uuid-4": {QueueType: sqs.QueueTypeStandard, Topic: true, Broadcast: true},
+			},
+		}
+		predecessor = ""
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/catalog" {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"services": [{"id": "uuid-1", "plans": [
+					{"id": "uuid-2", "name": "standard"},
+					{"id": "uuid-4", "name": "broadcast", "metadata": {"costs": [{"amount": {"gbp": 0.5}}]}}
+				]}]}`))
+				return
+			}
+			predecessor = sqs.PredecessorBindingID(r.Context())
+			body, _ := ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		})
+		handler = sqs.BindingRotation(next, provider)
+	})
+
+	It("advertises binding rotation on every plan except broadcast plans", func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/catalog", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"services": [{"id": "uuid-1", "plans": [
+			{"id": "uuid-2", "name": "standard", "binding_rotatable": true},
+			{"id": "uuid-4", "name": "broadcast", "metadata": {"costs": [{"amount": {"gbp": 0.5}}]}}
+		]}]}`))
+	})
+
+	It("passes the predecessor of a bind request to the provider", func() {
+		body := `{"service_id": "uuid-1", "plan_id": "uuid-2", "predecessor_binding_id": "binding-1"}`
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance-1/service_bindings/binding-2", strings.NewReader(body)))
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+		Expect(predecessor).To(Equal("binding-1"))
+		Expect(json.RawMessage(rec.Body.Bytes())).To(MatchJSON(body))
+	})
+
+	It("passes no predecessor for other bind requests", func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance-1/service_bindings/binding-2", strings.NewReader(`{"service_id": "uuid-1"}`)))
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+		Expect(predecessor).To(BeEmpty())
+	})
+})
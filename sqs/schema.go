@@ -0,0 +1,469 @@
+package sqs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pivotal-cf/brokerapi/domain"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// ParamsSchema is a JSON schema for an object of service parameters. It
+// is generated from struct tags by NewParamsSchema:
+//
+//	`json:"name" minimum:"0" maximum:"900" enum:"a,b" fifo:"true" dlq:"true" topic:"true"`
+//
+// Fields without a json name are not parameters. Fields tagged fifo are
+// only offered on FIFO plans, fields tagged dlq only on plans with a
+// dead letter queue and fields tagged topic only on plans with a topic.
+// Slices of structs are arrays of objects, limited in length by a
+// maxItems tag, whose fields may be tagged required. Structs are objects
+// described by their own fields. Maps of strings are objects with any
+// keys, limited in number by a maxProperties tag. json.RawMessage fields
+// are objects whose contents are not checked.
+type ParamsSchema struct {
+	Properties map[string]*PropertySchema
+	Required   []string
+}
+
+// PropertySchema describes a single parameter.
+type PropertySchema struct {
+	Type          string
+	Minimum       *int
+	Maximum       *int
+	Enum          []string
+	FIFO          bool
+	DLQ           bool
+	Topic         bool
+	Items         *ParamsSchema
+	MaxItems      *int
+	Properties    *ParamsSchema
+	Values        *PropertySchema
+	MaxProperties *int
+}
+
+// NewParamsSchema generates a schema from the fields of a struct.
+func NewParamsSchema(v interface{}) ParamsSchema {
+	schema := ParamsSchema{Properties: map[string]*PropertySchema{}}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		property := &PropertySchema{
+			FIFO:  field.Tag.Get("fifo") == "true",
+			DLQ:   field.Tag.Get("dlq") == "true",
+			Topic: field.Tag.Get("topic") == "true",
+		}
+		kind := field.Type.Kind()
+		if kind == reflect.Ptr {
+			kind = field.Type.Elem().Kind()
+		}
+		switch {
+		case field.Type == reflect.TypeOf(json.RawMessage{}):
+			property.Type = "object"
+		case kind == reflect.Int:
+			property.Type = "integer"
+		case kind == reflect.Bool:
+			property.Type = "boolean"
+		case kind == reflect.String:
+			property.Type = "string"
+		case kind == reflect.Struct:
+			property.Type = "object"
+			elem := field.Type
+			if elem.Kind() == reflect.Ptr {
+				elem = elem.Elem()
+			}
+			properties := NewParamsSchema(reflect.Zero(elem).Interface())
+			property.Properties = &properties
+		case kind == reflect.Map:
+			if field.Type.Key().Kind() != reflect.String || field.Type.Elem().Kind() != reflect.String {
+				panic(fmt.Sprintf("unsupported parameter type %s for %s", field.Type, name))
+			}
+			property.Type = "object"
+			property.Values = &PropertySchema{Type: "string"}
+		case kind == reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.Struct {
+				panic(fmt.Sprintf("unsupported parameter type %s for %s", field.Type, name))
+			}
+			property.Type = "array"
+			items := NewParamsSchema(reflect.Zero(field.Type.Elem()).Interface())
+			property.Items = &items
+		default:
+			panic(fmt.Sprintf("unsupported parameter type %s for %s", field.Type, name))
+		}
+		if minimum, ok := field.Tag.Lookup("minimum"); ok {
+			property.Minimum = mustAtoi(minimum)
+		}
+		if maximum, ok := field.Tag.Lookup("maximum"); ok {
+			property.Maximum = mustAtoi(maximum)
+		}
+		if enum, ok := field.Tag.Lookup("enum"); ok {
+			property.Enum = strings.Split(enum, ",")
+		}
+		if maxItems, ok := field.Tag.Lookup("maxItems"); ok {
+			property.MaxItems = mustAtoi(maxItems)
+		}
+		if maxProperties, ok := field.Tag.Lookup("maxProperties"); ok {
+			property.MaxProperties = mustAtoi(maxProperties)
+		}
+		if field.Tag.Get("required") == "true" {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+func mustAtoi(s string) *int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return &i
+}
+
+// JSONSchema returns the schema in the form published in the catalog.
+func (schema ParamsSchema) JSONSchema() map[string]interface{} {
+	object := schema.objectSchema()
+	object["$schema"] = "http://json-schema.org/draft-04/schema#"
+	return object
+}
+
+func (schema ParamsSchema) objectSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name, property := range schema.Properties {
+		p := map[string]interface{}{
+			"type": property.Type,
+		}
+		if property.Minimum != nil {
+			p["minimum"] = *property.Minimum
+		}
+		if property.Maximum != nil {
+			p["maximum"] = *property.Maximum
+		}
+		if property.Enum != nil {
+			p["enum"] = property.Enum
+		}
+		if property.Items != nil {
+			p["items"] = property.Items.objectSchema()
+		}
+		if property.MaxItems != nil {
+			p["maxItems"] = *property.MaxItems
+		}
+		if property.Properties != nil {
+			for key, value := range property.Properties.objectSchema() {
+				p[key] = value
+			}
+		}
+		if property.Values != nil {
+			p["additionalProperties"] = map[string]interface{}{"type": property.Values.Type}
+		}
+		if property.MaxProperties != nil {
+			p["maxProperties"] = *property.MaxProperties
+		}
+		properties[name] = p
+	}
+	object := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(schema.Required) > 0 {
+		object["required"] = schema.Required
+	}
+	return object
+}
+
+// Validate checks raw parameters against the schema. The error names the
+// offending field.
+func (schema ParamsSchema) Validate(raw json.RawMessage) error {
+	if raw == nil {
+		return nil
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return invalidParams(fmt.Errorf("parameters must be a JSON object"))
+	}
+	if err := schema.validate(params, ""); err != nil {
+		return invalidParams(err)
+	}
+	return nil
+}
+
+// validate checks the fields of an object, prefixing the field names in
+// errors with path.
+func (schema ParamsSchema) validate(params map[string]json.RawMessage, path string) error {
+	for _, name := range schema.Required {
+		if _, ok := params[name]; !ok {
+			return fmt.Errorf("%s%s: required", path, name)
+		}
+	}
+	names := []string{}
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if !ok {
+			return fmt.Errorf("%s%s: unknown parameter", path, name)
+		}
+		if property.Type == "array" {
+			if err := property.validateItems(params[name], path+name); err != nil {
+				return err
+			}
+			continue
+		}
+		if property.Properties != nil {
+			var object map[string]json.RawMessage
+			if err := json.Unmarshal(params[name], &object); err != nil || object == nil {
+				return fmt.Errorf("%s%s: must be an object", path, name)
+			}
+			if err := property.Properties.validate(object, path+name+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if property.Values != nil {
+			if err := property.validateValues(params[name], path+name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := property.validate(params[name]); err != nil {
+			return fmt.Errorf("%s%s: %s", path, name, err)
+		}
+	}
+	return nil
+}
+
+func (property *PropertySchema) validateItems(raw json.RawMessage, path string) error {
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return fmt.Errorf("%s: must be an array of objects", path)
+	}
+	if property.MaxItems != nil && len(items) > *property.MaxItems {
+		return fmt.Errorf("%s: must have at most %d items", path, *property.MaxItems)
+	}
+	for i, item := range items {
+		if err := property.Items.validate(item, fmt.Sprintf("%s[%d].", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (property *PropertySchema) validateValues(raw json.RawMessage, path string) error {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil || values == nil {
+		return fmt.Errorf("%s: must be an object", path)
+	}
+	if property.MaxProperties != nil && len(values) > *property.MaxProperties {
+		return fmt.Errorf("%s: must have at most %d properties", path, *property.MaxProperties)
+	}
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := property.Values.validate(values[key]); err != nil {
+			return fmt.Errorf("%s.%s: %s", path, key, err)
+		}
+	}
+	return nil
+}
+
+func (property *PropertySchema) validate(raw json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	switch property.Type {
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("must be an integer")
+		}
+		i, err := number.Int64()
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		if property.Minimum != nil && i < int64(*property.Minimum) {
+			return fmt.Errorf("must be at least %d", *property.Minimum)
+		}
+		if property.Maximum != nil && i > int64(*property.Maximum) {
+			return fmt.Errorf("must be at most %d", *property.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("must be a boolean")
+		}
+	case "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("must be an object")
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		if property.Enum != nil && !contains(property.Enum, s) {
+			return fmt.Errorf("must be one of %s", strings.Join(property.Enum, ", "))
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidParams(err error) error {
+	return apiresponses.NewFailureResponse(
+		err,
+		http.StatusBadRequest,
+		"invalid-parameters",
+	)
+}
+
+// validateTemplateString checks that a tenant's string can be rendered
+// into a stack template as a quoted string without ending the quotes.
+func validateTemplateString(path, value string) error {
+	if strings.ContainsAny(value, "\"\\") || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return invalidParams(fmt.Errorf("%s: cannot contain quotes, backslashes or control characters", path))
+	}
+	return nil
+}
+
+// InstanceSchema returns the schema for provision and update parameters
+// on the plan, taking the plan's queue type and limits into account. The
+// queues and subscriptions of broadcast plans belong to the bindings, so
+// broadcast plans leave out the parameters for the instance's queues and
+// take the subscription parameters as binding parameters instead.
+func (plan PlanConfig) InstanceSchema() ParamsSchema {
+	schema := NewParamsSchema(QueueParams{})
+	if plan.Broadcast {
+		delete(schema.Properties, "queues")
+		delete(schema.Properties, "allowed_senders")
+		delete(schema.Properties, "alarms")
+	}
+	plan.filterProperties(schema)
+	plan.applyMaximums(schema)
+	if queues := schema.Properties["queues"]; queues != nil {
+		plan.filterProperties(*queues.Items)
+		plan.applyMaximums(*queues.Items)
+	}
+	if alarms := schema.Properties["alarms"]; alarms != nil {
+		plan.filterProperties(*alarms.Properties)
+	}
+	if !plan.HasDeadLetterQueue() {
+		zero := 0
+		schema.Properties["redrive_max_receive_count"].Maximum = &zero
+	}
+	return schema
+}
+
+// filterProperties removes the properties the plan does not support.
+func (plan PlanConfig) filterProperties(schema ParamsSchema) {
+	for name, property := range schema.Properties {
+		if property.FIFO && !plan.FIFO() {
+			delete(schema.Properties, name)
+		}
+		if property.DLQ && !plan.HasDeadLetterQueue() {
+			delete(schema.Properties, name)
+		}
+		if property.Topic && (!plan.Topic || plan.Broadcast) {
+			delete(schema.Properties, name)
+		}
+	}
+}
+
+// applyMaximums lowers the maximums of the schema's properties to the
+// plan's limits.
+func (plan PlanConfig) applyMaximums(schema ParamsSchema) {
+	v := reflect.ValueOf(plan.Maximums)
+	for i := 0; i < v.NumField(); i++ {
+		maximum, ok := v.Field(i).Interface().(*int)
+		if !ok || maximum == nil {
+			continue
+		}
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if property, ok := schema.Properties[name]; ok && (property.Maximum == nil || *maximum < *property.Maximum) {
+			property.Maximum = maximum
+		}
+	}
+}
+
+// UpdateSchema returns the schema for update parameters on the plan,
+// which also include the one-off actions that can be taken on a service.
+func (plan PlanConfig) UpdateSchema() ParamsSchema {
+	schema := plan.InstanceSchema()
+	for name, property := range NewParamsSchema(RedriveParams{}).Properties {
+		if property.DLQ && !plan.HasDeadLetterQueue() {
+			continue
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// BindingSchema returns the schema for binding parameters on the plan.
+func (plan PlanConfig) BindingSchema() ParamsSchema {
+	schema := NewParamsSchema(UserTemplateBuilder{})
+	for name, property := range schema.Properties {
+		if property.Topic && !plan.Broadcast {
+			delete(schema.Properties, name)
+		}
+	}
+	if len(plan.AccessPolicies) > 0 {
+		schema.Properties["access_policy"].Enum = plan.AccessPolicies
+	}
+	if len(plan.BindingTypes) > 0 {
+		schema.Properties["binding_type"].Enum = plan.BindingTypes
+	}
+	return schema
+}
+
+// bindingSchema returns the schema for binding parameters on the plan,
+// offering the configured access policies when the plan does not limit
+// them.
+func (s *Provider) bindingSchema(plan PlanConfig) ParamsSchema {
+	schema := plan.BindingSchema()
+	if len(plan.AccessPolicies) == 0 {
+		schema.Properties["access_policy"].Enum = sortedKeys(s.accessPolicies())
+	}
+	return schema
+}
+
+// Schemas returns the parameter schemas to publish in the catalog for a
+// plan.
+func (s *Provider) Schemas(plan domain.ServicePlan) *domain.ServiceSchemas {
+	config := s.getPlan(plan)
+	return &domain.ServiceSchemas{
+		Instance: domain.ServiceInstanceSchema{
+			Create: domain.Schema{Parameters: config.InstanceSchema().JSONSchema()},
+			Update: domain.Schema{Parameters: config.UpdateSchema().JSONSchema()},
+		},
+		Binding: domain.ServiceBindingSchema{
+			Create: domain.Schema{Parameters: s.bindingSchema(config).JSONSchema()},
+		},
+	}
+}
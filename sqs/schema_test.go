@@ -0,0 +1,285 @@
+package sqs_test
+
+import (
+	"encoding/json"
+
+	"github.com/alphagov/paas-sqs-broker/sqs"
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+var _ = Describe("ParamsSchema", func() {
+	var schema sqs.ParamsSchema
+
+	BeforeEach(func() {
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeFIFO}.InstanceSchema()
+	})
+
+	It("should describe every queue parameter", func() {
+		Expect(schema.Properties).To(HaveLen(20))
+		Expect(*schema.Properties["delay_seconds"]).To(Equal(sqs.PropertySchema{
+			Type:    "integer",
+			Minimum: aws.Int(0),
+			Maximum: aws.Int(900),
+		}))
+		Expect(*schema.Properties["encryption"]).To(Equal(sqs.PropertySchema{
+			Type: "string",
+			Enum: []string{"sqs", "kms"},
+		}))
+		Expect(*schema.Properties["content_based_deduplication"]).To(Equal(sqs.PropertySchema{
+			Type: "boolean",
+			FIFO: true,
+		}))
+		Expect(schema.Properties["queues"].Type).To(Equal("array"))
+		Expect(*schema.Properties["queues"].MaxItems).To(Equal(9))
+		Expect(schema.Properties["queues"].Items.Required).To(ConsistOf("name"))
+		Expect(schema.Properties["queues"].Items.Properties).To(HaveKey("redrive_max_receive_count"))
+		Expect(*schema.Properties["tags"]).To(Equal(sqs.PropertySchema{
+			Type:          "object",
+			Values:        &sqs.PropertySchema{Type: "string"},
+			MaxProperties: aws.Int(20),
+		}))
+	})
+
+	It("should leave out FIFO parameters for standard plans", func() {
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard}.InstanceSchema()
+		Expect(schema.Properties).ToNot(HaveKey("content_based_deduplication"))
+		Expect(schema.Properties).ToNot(HaveKey("deduplication_scope"))
+		Expect(schema.Properties).ToNot(HaveKey("fifo_throughput_limit"))
+	})
+
+	It("should leave out dead letter queue parameters for plans without one", func() {
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard, DeadLetterQueue: aws.Bool(false)}.InstanceSchema()
+		Expect(schema.Properties).To(HaveKey("message_retention_period"))
+		Expect(schema.Properties).ToNot(HaveKey("dlq_message_retention_period"))
+		Expect(schema.Properties).ToNot(HaveKey("dlq_redrive_permission"))
+		Expect(schema.Properties["queues"].Items.Properties).ToNot(HaveKey("redrive_max_receive_count"))
+		Expect(schema.Properties["alarms"].Properties.Properties).ToNot(HaveKey("dlq_depth"))
+	})
+
+	It("should only offer subscription parameters on plans with a topic", func() {
+		Expect(schema.Properties).ToNot(HaveKey("filter_policy"))
+		Expect(schema.Properties["queues"].Items.Properties).ToNot(HaveKey("raw_message_delivery"))
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard, Topic: true}.InstanceSchema()
+		Expect(*schema.Properties["filter_policy"]).To(Equal(sqs.PropertySchema{
+			Type:  "object",
+			Topic: true,
+		}))
+		Expect(schema.Properties).To(HaveKey("raw_message_delivery"))
+		Expect(schema.Properties["queues"].Items.Properties).To(HaveKey("filter_policy"))
+		Expect(schema.Validate(json.RawMessage(`{"filter_policy": {"event": ["a"]}}`))).To(Succeed())
+		Expect(schema.Validate(json.RawMessage(`{"filter_policy": "event"}`))).To(MatchError("filter_policy: must be an object"))
+		Expect(schema.Validate(json.RawMessage(`{"queues": [{"name": "a", "filter_policy": []}]}`))).To(MatchError("queues[0].filter_policy: must be an object"))
+	})
+
+	It("should move subscription parameters to bindings on broadcast plans", func() {
+		plan := sqs.PlanConfig{QueueType: sqs.QueueTypeStandard, Topic: true, Broadcast: true}
+		schema = plan.InstanceSchema()
+		Expect(schema.Properties).To(HaveKey("visibility_timeout"))
+		Expect(schema.Properties).ToNot(HaveKey("queues"))
+		Expect(schema.Properties).ToNot(HaveKey("allowed_senders"))
+		Expect(schema.Properties).ToNot(HaveKey("alarms"))
+		Expect(schema.Properties).ToNot(HaveKey("filter_policy"))
+		Expect(schema.Properties).ToNot(HaveKey("dlq_message_retention_period"))
+		schema = plan.BindingSchema()
+		Expect(schema.Properties).To(HaveKey("access_policy"))
+		Expect(schema.Properties).To(HaveKey("filter_policy"))
+		Expect(schema.Properties).To(HaveKey("raw_message_delivery"))
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard, Topic: true}.BindingSchema()
+		Expect(schema.Properties).ToNot(HaveKey("filter_policy"))
+	})
+
+	It("should only offer redrive of the dead letter queue on update", func() {
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard}.UpdateSchema()
+		Expect(schema.Properties).To(HaveKey("redrive_dlq"))
+		Expect(*schema.Properties["redrive_dlq_max_messages_per_second"]).To(Equal(sqs.PropertySchema{
+			Type:    "integer",
+			Minimum: aws.Int(1),
+			Maximum: aws.Int(500),
+			DLQ:     true,
+		}))
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard, DeadLetterQueue: aws.Bool(false)}.UpdateSchema()
+		Expect(schema.Properties).ToNot(HaveKey("redrive_dlq"))
+	})
+
+	It("should apply plan maximums", func() {
+		schema = sqs.PlanConfig{
+			QueueType: sqs.QueueTypeStandard,
+			Maximums:  sqs.QueueParams{DelaySeconds: aws.Int(60), VisibilityTimeout: aws.Int(86400)},
+		}.InstanceSchema()
+		Expect(*schema.Properties["delay_seconds"].Maximum).To(Equal(60))
+		Expect(*schema.Properties["visibility_timeout"].Maximum).To(Equal(43200))
+		Expect(*schema.Properties["queues"].Items.Properties["delay_seconds"].Maximum).To(Equal(60))
+	})
+
+	It("should restrict binding access policies to those allowed by the plan", func() {
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard}.BindingSchema()
+		Expect(schema.Properties).To(HaveLen(2))
+		Expect(schema.Properties["access_policy"].Enum).To(ConsistOf("full", "producer", "consumer", "monitor"))
+		schema = sqs.PlanConfig{
+			QueueType:      sqs.QueueTypeStandard,
+			AccessPolicies: []sqs.AccessPolicy{sqs.AccessPolicyConsumer},
+		}.BindingSchema()
+		Expect(schema.Properties["access_policy"].Enum).To(ConsistOf("consumer"))
+	})
+
+	It("should restrict binding types to those allowed by the plan", func() {
+		schema = sqs.PlanConfig{QueueType: sqs.QueueTypeStandard}.BindingSchema()
+		Expect(schema.Properties["binding_type"].Enum).To(ConsistOf("user", "role"))
+		schema = sqs.PlanConfig{
+			QueueType:    sqs.QueueTypeStandard,
+			BindingTypes: []sqs.BindingType{sqs.BindingTypeRole},
+		}.BindingSchema()
+		Expect(schema.Properties["binding_type"].Enum).To(ConsistOf("role"))
+		Expect(schema.Validate([]byte(`{"binding_type": "user"}`))).To(MatchError("binding_type: must be one of role"))
+	})
+
+	It("should render as a JSON schema", func() {
+		rendered, err := json.Marshal(schema.JSONSchema())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rendered).To(MatchJSON(`{
+			"$schema": "http://json-schema.org/draft-04/schema#",
+			"type": "object",
+			"additionalProperties": false,
+			"properties": {
+				"alarms": {
+					"type": "object",
+					"additionalProperties": false,
+					"properties": {
+						"dlq_depth": {"type": "boolean"},
+						"oldest_message_age": {"type": "integer", "minimum": 60, "maximum": 1209600},
+						"queue_depth": {"type": "integer", "minimum": 1, "maximum": 1000000000},
+						"endpoints": {
+							"type": "array",
+							"maxItems": 10,
+							"items": {
+								"type": "object",
+								"additionalProperties": false,
+								"required": ["protocol", "endpoint"],
+								"properties": {
+									"protocol": {"type": "string", "enum": ["email", "https"]},
+									"endpoint": {"type": "string"}
+								}
+							}
+						}
+					}
+				},
+				"allowed_senders": {
+					"type": "array",
+					"maxItems": 20,
+					"items": {
+						"type": "object",
+						"additionalProperties": false,
+						"properties": {
+							"account": {"type": "string"},
+							"service": {"type": "string", "enum": ["events.amazonaws.com", "s3.amazonaws.com", "sns.amazonaws.com"]},
+							"source_arn": {"type": "string"}
+						}
+					}
+				},
+				"content_based_deduplication": {"type": "boolean"},
+				"deduplication_scope": {"type": "string", "enum": ["queue", "messageGroup"]},
+				"delay_seconds": {"type": "integer", "minimum": 0, "maximum": 900},
+				"dlq_delay_seconds": {"type": "integer", "minimum": 0, "maximum": 900},
+				"dlq_maximum_message_size": {"type": "integer", "minimum": 1024, "maximum": 262144},
+				"dlq_message_retention_period": {"type": "integer", "minimum": 60, "maximum": 1209600},
+				"dlq_redrive_permission": {"type": "string", "enum": ["allowAll", "byQueue"]},
+				"dlq_visibility_timeout": {"type": "integer", "minimum": 0, "maximum": 43200},
+				"encryption": {"type": "string", "enum": ["sqs", "kms"]},
+				"fifo_throughput_limit": {"type": "string", "enum": ["perQueue", "perMessageGroupId"]},
+				"kms_key_arn": {"type": "string"},
+				"maximum_message_size": {"type": "integer", "minimum": 1024, "maximum": 262144},
+				"message_retention_period": {"type": "integer", "minimum": 60, "maximum": 1209600},
+				"queues": {
+					"type": "array",
+					"maxItems": 9,
+					"items": {
+						"type": "object",
+						"additionalProperties": false,
+						"required": ["name"],
+						"properties": {
+							"name": {"type": "string"},
+							"delay_seconds": {"type": "integer", "minimum": 0, "maximum": 900},
+							"maximum_message_size": {"type": "integer", "minimum": 1024, "maximum": 262144},
+							"message_retention_period": {"type": "integer", "minimum": 60, "maximum": 1209600},
+							"receive_message_wait_time_seconds": {"type": "integer", "minimum": 0, "maximum": 20},
+							"redrive_max_receive_count": {"type": "integer", "minimum": 0, "maximum": 1000},
+							"visibility_timeout": {"type": "integer", "minimum": 0, "maximum": 43200}
+						}
+					}
+				},
+				"receive_message_wait_time_seconds": {"type": "integer", "minimum": 0, "maximum": 20},
+				"redrive_max_receive_count": {"type": "integer", "minimum": 0, "maximum": 1000},
+				"tags": {"type": "object", "additionalProperties": {"type": "string"}, "maxProperties": 20},
+				"visibility_timeout": {"type": "integer", "minimum": 0, "maximum": 43200}
+			}
+		}`))
+	})
+
+	DescribeTable("validating parameters",
+		func(params string, expectedErr string) {
+			err := schema.Validate(json.RawMessage(params))
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(expectedErr))
+			castErrResponse, ok := err.(*brokerapi.FailureResponse)
+			Expect(ok).To(BeTrue())
+			Expect(castErrResponse.ValidatedStatusCode(nil)).To(Equal(400))
+		},
+		Entry("empty", `{}`, ""),
+		Entry("valid", `{"delay_seconds": 900, "encryption": "kms", "content_based_deduplication": true}`, ""),
+		Entry("unknown field", `{"mango": 1}`, "mango: unknown parameter"),
+		Entry("below minimum", `{"maximum_message_size": 1023}`, "maximum_message_size: must be at least 1024"),
+		Entry("above maximum", `{"receive_message_wait_time_seconds": 21}`, "receive_message_wait_time_seconds: must be at most 20"),
+		Entry("not an integer", `{"delay_seconds": 1.5}`, "delay_seconds: must be an integer"),
+		Entry("string for integer", `{"delay_seconds": "5"}`, "delay_seconds: must be an integer"),
+		Entry("not a boolean", `{"content_based_deduplication": "yes"}`, "content_based_deduplication: must be a boolean"),
+		Entry("not in enum", `{"encryption": "rot13"}`, "encryption: must be one of sqs, kms"),
+		Entry("not an object", `[]`, "parameters must be a JSON object"),
+		Entry("named queues", `{"queues": [{"name": "jobs", "delay_seconds": 5}]}`, ""),
+		Entry("named queue without a name", `{"queues": [{"delay_seconds": 5}]}`, "queues[0].name: required"),
+		Entry("named queue above maximum", `{"queues": [{"name": "jobs"}, {"name": "mail", "delay_seconds": 901}]}`, "queues[1].delay_seconds: must be at most 900"),
+		Entry("named queue unknown field", `{"queues": [{"name": "jobs", "mango": 1}]}`, "queues[0].mango: unknown parameter"),
+		Entry("named queues not an array", `{"queues": {"name": "jobs"}}`, "queues: must be an array of objects"),
+		Entry("allowed senders", `{"allowed_senders": [{"service": "s3.amazonaws.com", "source_arn": "arn:aws:s3:::bucket"}, {"account": "123456789012"}]}`, ""),
+		Entry("allowed sender unknown service", `{"allowed_senders": [{"service": "lambda.amazonaws.com"}]}`, "allowed_senders[0].service: must be one of events.amazonaws.com, s3.amazonaws.com, sns.amazonaws.com"),
+		Entry("alarms", `{"alarms": {"queue_depth": 100, "endpoints": [{"protocol": "email", "endpoint": "team@example.com"}]}}`, ""),
+		Entry("alarms not an object", `{"alarms": []}`, "alarms: must be an object"),
+		Entry("alarm below minimum", `{"alarms": {"oldest_message_age": 59}}`, "alarms.oldest_message_age: must be at least 60"),
+		Entry("alarm endpoint unknown protocol", `{"alarms": {"endpoints": [{"protocol": "sms", "endpoint": "07700900000"}]}}`, "alarms.endpoints[0].protocol: must be one of email, https"),
+		Entry("tags", `{"tags": {"team": "payments", "cost-centre": "1234"}}`, ""),
+		Entry("tags not an object", `{"tags": ["team"]}`, "tags: must be an object"),
+		Entry("tag value not a string", `{"tags": {"team": 1}}`, "tags.team: must be a string"),
+		Entry("too many tags", `{"tags": {"a": "", "b": "", "c": "", "d": "", "e": "", "f": "", "g": "", "h": "", "i": "", "j": "", "k": "", "l": "", "m": "", "n": "", "o": "", "p": "", "q": "", "r": "", "s": "", "t": "", "u": ""}}`, "tags: must have at most 20 properties"),
+		Entry("too many named queues", `{"queues": [{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}, {"name": "e"}, {"name": "f"}, {"name": "g"}, {"name": "h"}, {"name": "i"}, {"name": "j"}]}`, "queues: must have at most 9 items"),
+	)
+})
+
+var _ = Describe("Provider schemas", func() {
+	It("should publish schemas for the plan", func() {
+		provider := &sqs.Provider{}
+		schemas := provider.Schemas(domain.ServicePlan{ID: "uuid-3", Name: "fifo"})
+		Expect(schemas.Instance.Create.Parameters).To(HaveKeyWithValue("properties", HaveKey("fifo_throughput_limit")))
+		Expect(schemas.Instance.Update.Parameters).To(HaveKeyWithValue("properties", HaveKey("fifo_throughput_limit")))
+		Expect(schemas.Instance.Create.Parameters).To(HaveKeyWithValue("properties", Not(HaveKey("redrive_dlq"))))
+		Expect(schemas.Instance.Update.Parameters).To(HaveKeyWithValue("properties", HaveKey("redrive_dlq")))
+		Expect(schemas.Binding.Create.Parameters).To(HaveKeyWithValue("properties", HaveKey("access_policy")))
+	})
+
+	It("should offer the configured access policies to bindings", func() {
+		provider := &sqs.Provider{
+			AccessPolicies: map[string]sqs.AccessPolicyConfig{
+				"reader": {Actions: []string{"sqs:ReceiveMessage"}},
+				"writer": {Actions: []string{"sqs:SendMessage"}},
+			},
+		}
+		schemas := provider.Schemas(domain.ServicePlan{ID: "uuid-2", Name: "standard"})
+		Expect(schemas.Binding.Create.Parameters).To(HaveKeyWithValue("properties",
+			HaveKeyWithValue("access_policy", HaveKeyWithValue("enum", ConsistOf("reader", "writer")))))
+	})
+})
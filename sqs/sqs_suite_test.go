@@ -1,8 +1,14 @@
 package sqs_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	goformationsqs "github.com/awslabs/goformation/v4/cloudformation/sqs"
+	"github.com/awslabs/goformation/v4/intrinsics"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +17,116 @@ func TestSQS(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "SQS Suite")
 }
+
+// parsedTemplate is a CloudFormation template with conditions evaluated and
+// intrinsic functions resolved. Resources whose condition is false are
+// removed. Fn::GetAtt resolves to "<LogicalID>.<Attribute>".
+type parsedTemplate struct {
+	Resources map[string]parsedResource
+	Outputs   map[string]interface{}
+}
+
+type parsedResource struct {
+	Type       string
+	Properties map[string]interface{}
+}
+
+// lenientQueue drops the strict UnmarshalJSON of goformation's Queue, which
+// rejects properties newer than the vendored CloudFormation spec.
+type lenientQueue goformationsqs.Queue
+
+// Queue decodes the named resource into a goformation Queue.
+func (t parsedTemplate) Queue(name string) *goformationsqs.Queue {
+	resource, ok := t.Resources[name]
+	Expect(ok).To(BeTrue(), "missing resource %s", name)
+	Expect(resource.Type).To(Equal("AWS::SQS::Queue"))
+	data, err := json.Marshal(resource.Properties)
+	Expect(err).ToNot(HaveOccurred())
+	var queue lenientQueue
+	Expect(json.Unmarshal(data, &queue)).To(Succeed())
+	return (*goformationsqs.Queue)(&queue)
+}
+
+// stackParams converts stack parameters to the form parseTemplate takes.
+func stackParams(params []*cloudformation.Parameter) map[string]string {
+	values := map[string]string{}
+	for _, p := range params {
+		if p.ParameterValue != nil {
+			values[*p.ParameterKey] = *p.ParameterValue
+		}
+	}
+	return values
+}
+
+// parseTemplate renders a template body the way CloudFormation would for
+// the given parameter values, using parameter defaults for the rest.
+func parseTemplate(body string, params map[string]string) parsedTemplate {
+	data, err := intrinsics.ProcessYAML([]byte(body), &intrinsics.ProcessorOptions{NoProcess: true})
+	Expect(err).ToNot(HaveOccurred())
+	var raw map[string]interface{}
+	Expect(json.Unmarshal(data, &raw)).To(Succeed())
+
+	overrides := map[string]interface{}{}
+	declared, _ := raw["Parameters"].(map[string]interface{})
+	for name, value := range params {
+		Expect(declared).To(HaveKey(name), "undeclared parameter %s", name)
+		if declared[name].(map[string]interface{})["Type"] == "Number" {
+			number, err := strconv.ParseFloat(value, 64)
+			Expect(err).ToNot(HaveOccurred())
+			overrides[name] = number
+		} else {
+			overrides[name] = value
+		}
+	}
+
+	// Conditions are evaluated on their own as goformation would otherwise
+	// also replace resources and outputs that carry a Condition key.
+	head, err := json.Marshal(map[string]interface{}{
+		"Parameters": raw["Parameters"],
+		"Conditions": raw["Conditions"],
+	})
+	Expect(err).ToNot(HaveOccurred())
+	head, err = intrinsics.ProcessJSON(head, &intrinsics.ProcessorOptions{
+		ParameterOverrides: overrides,
+		EvaluateConditions: true,
+	})
+	Expect(err).ToNot(HaveOccurred())
+	var evaluated struct {
+		Parameters map[string]interface{}
+		Conditions map[string]bool
+	}
+	Expect(json.Unmarshal(head, &evaluated)).To(Succeed())
+	raw["Parameters"] = evaluated.Parameters
+	raw["Conditions"] = evaluated.Conditions
+
+	for _, section := range []string{"Resources", "Outputs"} {
+		items, _ := raw[section].(map[string]interface{})
+		for name, item := range items {
+			item := item.(map[string]interface{})
+			if condition, ok := item["Condition"].(string); ok {
+				delete(item, "Condition")
+				if !evaluated.Conditions[condition] {
+					delete(items, name)
+				}
+			}
+		}
+	}
+
+	data, err = json.Marshal(raw)
+	Expect(err).ToNot(HaveOccurred())
+	data, err = intrinsics.ProcessJSON(data, &intrinsics.ProcessorOptions{
+		IntrinsicHandlerOverrides: map[string]intrinsics.IntrinsicHandler{
+			"Fn::GetAtt": func(name string, input interface{}, template interface{}) interface{} {
+				if args, ok := input.([]interface{}); ok && len(args) == 2 {
+					return fmt.Sprintf("%v.%v", args[0], args[1])
+				}
+				return nil
+			},
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	var t parsedTemplate
+	Expect(json.Unmarshal(data, &t)).To(Succeed())
+	return t
+}
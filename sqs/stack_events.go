@@ -0,0 +1,105 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pivotal-cf/brokerapi/domain"
+)
+
+// awsRequestDetails matches the request metadata AWS appends to error
+// messages, e.g. "(Service: AmazonSQS; Status Code: 400; Error Code:
+// InvalidAttributeValue; Request ID: ...; Proxy: null)".
+var awsRequestDetails = regexp.MustCompile(`\s*\(Service: [^)]*\)`)
+
+// failedOperation describes a stack operation that has reached a failed
+// status. The reason the first resource failed is taken from the stack
+// events, as the stack status alone does not tell tenants what went wrong.
+func (s *Provider) failedOperation(ctx context.Context, stack *cloudformation.Stack, status string) *domain.LastOperation {
+	description := fmt.Sprintf("failed: %s", status)
+	if reason := s.failureReason(ctx, stack); reason != "" {
+		description = fmt.Sprintf("%s: %s", description, reason)
+	}
+	switch status {
+	case cloudformation.StackStatusUpdateRollbackComplete:
+		// the update has been rolled back, so the queues are still usable
+		// with their previous settings and the update can be retried
+		description = fmt.Sprintf("%s (the service is unchanged and still usable, the update can be retried)", description)
+		recordOperationRecovery(ctx, true, true)
+	case cloudformation.StackStatusUpdateRollbackFailed:
+		// the stack is stuck part way through rolling back
+		recordOperationRecovery(ctx, false, false)
+	}
+	return &domain.LastOperation{
+		State:       domain.Failed,
+		Description: description,
+	}
+}
+
+// failureReason returns the reason the first resource failed during the
+// stack's most recent operation, or an empty string if it can't be found.
+func (s *Provider) failureReason(ctx context.Context, stack *cloudformation.Stack) string {
+	stackName := aws.StringValue(stack.StackId)
+	if stackName == "" {
+		stackName = aws.StringValue(stack.StackName)
+	}
+	out, err := s.Client.DescribeStackEventsWithContext(ctx, &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		if s.Logger != nil {
+			s.Logger.Error("describe-stack-events", err, lager.Data{"stack": stackName})
+		}
+		return ""
+	}
+	if out == nil {
+		return ""
+	}
+
+	// events are returned newest first, so the last failure seen before
+	// the start of the operation is the one that caused the others
+	reason := ""
+	for _, event := range out.StackEvents {
+		status := aws.StringValue(event.ResourceStatus)
+		if isOperationStart(stack, event) {
+			break
+		}
+		if strings.HasSuffix(status, "_FAILED") && aws.StringValue(event.ResourceStatusReason) != "" {
+			if r := cleanFailureReason(aws.StringValue(event.ResourceStatusReason)); !isCascadingFailure(r) {
+				reason = fmt.Sprintf("%s: %s", aws.StringValue(event.LogicalResourceId), r)
+			}
+		}
+	}
+	return reason
+}
+
+// isOperationStart returns true for the event that started the stack's
+// most recent create, update or delete.
+func isOperationStart(stack *cloudformation.Stack, event *cloudformation.StackEvent) bool {
+	if aws.StringValue(event.ResourceType) != "AWS::CloudFormation::Stack" {
+		return false
+	}
+	switch aws.StringValue(event.ResourceStatus) {
+	case cloudformation.ResourceStatusCreateInProgress, cloudformation.ResourceStatusUpdateInProgress, cloudformation.ResourceStatusDeleteInProgress:
+		return aws.StringValue(event.ResourceStatusReason) == "User Initiated"
+	}
+	return false
+}
+
+// isCascadingFailure returns true for reasons that only say a resource
+// failed because another one did.
+func isCascadingFailure(reason string) bool {
+	return reason == "Resource creation cancelled" ||
+		reason == "Resource update cancelled" ||
+		strings.HasPrefix(reason, "The following resource(s) failed to")
+}
+
+// cleanFailureReason strips AWS request metadata from a failure reason.
+func cleanFailureReason(reason string) string {
+	return strings.TrimSpace(awsRequestDetails.ReplaceAllString(reason, ""))
+}
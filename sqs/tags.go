@@ -0,0 +1,154 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const (
+	ParamTags = "Tags"
+)
+
+// MaxOperatorTags is the most tags an operator can configure. Along with
+// the broker's own tags and up to 20 from the tenant, they must fit in
+// the 50 tags CloudFormation allows on a stack.
+const MaxOperatorTags = 20
+
+// MaxStackParameterSize is the longest value CloudFormation accepts for
+// a stack parameter, in bytes.
+const MaxStackParameterSize = 4096
+
+var (
+	tagKey   = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]{1,128}$`)
+	tagValue = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]{0,256}$`)
+)
+
+// brokerTags are the tags the broker sets on stacks and resources itself,
+// which operators and tenants cannot override.
+var brokerTags = []string{
+	TagCostAllocation,
+	TagEnvironment,
+	TagName,
+	TagService,
+	TagServiceId,
+	TagPlanId,
+	TagInstanceId,
+	TagAccessPolicy,
+	TagBindingType,
+	TagBindingParameters,
+	TagOrganizationGUID,
+	TagOrganizationName,
+	TagSpaceGUID,
+	TagSpaceName,
+	TagNamespace,
+	TagClusterID,
+	TagAppGUID,
+}
+
+// validateTag checks that a tag is acceptable to AWS and does not clash
+// with the broker's own tags.
+func validateTag(key, value string) error {
+	switch {
+	case !tagKey.MatchString(key):
+		return fmt.Errorf("key must be 1 to 128 letters, numbers, spaces or _.:/=+-@")
+	case strings.HasPrefix(strings.ToLower(key), "aws:"):
+		return fmt.Errorf("is reserved for use by AWS")
+	case contains(brokerTags, key):
+		return fmt.Errorf("is set by the broker")
+	case !tagValue.MatchString(value):
+		return fmt.Errorf("value must be at most 256 letters, numbers, spaces or _.:/=+-@")
+	}
+	return nil
+}
+
+// validateTags checks the tenant's tags, which cannot override the
+// operator's.
+func (s *Provider) validateTags(tags map[string]string) error {
+	for _, key := range sortedKeys(tags) {
+		if err := validateTag(key, tags[key]); err != nil {
+			return invalidParams(fmt.Errorf("tags.%s: %s", key, err))
+		}
+		if _, ok := s.Tags[key]; ok {
+			return invalidParams(fmt.Errorf("tags.%s: is set by the operator", key))
+		}
+	}
+	// the tags are kept in a single stack parameter for updates
+	if size := len(encodeTags(tags)); size > MaxStackParameterSize {
+		return invalidParams(fmt.Errorf("tags: must be at most %d bytes altogether when encoded as JSON, not %d", MaxStackParameterSize, size))
+	}
+	return nil
+}
+
+// resourceTags returns the tags the broker puts on each resource it
+// creates.
+func (s *Provider) resourceTags(name, serviceID, instanceID string) map[string]string {
+	return map[string]string{
+		TagName:           name,
+		TagService:        "sqs",
+		TagServiceId:      serviceID,
+		TagEnvironment:    s.Environment,
+		TagCostAllocation: instanceID,
+	}
+}
+
+// propagatedTags returns every tag for a stack, which CloudFormation
+// copies to each resource in the stack that supports tags. The identity
+// tags the broker uses to recognise the stack take precedence over the
+// resource tags, which take precedence over the tenant's and then the
+// operator's tags.
+func (s *Provider) propagatedTags(identity []*cloudformation.Tag, resource, tenant map[string]string) []*cloudformation.Tag {
+	merged := mergeTags(s.Tags, tenant, resource)
+	for _, tag := range identity {
+		merged[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	tags := []*cloudformation.Tag{}
+	for _, key := range sortedKeys(merged) {
+		tags = append(tags, &cloudformation.Tag{Key: aws.String(key), Value: aws.String(merged[key])})
+	}
+	return tags
+}
+
+// mergeTags combines sets of tags, with later sets taking precedence.
+func mergeTags(sets ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, tags := range sets {
+		for key, value := range tags {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeTags returns the value of the Tags stack parameter.
+func encodeTags(tags map[string]string) string {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	data, _ := json.Marshal(tags) // map[string]string always marshals
+	return string(data)
+}
+
+// decodeTags parses the value of the Tags stack parameter. Stacks from
+// before tenants could set tags have none.
+func decodeTags(value string) map[string]string {
+	tags := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &tags); err != nil || len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
@@ -6,6 +6,20 @@ package sqs
 const queueTemplateFormat = `
 AWSTemplateFormatVersion: 2010-09-09
 Parameters:
+  Alarms:
+    Default: ""
+    Description: |
+      The CloudWatch alarms on the queues, as JSON. The alarms are
+      rendered from this value by the broker, it is kept here so that
+      updates can reuse it.
+    Type: String
+  AllowedSenders:
+    Default: "[]"
+    Description: |
+      The AWS services and accounts allowed to send to the primary
+      queue, as JSON. The queue policy is rendered from this value by
+      the broker, it is kept here so that updates can reuse it.
+    Type: String
   DelaySeconds:
     Default: 0
     Description: |
@@ -14,6 +28,70 @@ Parameters:
       900 (15 minutes).
     MaxValue: 900
     Type: Number
+  DLQDelaySeconds:
+    Default: 0
+    Description: |
+      The time in seconds for which the delivery of all messages in
+      the secondary queue is delayed.
+    MaxValue: 900
+    Type: Number
+  DLQMaximumMessageSize:
+    Default: 262144
+    Description: |
+      The limit of how many bytes that a message in the secondary
+      queue can contain.
+    MaxValue: 262144
+    MinValue: 1024
+    Type: Number
+  DLQMessageRetentionPeriod:
+    Default: -1
+    Description: |
+      The number of seconds that the secondary queue retains a
+      message. A value of -1 uses the MessageRetentionPeriod of the
+      primary queue.
+    MaxValue: 1209600
+    MinValue: -1
+    Type: Number
+  DLQRedrivePermission:
+    AllowedValues:
+    - allowAll
+    - byQueue
+    Default: allowAll
+    Description: |
+      Which queues may use the secondary queue as a dead-letter queue.
+      Use "byQueue" to only allow the primary queue.
+    Type: String
+  DLQVisibilityTimeout:
+    Default: -1
+    Description: |
+      The visibility timeout of the secondary queue. A value of -1
+      uses the VisibilityTimeout of the primary queue.
+    MaxValue: 43200
+    MinValue: -1
+    Type: Number
+  Encryption:
+    AllowedValues:
+    - sqs
+    - kms
+    Default: sqs
+    Description: |
+      The server-side encryption used for the queues. Use "sqs" for
+      SQS-managed encryption keys (SSE-SQS) or "kms" for a
+      customer-managed AWS KMS key (SSE-KMS).
+    Type: String
+  FilterPolicy:
+    Default: ""
+    Description: |
+      The filter policy of the primary queue's subscription to the
+      topic, as JSON. The subscription is rendered from this value by
+      the broker, it is kept here so that updates can reuse it.
+    Type: String
+  KmsKeyArn:
+    Default: ""
+    Description: |
+      The ARN of an existing KMS key to use when Encryption is "kms".
+      If empty, a dedicated key with automatic rotation is created.
+    Type: String
   MaximumMessageSize:
     Default: 262144
     Description: |
@@ -34,6 +112,22 @@ Parameters:
     MaxValue: 1209600
     MinValue: 60
     Type: Number
+  Queues:
+    Default: "[]"
+    Description: |
+      The named queues in the stack, as JSON. The queue resources are
+      rendered from this value by the broker, it is kept here so that
+      updates can reuse it.
+    Type: String
+  RawMessageDelivery:
+    AllowedValues:
+    - "true"
+    - "false"
+    Default: "false"
+    Description: |
+      Whether messages published to the topic are delivered to the
+      primary queue without the SNS JSON envelope.
+    Type: String
   ReceiveMessageWaitTimeSeconds:
     Default: 0
     Description: |
@@ -51,7 +145,14 @@ Parameters:
       The number of times a message is delivered to the source queue
       before being moved to the dead-letter queue.  A value of 0
       disables the dead-letter queue.
+    MaxValue: 1000
     Type: Number
+  Tags:
+    Default: "{}"
+    Description: |
+      The tenant's tags for the stack, as JSON. The stack is tagged by
+      the broker, they are kept here so that updates can reuse them.
+    Type: String
   VisibilityTimeout:
     Default: 30
     Description: |
@@ -64,17 +165,116 @@ Parameters:
       of 30 seconds.
     MaxValue: 43200
     Type: Number
+{{ if .FIFOQueue }}
+  ContentBasedDeduplication:
+    AllowedValues:
+    - "true"
+    - "false"
+    Default: "false"
+    Description: |
+      Whether SQS uses a SHA-256 hash of the message body to generate
+      the deduplication ID when the sender does not provide one.
+    Type: String
+  DeduplicationScope:
+    AllowedValues:
+    - queue
+    - messageGroup
+    Default: queue
+    Description: |
+      Whether message deduplication occurs at the message group or
+      queue level.
+    Type: String
+  FifoThroughputLimit:
+    AllowedValues:
+    - perQueue
+    - perMessageGroupId
+    Default: perQueue
+    Description: |
+      Whether the FIFO queue throughput quota applies to the entire
+      queue or per message group. High throughput mode requires
+      perMessageGroupId with a DeduplicationScope of messageGroup.
+    Type: String
+{{ end }}
 Conditions:
   ShouldNotUseDLQ:
     Fn::Equals:
     - !Ref RedriveMaxReceiveCount
     - 0
+  ShouldInheritDLQMessageRetentionPeriod:
+    Fn::Equals:
+    - !Ref DLQMessageRetentionPeriod
+    - -1
+  ShouldInheritDLQVisibilityTimeout:
+    Fn::Equals:
+    - !Ref DLQVisibilityTimeout
+    - -1
+  ShouldRestrictDLQRedrive:
+    Fn::Equals:
+    - !Ref DLQRedrivePermission
+    - byQueue
+  ShouldUseRawMessageDelivery:
+    Fn::Equals:
+    - !Ref RawMessageDelivery
+    - "true"
+  ShouldUseKMS:
+    Fn::Equals:
+    - !Ref Encryption
+    - kms
+  ShouldCreateKMSKey:
+    Fn::And:
+    - Condition: ShouldUseKMS
+    - Fn::Equals:
+      - !Ref KmsKeyArn
+      - ""
+{{ if .FIFOQueue }}
+  ShouldUseContentBasedDeduplication:
+    Fn::Equals:
+    - !Ref ContentBasedDeduplication
+    - "true"
+{{ end }}
 Resources:
+  QueueKey:
+    Condition: ShouldCreateKMSKey
+    Properties:
+      Description: Encryption key for {{ if .Broadcast }}{{.TopicName}} and its binding queues{{ else }}{{.PrimaryQueueName}}{{ if not .SingleQueue }} and {{.SecondaryQueueName}}{{ end }}{{ end }}
+      EnableKeyRotation: true
+      KeyPolicy:
+        Statement:
+        - Action: kms:*
+          Effect: Allow
+          Principal:
+            AWS: !Sub "arn:${AWS::Partition}:iam::${AWS::AccountId}:root"
+          Resource: "*"
+{{ if .Topic }}
+        - Action:
+          - kms:Decrypt
+          - kms:GenerateDataKey
+          Effect: Allow
+          Principal:
+            Service: sns.amazonaws.com
+          Resource: "*"
+{{ end }}
+        Version: 2012-10-17
+{{ if .Tags }}
+      Tags:
+{{ range $key, $value := .Tags }}
+      - Key: {{ $key }}
+        Value: {{ $value }}
+{{ end }}
+{{ end }}
+    Type: AWS::KMS::Key
+{{ if not .Broadcast }}
   PrimaryQueue:
     Properties:
       QueueName: {{.PrimaryQueueName}}
 {{ if .FIFOQueue }}
       FifoQueue: {{.FIFOQueue}}
+      ContentBasedDeduplication: !If
+        - ShouldUseContentBasedDeduplication
+        - true
+        - false
+      DeduplicationScope: !Ref DeduplicationScope
+      FifoThroughputLimit: !Ref FifoThroughputLimit
 {{ end }}
       Tags:
       - Key: QueueType
@@ -84,9 +284,19 @@ Resources:
         Value: {{ $value }}
 {{ end }}
       DelaySeconds: !Ref DelaySeconds
+      KmsMasterKeyId: !If
+        - ShouldUseKMS
+        - !If
+          - ShouldCreateKMSKey
+          - Fn::GetAtt:
+            - QueueKey
+            - Arn
+          - !Ref KmsKeyArn
+        - !Ref "AWS::NoValue"
       MaximumMessageSize: !Ref MaximumMessageSize
       MessageRetentionPeriod: !Ref MessageRetentionPeriod
       ReceiveMessageWaitTimeSeconds: !Ref ReceiveMessageWaitTimeSeconds
+{{ if not .SingleQueue }}
       RedrivePolicy: !If
         - ShouldNotUseDLQ
         - !Ref "AWS::NoValue"
@@ -95,13 +305,26 @@ Resources:
             - SecondaryQueue
             - Arn
           maxReceiveCount: !Ref RedriveMaxReceiveCount
+{{ end }}
+      SqsManagedSseEnabled: !If
+        - ShouldUseKMS
+        - false
+        - true
       VisibilityTimeout: !Ref VisibilityTimeout
     Type: AWS::SQS::Queue
+{{ end }}
+{{ if not .SingleQueue }}
   SecondaryQueue:
     Properties:
       QueueName: {{.SecondaryQueueName}}
 {{ if .FIFOQueue }}
       FifoQueue: {{.FIFOQueue}}
+      ContentBasedDeduplication: !If
+        - ShouldUseContentBasedDeduplication
+        - true
+        - false
+      DeduplicationScope: !Ref DeduplicationScope
+      FifoThroughputLimit: !Ref FifoThroughputLimit
 {{ end }}
       Tags:
       - Key: QueueType
@@ -110,10 +333,403 @@ Resources:
       - Key: {{ $key }}
         Value: {{ $value }}
 {{ end }}
-      MessageRetentionPeriod: !Ref MessageRetentionPeriod
-      VisibilityTimeout: !Ref VisibilityTimeout
+      DelaySeconds: !Ref DLQDelaySeconds
+      KmsMasterKeyId: !If
+        - ShouldUseKMS
+        - !If
+          - ShouldCreateKMSKey
+          - Fn::GetAtt:
+            - QueueKey
+            - Arn
+          - !Ref KmsKeyArn
+        - !Ref "AWS::NoValue"
+      MaximumMessageSize: !Ref DLQMaximumMessageSize
+      MessageRetentionPeriod: !If
+        - ShouldInheritDLQMessageRetentionPeriod
+        - !Ref MessageRetentionPeriod
+        - !Ref DLQMessageRetentionPeriod
+      RedriveAllowPolicy: !If
+        - ShouldRestrictDLQRedrive
+        - redrivePermission: byQueue
+          sourceQueueArns:
+          - !Sub "arn:${AWS::Partition}:sqs:${AWS::Region}:${AWS::AccountId}:{{.PrimaryQueueName}}"
+{{ range $queue := .Queues }}
+          - !Sub "arn:${AWS::Partition}:sqs:${AWS::Region}:${AWS::AccountId}:{{ $.NamedQueueName $queue }}"
+{{ end }}
+        - !Ref "AWS::NoValue"
+      SqsManagedSseEnabled: !If
+        - ShouldUseKMS
+        - false
+        - true
+      VisibilityTimeout: !If
+        - ShouldInheritDLQVisibilityTimeout
+        - !Ref VisibilityTimeout
+        - !Ref DLQVisibilityTimeout
     Type: AWS::SQS::Queue
+{{ end }}
+{{ range $queue := .Queues }}
+  {{ $queue.LogicalID }}:
+    Properties:
+      QueueName: {{ $.NamedQueueName $queue }}
+{{ if $.FIFOQueue }}
+      FifoQueue: {{ $.FIFOQueue }}
+      ContentBasedDeduplication: !If
+        - ShouldUseContentBasedDeduplication
+        - true
+        - false
+      DeduplicationScope: !Ref DeduplicationScope
+      FifoThroughputLimit: !Ref FifoThroughputLimit
+{{ end }}
+      Tags:
+      - Key: QueueType
+        Value: Named
+      - Key: QueueName
+        Value: {{ $queue.Name }}
+{{ range $key, $value := $.Tags }}
+      - Key: {{ $key }}
+        Value: {{ $value }}
+{{ end }}
+{{ if $queue.DelaySeconds }}
+      DelaySeconds: {{ $queue.DelaySeconds }}
+{{ end }}
+      KmsMasterKeyId: !If
+        - ShouldUseKMS
+        - !If
+          - ShouldCreateKMSKey
+          - Fn::GetAtt:
+            - QueueKey
+            - Arn
+          - !Ref KmsKeyArn
+        - !Ref "AWS::NoValue"
+{{ if $queue.MaximumMessageSize }}
+      MaximumMessageSize: {{ $queue.MaximumMessageSize }}
+{{ end }}
+{{ if $queue.MessageRetentionPeriod }}
+      MessageRetentionPeriod: {{ $queue.MessageRetentionPeriod }}
+{{ end }}
+{{ if $queue.ReceiveMessageWaitTimeSeconds }}
+      ReceiveMessageWaitTimeSeconds: {{ $queue.ReceiveMessageWaitTimeSeconds }}
+{{ end }}
+{{ if and $queue.HasRedrive (not $.SingleQueue) }}
+      RedrivePolicy:
+        deadLetterTargetArn:
+          Fn::GetAtt:
+          - SecondaryQueue
+          - Arn
+        maxReceiveCount: {{ $queue.RedriveMaxReceiveCount }}
+{{ end }}
+      SqsManagedSseEnabled: !If
+        - ShouldUseKMS
+        - false
+        - true
+{{ if $queue.VisibilityTimeout }}
+      VisibilityTimeout: {{ $queue.VisibilityTimeout }}
+{{ end }}
+    Type: AWS::SQS::Queue
+{{ end }}
+{{ if .Topic }}
+  Topic:
+    Properties:
+      TopicName: {{.TopicName}}
+{{ if .FIFOQueue }}
+      FifoTopic: true
+      ContentBasedDeduplication: !If
+        - ShouldUseContentBasedDeduplication
+        - true
+        - false
+{{ end }}
+      KmsMasterKeyId: !If
+        - ShouldUseKMS
+        - !If
+          - ShouldCreateKMSKey
+          - Fn::GetAtt:
+            - QueueKey
+            - Arn
+          - !Ref KmsKeyArn
+        - !Ref "AWS::NoValue"
+{{ if .Tags }}
+      Tags:
+{{ range $key, $value := .Tags }}
+      - Key: {{ $key }}
+        Value: {{ $value }}
+{{ end }}
+{{ end }}
+    Type: AWS::SNS::Topic
+{{ if not .Broadcast }}
+  PrimaryQueueSubscription:
+    Properties:
+      Endpoint:
+        Fn::GetAtt:
+        - PrimaryQueue
+        - Arn
+{{ if .FilterPolicy }}
+      FilterPolicy: {{ .FilterPolicy }}
+{{ end }}
+      Protocol: sqs
+      RawMessageDelivery: !If
+        - ShouldUseRawMessageDelivery
+        - true
+        - false
+      TopicArn: !Ref Topic
+    Type: AWS::SNS::Subscription
+{{ range $queue := .Queues }}
+  {{ $queue.SubscriptionLogicalID }}:
+    Properties:
+      Endpoint:
+        Fn::GetAtt:
+        - {{ $queue.LogicalID }}
+        - Arn
+{{ if $queue.FilterPolicyJSON }}
+      FilterPolicy: {{ $queue.FilterPolicyJSON }}
+{{ end }}
+      Protocol: sqs
+      RawMessageDelivery: {{ $queue.UsesRawMessageDelivery }}
+      TopicArn: !Ref Topic
+    Type: AWS::SNS::Subscription
+{{ end }}
+{{ end }}
+{{ end }}
+{{ if and (not .Broadcast) (or .Topic .AllowedSenders .HardenedQueuePolicy) }}
+  QueuePolicy:
+    Properties:
+      PolicyDocument:
+        Statement:
+{{ if .DenyInsecureTransport }}
+        - Action: sqs:*
+          Condition:
+            Bool:
+              aws:SecureTransport: "false"
+          Effect: Deny
+          Principal: "*"
+          Resource:
+          - Fn::GetAtt:
+            - PrimaryQueue
+            - Arn
+{{ if not .SingleQueue }}
+          - Fn::GetAtt:
+            - SecondaryQueue
+            - Arn
+{{ end }}
+{{ range $queue := .Queues }}
+          - Fn::GetAtt:
+            - {{ $queue.LogicalID }}
+            - Arn
+{{ end }}
+{{ end }}
+{{ if .RestrictPrincipals }}
+        - Action: sqs:*
+          Condition:
+            ArnNotLike:
+              aws:PrincipalArn:
+              - !Sub "arn:${AWS::Partition}:iam::${AWS::AccountId}:user/{{ .ResourcePrefix }}/*"
+              - !Sub "arn:${AWS::Partition}:iam::${AWS::AccountId}:role/{{ .ResourcePrefix }}/*"
+              - "{{ .BrokerPrincipalARN }}"
+            StringEquals:
+              aws:PrincipalAccount: !Ref AWS::AccountId
+          Effect: Deny
+          Principal: "*"
+          Resource:
+          - Fn::GetAtt:
+            - PrimaryQueue
+            - Arn
+{{ if not .SingleQueue }}
+          - Fn::GetAtt:
+            - SecondaryQueue
+            - Arn
+{{ end }}
+{{ range $queue := .Queues }}
+          - Fn::GetAtt:
+            - {{ $queue.LogicalID }}
+            - Arn
+{{ end }}
+{{ end }}
+{{ if .Topic }}
+        - Action: sqs:SendMessage
+          Condition:
+            ArnEquals:
+              aws:SourceArn: !Ref Topic
+          Effect: Allow
+          Principal:
+            Service: sns.amazonaws.com
+          Resource:
+          - Fn::GetAtt:
+            - PrimaryQueue
+            - Arn
+{{ range $queue := .Queues }}
+          - Fn::GetAtt:
+            - {{ $queue.LogicalID }}
+            - Arn
+{{ end }}
+{{ end }}
+{{ range $sender := .AllowedSenders }}
+        - Action: sqs:SendMessage
+{{ if $sender.Service }}
+          Condition:
+            ArnEquals:
+              aws:SourceArn: "{{ $sender.SourceARN }}"
+{{ end }}
+          Effect: Allow
+          Principal:
+{{ if $sender.Service }}
+            Service: {{ $sender.Service }}
+{{ else }}
+            AWS: !Sub "arn:${AWS::Partition}:iam::{{ $sender.Account }}:root"
+{{ end }}
+          Resource:
+          - Fn::GetAtt:
+            - PrimaryQueue
+            - Arn
+{{ end }}
+        Version: 2012-10-17
+      Queues:
+      - !Ref PrimaryQueue
+{{ if and .HardenedQueuePolicy (not .SingleQueue) }}
+      - !Ref SecondaryQueue
+{{ end }}
+{{ if or .Topic .HardenedQueuePolicy }}
+{{ range $queue := .Queues }}
+      - !Ref {{ $queue.LogicalID }}
+{{ end }}
+{{ end }}
+    Type: AWS::SQS::QueuePolicy
+{{ end }}
+{{ if .AlarmsEnabled }}
+  AlarmTopic:
+    Properties:
+      TopicName: {{ .AlarmTopicName }}
+{{ if .Tags }}
+      Tags:
+{{ range $key, $value := .Tags }}
+      - Key: {{ $key }}
+        Value: {{ $value }}
+{{ end }}
+{{ end }}
+    Type: AWS::SNS::Topic
+{{ range $i, $endpoint := .Alarms.Endpoints }}
+  AlarmTopicSubscription{{ $i }}:
+    Properties:
+      Endpoint: "{{ $endpoint.Endpoint }}"
+      Protocol: {{ $endpoint.Protocol }}
+      TopicArn: !Ref AlarmTopic
+    Type: AWS::SNS::Subscription
+{{ end }}
+{{ if and .Alarms.HasDLQDepth (not .SingleQueue) }}
+  SecondaryQueueDepthAlarm:
+    Properties:
+      AlarmActions:
+      - !Ref AlarmTopic
+      AlarmDescription: Messages have been moved to {{ .SecondaryQueueName }}
+      ComparisonOperator: GreaterThanThreshold
+      Dimensions:
+      - Name: QueueName
+        Value: {{ .SecondaryQueueName }}
+      EvaluationPeriods: 1
+      MetricName: ApproximateNumberOfMessagesVisible
+      Namespace: AWS/SQS
+      OKActions:
+      - !Ref AlarmTopic
+      Period: 300
+      Statistic: Maximum
+      Threshold: 0
+      TreatMissingData: notBreaching
+    Type: AWS::CloudWatch::Alarm
+{{ end }}
+{{ with .Alarms.OldestMessageAge }}
+  PrimaryQueueAgeAlarm:
+    Properties:
+      AlarmActions:
+      - !Ref AlarmTopic
+      AlarmDescription: The oldest message in {{ $.PrimaryQueueName }} is over {{ . }} seconds old
+      ComparisonOperator: GreaterThanOrEqualToThreshold
+      Dimensions:
+      - Name: QueueName
+        Value: {{ $.PrimaryQueueName }}
+      EvaluationPeriods: 1
+      MetricName: ApproximateAgeOfOldestMessage
+      Namespace: AWS/SQS
+      OKActions:
+      - !Ref AlarmTopic
+      Period: 300
+      Statistic: Maximum
+      Threshold: {{ . }}
+      TreatMissingData: notBreaching
+    Type: AWS::CloudWatch::Alarm
+{{ range $queue := $.Queues }}
+  {{ $queue.LogicalID }}AgeAlarm:
+    Properties:
+      AlarmActions:
+      - !Ref AlarmTopic
+      AlarmDescription: The oldest message in {{ $.NamedQueueName $queue }} is over {{ $.Alarms.OldestMessageAge }} seconds old
+      ComparisonOperator: GreaterThanOrEqualToThreshold
+      Dimensions:
+      - Name: QueueName
+        Value: {{ $.NamedQueueName $queue }}
+      EvaluationPeriods: 1
+      MetricName: ApproximateAgeOfOldestMessage
+      Namespace: AWS/SQS
+      OKActions:
+      - !Ref AlarmTopic
+      Period: 300
+      Statistic: Maximum
+      Threshold: {{ $.Alarms.OldestMessageAge }}
+      TreatMissingData: notBreaching
+    Type: AWS::CloudWatch::Alarm
+{{ end }}
+{{ end }}
+{{ with .Alarms.QueueDepth }}
+  PrimaryQueueDepthAlarm:
+    Properties:
+      AlarmActions:
+      - !Ref AlarmTopic
+      AlarmDescription: There are {{ . }} or more messages in {{ $.PrimaryQueueName }}
+      ComparisonOperator: GreaterThanOrEqualToThreshold
+      Dimensions:
+      - Name: QueueName
+        Value: {{ $.PrimaryQueueName }}
+      EvaluationPeriods: 1
+      MetricName: ApproximateNumberOfMessagesVisible
+      Namespace: AWS/SQS
+      OKActions:
+      - !Ref AlarmTopic
+      Period: 300
+      Statistic: Maximum
+      Threshold: {{ . }}
+      TreatMissingData: notBreaching
+    Type: AWS::CloudWatch::Alarm
+{{ range $queue := $.Queues }}
+  {{ $queue.LogicalID }}DepthAlarm:
+    Properties:
+      AlarmActions:
+      - !Ref AlarmTopic
+      AlarmDescription: There are {{ $.Alarms.QueueDepth }} or more messages in {{ $.NamedQueueName $queue }}
+      ComparisonOperator: GreaterThanOrEqualToThreshold
+      Dimensions:
+      - Name: QueueName
+        Value: {{ $.NamedQueueName $queue }}
+      EvaluationPeriods: 1
+      MetricName: ApproximateNumberOfMessagesVisible
+      Namespace: AWS/SQS
+      OKActions:
+      - !Ref AlarmTopic
+      Period: 300
+      Statistic: Maximum
+      Threshold: {{ $.Alarms.QueueDepth }}
+      TreatMissingData: notBreaching
+    Type: AWS::CloudWatch::Alarm
+{{ end }}
+{{ end }}
+{{ end }}
 Outputs:
+  KMSKeyARN:
+    Condition: ShouldUseKMS
+    Description: ARN of the KMS key used to encrypt the queues
+    Value: !If
+      - ShouldCreateKMSKey
+      - Fn::GetAtt:
+        - QueueKey
+        - Arn
+      - !Ref KmsKeyArn
+{{ if not .Broadcast }}
   PrimaryQueueARN:
     Description: Primary queue ARN
     Value:
@@ -123,6 +739,13 @@ Outputs:
   PrimaryQueueURL:
     Description: Primary queue URL
     Value: !Ref PrimaryQueue
+{{ end }}
+{{ if .Topic }}
+  TopicARN:
+    Description: Topic ARN
+    Value: !Ref Topic
+{{ end }}
+{{ if not .SingleQueue }}
   SecondaryQueueARN:
     Description: Secondary queue ARN
     Value:
@@ -132,6 +755,18 @@ Outputs:
   SecondaryQueueURL:
     Description: Secondary queue URL
     Value: !Ref SecondaryQueue
+{{ end }}
+{{ range $queue := .Queues }}
+  {{ $queue.LogicalID }}ARN:
+    Description: {{ $queue.Name }} queue ARN
+    Value:
+      Fn::GetAtt:
+      - {{ $queue.LogicalID }}
+      - Arn
+  {{ $queue.LogicalID }}URL:
+    Description: {{ $queue.Name }} queue URL
+    Value: !Ref {{ $queue.LogicalID }}
+{{ end }}
 `
 
 // userTemplateFormat is a raw text/template for generating a
@@ -140,6 +775,55 @@ Outputs:
 // struct.
 const userTemplateFormat = `
 AWSTemplateFormatVersion: 2010-09-09
+{{ if not .UsesRole }}
+Parameters:
+  ActiveAccessKey:
+    AllowedValues:
+    - "1"
+    - "2"
+    Default: "1"
+    Description: |
+      The access key whose credentials are stored in the binding
+      credentials.
+    Type: String
+  RetiringAccessKey:
+    AllowedValues:
+    - ""
+    - "1"
+    - "2"
+    Default: ""
+    Description: |
+      The access key that was active before the last rotation, which is
+      kept until the grace period has passed.
+    Type: String
+  AccessKeyRotatedAt:
+    Default: ""
+    Description: |
+      When the access keys were last rotated, in RFC 3339 format. Empty
+      if they have not been rotated since the stack was created.
+    Type: String
+Conditions:
+  HasAccessKey1:
+    Fn::Or:
+    - Fn::Equals:
+      - !Ref ActiveAccessKey
+      - "1"
+    - Fn::Equals:
+      - !Ref RetiringAccessKey
+      - "1"
+  HasAccessKey2:
+    Fn::Or:
+    - Fn::Equals:
+      - !Ref ActiveAccessKey
+      - "2"
+    - Fn::Equals:
+      - !Ref RetiringAccessKey
+      - "2"
+  ShouldUseAccessKey2:
+    Fn::Equals:
+    - !Ref ActiveAccessKey
+    - "2"
+{{ end }}
 Outputs:
   CredentialsARN:
     Description: Path to the binding credentials
@@ -151,15 +835,33 @@ Resources:
       Description: Binding credentials
       Name: '{{ .ResourcePrefix }}-{{ .BindingID }}'
       SecretString:
-        Fn::Sub: '{{ .CredentialsJSON }}'
+{{ if .UsesRole }}
+        Fn::Sub: '{{ .RoleCredentialsJSON }}'
+{{ else }}
+        Fn::If:
+        - ShouldUseAccessKey2
+        - Fn::Sub: '{{ .CredentialsJSON "IAMAccessKey2" }}'
+        - Fn::Sub: '{{ .CredentialsJSON "IAMAccessKey" }}'
+{{ end }}
     Type: AWS::SecretsManager::Secret
+{{ if not .UsesRole }}
   IAMAccessKey:
+    Condition: HasAccessKey1
+    Properties:
+      Serial: 1
+      Status: Active
+      UserName:
+        Ref: IAMUser
+    Type: AWS::IAM::AccessKey
+  IAMAccessKey2:
+    Condition: HasAccessKey2
     Properties:
       Serial: 1
       Status: Active
       UserName:
         Ref: IAMUser
     Type: AWS::IAM::AccessKey
+{{ end }}
   IAMPolicy:
     Properties:
       PolicyDocument:
@@ -170,13 +872,202 @@ Resources:
 {{ end }}
           Effect: Allow
           Resource:
+{{ if .GrantsPrimaryQueues }}
+{{ if .BroadcastQueue }}
+          - Fn::GetAtt:
+            - BindingQueue
+            - Arn
+{{ else }}
           - "{{ .PrimaryQueueARN }}"
+{{ end }}
+{{ end }}
+{{ if .GrantsSecondaryQueue }}
           - "{{ .SecondaryQueueARN }}"
+{{ end }}
+{{ if .GrantsPrimaryQueues }}
+{{ range $arn := .NamedQueueARNs }}
+          - "{{ $arn }}"
+{{ end }}
+{{ end }}
+{{ if and .TopicARN .TopicPolicyActions }}
+        - Action:
+{{ range $action := .TopicPolicyActions }}
+          - {{ $action }}
+{{ end }}
+          Effect: Allow
+          Resource:
+          - "{{ .TopicARN }}"
+          - "{{ .TopicARN }}:*"
+{{ end }}
+{{ if .KMSKeyARN }}
+        - Action:
+          - kms:Decrypt
+          - kms:GenerateDataKey
+          Effect: Allow
+          Resource:
+          - "{{ .KMSKeyARN }}"
+{{ end }}
+{{ range $statement := .PolicyStatements }}
+        - Action:
+{{ range $action := $statement.Actions }}
+          - {{ $action }}
+{{ end }}
+          Effect: {{ $statement.StatementEffect }}
+          Resource:
+{{ range $resource := $statement.StatementResources }}
+          - "{{ $resource }}"
+{{ end }}
+{{ end }}
         Version: 2012-10-17
       PolicyName: '{{ .ResourcePrefix }}-{{ .BindingID }}'
+{{ if .UsesRole }}
+      Roles:
+      - Ref: IAMRole
+{{ else }}
       Users:
       - Ref: IAMUser
+{{ end }}
     Type: AWS::IAM::Policy
+{{ with .BroadcastQueue }}
+  BindingQueue:
+    Properties:
+      QueueName: {{ .QueueName }}
+{{ if .FIFO }}
+      FifoQueue: true
+{{ with .Params.ContentBasedDeduplication }}
+      ContentBasedDeduplication: {{ . }}
+{{ end }}
+{{ with .Params.DeduplicationScope }}
+      DeduplicationScope: {{ . }}
+{{ end }}
+{{ with .Params.FifoThroughputLimit }}
+      FifoThroughputLimit: {{ . }}
+{{ end }}
+{{ end }}
+{{ with .Params.DelaySeconds }}
+      DelaySeconds: {{ . }}
+{{ end }}
+{{ if $.KMSKeyARN }}
+      KmsMasterKeyId: "{{ $.KMSKeyARN }}"
+      SqsManagedSseEnabled: false
+{{ else }}
+      SqsManagedSseEnabled: true
+{{ end }}
+{{ with .Params.MaximumMessageSize }}
+      MaximumMessageSize: {{ . }}
+{{ end }}
+{{ with .Params.MessageRetentionPeriod }}
+      MessageRetentionPeriod: {{ . }}
+{{ end }}
+{{ with .Params.ReceiveMessageWaitTimeSeconds }}
+      ReceiveMessageWaitTimeSeconds: {{ . }}
+{{ end }}
+{{ with .Params.VisibilityTimeout }}
+      VisibilityTimeout: {{ . }}
+{{ end }}
+{{ if $.Tags }}
+      Tags:
+{{ range $key, $value := $.Tags }}
+      - Key: {{ $key }}
+        Value: {{ $value }}
+{{ end }}
+{{ end }}
+    Type: AWS::SQS::Queue
+  BindingQueuePolicy:
+    Properties:
+      PolicyDocument:
+        Statement:
+{{ if $.DenyInsecureTransport }}
+        - Action: sqs:*
+          Condition:
+            Bool:
+              aws:SecureTransport: "false"
+          Effect: Deny
+          Principal: "*"
+          Resource:
+          - Fn::GetAtt:
+            - BindingQueue
+            - Arn
+{{ end }}
+{{ if $.RestrictPrincipals }}
+        - Action: sqs:*
+          Condition:
+            ArnNotLike:
+              aws:PrincipalArn:
+              - Fn::Sub: "arn:${AWS::Partition}:iam::${AWS::AccountId}:user/{{ $.ResourcePrefix }}/*"
+              - Fn::Sub: "arn:${AWS::Partition}:iam::${AWS::AccountId}:role/{{ $.ResourcePrefix }}/*"
+              - "{{ $.BrokerPrincipalARN }}"
+            StringEquals:
+              aws:PrincipalAccount:
+                Ref: AWS::AccountId
+          Effect: Deny
+          Principal: "*"
+          Resource:
+          - Fn::GetAtt:
+            - BindingQueue
+            - Arn
+{{ end }}
+        - Action: sqs:SendMessage
+          Condition:
+            ArnEquals:
+              aws:SourceArn: "{{ $.TopicARN }}"
+          Effect: Allow
+          Principal:
+            Service: sns.amazonaws.com
+          Resource:
+          - Fn::GetAtt:
+            - BindingQueue
+            - Arn
+        Version: 2012-10-17
+      Queues:
+      - Ref: BindingQueue
+    Type: AWS::SQS::QueuePolicy
+  BindingQueueSubscription:
+    DependsOn: BindingQueuePolicy
+    Properties:
+      Endpoint:
+        Fn::GetAtt:
+        - BindingQueue
+        - Arn
+{{ if $.FilterPolicyJSON }}
+      FilterPolicy: {{ $.FilterPolicyJSON }}
+{{ end }}
+      Protocol: sqs
+      RawMessageDelivery: {{ $.UsesRawMessageDelivery }}
+      TopicArn: "{{ $.TopicARN }}"
+    Type: AWS::SNS::Subscription
+{{ end }}
+{{ if .UsesRole }}
+  IAMRole:
+    Properties:
+      AssumeRolePolicyDocument:
+        Statement:
+        - Action: sts:AssumeRole
+          Condition:
+            StringEquals:
+              sts:ExternalId: "{{ .ExternalID }}"
+          Effect: Allow
+          Principal:
+            AWS: "{{ .RoleTrustPrincipalARN }}"
+        Version: 2012-10-17
+      Path: /{{ .ResourcePrefix }}/
+{{ if .PermissionsBoundary }}
+      PermissionsBoundary: {{ .PermissionsBoundary }}
+{{ end }}
+{{ if .AdditionalUserPolicy }}
+      ManagedPolicyArns:
+      - "{{ .AdditionalUserPolicy }}"
+{{ end }}
+      RoleName: binding-{{ .BindingID }}
+{{ if .Tags }}
+      Tags:
+{{ range $key, $value := .Tags }}
+      - Key: {{ $key }}
+        Value: {{ $value }}
+{{ end }}
+{{ end }}
+    Type: AWS::IAM::Role
+{{ else }}
   IAMUser:
     Properties:
       Path: /{{ .ResourcePrefix }}/
@@ -196,4 +1087,5 @@ Resources:
 {{ end }}
 {{ end }}
     Type: AWS::IAM::User
+{{ end }}
 `
@@ -0,0 +1,66 @@
+package sqs
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+const (
+	ParamFilterPolicy       = "FilterPolicy"
+	ParamRawMessageDelivery = "RawMessageDelivery"
+)
+
+const (
+	ConditionShouldUseRawMessageDelivery = "ShouldUseRawMessageDelivery"
+)
+
+const (
+	ResourceTopic                    = "Topic"
+	ResourcePrimaryQueueSubscription = "PrimaryQueueSubscription"
+	ResourceQueuePolicy              = "QueuePolicy"
+)
+
+const (
+	OutputTopicARN = "TopicARN"
+)
+
+// TopicName builds the name for the topic. FIFO topics require the same
+// suffix as FIFO queues.
+func (params *QueueTemplateBuilder) TopicName() string {
+	return params.QueueName + params.ext()
+}
+
+// SubscriptionLogicalID returns the ID of the named queue's subscription
+// to the topic in the queue stack.
+func (queue NamedQueue) SubscriptionLogicalID() string {
+	return queue.LogicalID() + "Subscription"
+}
+
+// FilterPolicyJSON returns the queue's filter policy in the form used by
+// the queue template.
+func (queue NamedQueue) FilterPolicyJSON() string {
+	return filterPolicyJSON(queue.FilterPolicy)
+}
+
+// UsesRawMessageDelivery returns true if messages published to the topic
+// are delivered to the queue without the SNS envelope.
+func (queue NamedQueue) UsesRawMessageDelivery() bool {
+	return queue.RawMessageDelivery != nil && *queue.RawMessageDelivery
+}
+
+// filterPolicyJSON compacts a filter policy onto a single line so it can
+// be written into the queue template as a YAML flow mapping. An empty
+// policy, or {}, is no filter policy at all.
+func filterPolicyJSON(policy json.RawMessage) string {
+	if len(policy) == 0 {
+		return ""
+	}
+	buf := new(bytes.Buffer)
+	if err := json.Compact(buf, policy); err != nil {
+		return ""
+	}
+	if buf.String() == "{}" {
+		return ""
+	}
+	return buf.String()
+}
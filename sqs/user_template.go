@@ -2,17 +2,18 @@ package sqs
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"strings"
 	"text/template"
-
-	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
 )
 
 const (
 	ResourceUser        = "IAMUser"
+	ResourceRole        = "IAMRole"
 	ResourceAccessKey   = "IAMAccessKey"
+	ResourceAccessKey2  = "IAMAccessKey2"
 	ResourcePolicy      = "IAMPolicy"
 	ResourceCredentials = "BindingCredentials"
 )
@@ -27,32 +28,154 @@ const (
 	AccessPolicyFull     AccessPolicy = "full"
 	AccessPolicyProducer AccessPolicy = "producer"
 	AccessPolicyConsumer AccessPolicy = "consumer"
+	AccessPolicyMonitor  AccessPolicy = "monitor"
+)
+
+// BindingType is the kind of IAM identity a binding's credentials are
+// for. User bindings get an IAM user with access keys. Role bindings get
+// an IAM role that RoleTrustPrincipalARN can assume with the binding's
+// external ID, so no long-lived keys are issued.
+type BindingType = string
+
+const (
+	BindingTypeUser BindingType = "user"
+	BindingTypeRole BindingType = "role"
 )
 
 type UserTemplateBuilder struct {
-	BindingID            string            `json:"-"`
-	ResourcePrefix       string            `json:"-"`
-	UserPath             string            `json:"-"`
-	PrimaryQueueURL      string            `json:"-"`
-	PrimaryQueueARN      string            `json:"-"`
-	SecondaryQueueURL    string            `json:"-"`
-	SecondaryQueueARN    string            `json:"-"`
-	Tags                 map[string]string `json:"-"`
-	AdditionalUserPolicy string            `json:"-"`
-	PermissionsBoundary  string            `json:"-"`
-	AccessPolicy         AccessPolicy      `json:"access_policy"`
-	AccessPolicyActions  []string
+	BindingID         string            `json:"-"`
+	ResourcePrefix    string            `json:"-"`
+	UserPath          string            `json:"-"`
+	PrimaryQueueURL   string            `json:"-"`
+	PrimaryQueueARN   string            `json:"-"`
+	SecondaryQueueURL string            `json:"-"`
+	SecondaryQueueARN string            `json:"-"`
+	NamedQueueURLs    map[string]string `json:"-"`
+	NamedQueueARNs    []string          `json:"-"`
+	KMSKeyARN         string            `json:"-"`
+	TopicARN          string            `json:"-"`
+	BroadcastQueue    *BroadcastQueue   `json:"-"`
+	// DenyInsecureTransport and RestrictPrincipals harden the policy of
+	// the broadcast queue in the same way as the queue stack's policy.
+	DenyInsecureTransport bool              `json:"-"`
+	RestrictPrincipals    bool              `json:"-"`
+	BrokerPrincipalARN    string            `json:"-"`
+	Tags                  map[string]string `json:"-"`
+	AdditionalUserPolicy  string            `json:"-"`
+	PermissionsBoundary   string            `json:"-"`
+	AccessPolicy          AccessPolicy      `json:"access_policy" enum:"full,producer,consumer,monitor"`
+	BindingType           BindingType       `json:"binding_type,omitempty" enum:"user,role"`
+	// RoleTrustPrincipalARN is allowed to assume the role of a role
+	// binding when it gives ExternalID.
+	RoleTrustPrincipalARN string `json:"-"`
+	ExternalID            string `json:"-"`
+	// AccessPolicies are the access policies the binding may use, by
+	// name. DefaultAccessPolicies are used when it is nil.
+	AccessPolicies      map[string]AccessPolicyConfig `json:"-"`
+	AccessPolicyActions []string
+	TopicPolicyActions  []string
+	// AccessPolicyQueues and PolicyStatements are set by Build from the
+	// binding's access policy.
+	AccessPolicyQueues string            `json:"-"`
+	PolicyStatements   []PolicyStatement `json:"-"`
+	// FilterPolicy limits the messages published to the topic that are
+	// delivered to the binding's queue, on broadcast plans.
+	FilterPolicy json.RawMessage `json:"filter_policy,omitempty" topic:"true"`
+	// RawMessageDelivery delivers messages published to the topic to the
+	// binding's queue without the SNS envelope, on broadcast plans.
+	RawMessageDelivery *bool `json:"raw_message_delivery,omitempty" topic:"true"`
 }
 
 type Credentials struct {
-	AWSAccessKeyID     string `json:"aws_access_key_id"`
-	AWSSecretAccessKey string `json:"aws_secret_access_key"`
-	AWSRegion          string `json:"aws_region"`
-	PrimaryQueueURL    string `json:"primary_queue_url"`
-	SecondaryQueueURL  string `json:"secondary_queue_url"`
+	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty"`
+	AWSSecretAccessKey string `json:"aws_secret_access_key,omitempty"`
+	// AWSRoleARN and AWSExternalID are given instead of access keys to
+	// role bindings, to assume the role with.
+	AWSRoleARN        string `json:"aws_role_arn,omitempty"`
+	AWSExternalID     string `json:"aws_external_id,omitempty"`
+	AWSRegion         string `json:"aws_region"`
+	PrimaryQueueURL   string `json:"primary_queue_url"`
+	SecondaryQueueURL string `json:"secondary_queue_url,omitempty"`
+	// QueueURLs maps the names of the instance's named queues to
+	// their URLs.
+	QueueURLs map[string]string `json:"queue_urls,omitempty"`
+	// TopicARN is the topic that the queues are subscribed to, on plans
+	// with a topic.
+	TopicARN string `json:"topic_arn,omitempty"`
+}
+
+// FilterPolicyJSON returns the filter policy of the binding queue's
+// subscription in the form used by the user template.
+func (builder UserTemplateBuilder) FilterPolicyJSON() string {
+	return filterPolicyJSON(builder.FilterPolicy)
+}
+
+// UsesRawMessageDelivery returns true if messages published to the topic
+// are delivered to the binding queue without the SNS envelope.
+func (builder UserTemplateBuilder) UsesRawMessageDelivery() bool {
+	return builder.RawMessageDelivery != nil && *builder.RawMessageDelivery
+}
+
+// parametersHash returns a hash of the binding's subscription settings,
+// so a repeated bind request can be compared with the original, or an
+// empty string if none are set.
+func (builder UserTemplateBuilder) parametersHash() string {
+	filterPolicy := builder.FilterPolicyJSON()
+	if filterPolicy == "" && builder.RawMessageDelivery == nil {
+		return ""
+	}
+	data, _ := json.Marshal(struct {
+		FilterPolicy       string `json:"filter_policy"`
+		RawMessageDelivery *bool  `json:"raw_message_delivery"`
+	}{filterPolicy, builder.RawMessageDelivery})
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// GrantsPrimaryQueues returns true if the access policy applies to the
+// primary and named queues.
+func (builder UserTemplateBuilder) GrantsPrimaryQueues() bool {
+	return builder.AccessPolicyQueues != AccessPolicyQueuesSecondary
+}
+
+// GrantsSecondaryQueue returns true if the access policy applies to the
+// dead letter queue.
+func (builder UserTemplateBuilder) GrantsSecondaryQueue() bool {
+	return builder.AccessPolicyQueues != AccessPolicyQueuesPrimary && builder.SecondaryQueueARN != ""
+}
+
+// UsesRole returns true if the binding is for an IAM role rather than an
+// IAM user.
+func (builder UserTemplateBuilder) UsesRole() bool {
+	return builder.BindingType == BindingTypeRole
+}
+
+// CredentialsJSON returns the credentials for the binding with the given
+// access key. The user template has two access keys so that they can be
+// rotated, see RotateAccessKeys.
+func (builder UserTemplateBuilder) CredentialsJSON(accessKey string) (string, error) {
+	credentialsPlaceholders := builder.credentialsPlaceholders()
+	credentialsPlaceholders.AWSAccessKeyID = fmt.Sprintf("${%s}", accessKey)
+	credentialsPlaceholders.AWSSecretAccessKey = fmt.Sprintf("${%s.SecretAccessKey}", accessKey)
+	credentialsTemplate, err := json.Marshal(credentialsPlaceholders)
+	if err != nil {
+		return "", err
+	}
+	return string(credentialsTemplate), nil
 }
 
-func (builder UserTemplateBuilder) CredentialsJSON() (string, error) {
+// RoleCredentialsJSON returns the credentials for a role binding.
+func (builder UserTemplateBuilder) RoleCredentialsJSON() (string, error) {
+	credentialsPlaceholders := builder.credentialsPlaceholders()
+	credentialsPlaceholders.AWSRoleARN = fmt.Sprintf("${%s.Arn}", ResourceRole)
+	credentialsPlaceholders.AWSExternalID = builder.ExternalID
+	credentialsTemplate, err := json.Marshal(credentialsPlaceholders)
+	if err != nil {
+		return "", err
+	}
+	return string(credentialsTemplate), nil
+}
+
+func (builder UserTemplateBuilder) credentialsPlaceholders() Credentials {
 	// this is a template representing the json credential for the binding.
 	// the values get interpolated with values from cloudformation
 	// once they are available.
@@ -61,28 +184,48 @@ func (builder UserTemplateBuilder) CredentialsJSON() (string, error) {
 	// ${res.arn} is equivilent to cloudformation.GetAtt("res", "arn")
 	//
 	credentialsPlaceholders := Credentials{
-		AWSAccessKeyID:     fmt.Sprintf("${%s}", ResourceAccessKey),
-		AWSSecretAccessKey: fmt.Sprintf("${%s.SecretAccessKey}", ResourceAccessKey),
-		AWSRegion:          "${AWS::Region}",
-		PrimaryQueueURL:    builder.PrimaryQueueURL,
-		SecondaryQueueURL:  builder.SecondaryQueueURL,
+		AWSRegion:         "${AWS::Region}",
+		PrimaryQueueURL:   builder.PrimaryQueueURL,
+		SecondaryQueueURL: builder.SecondaryQueueURL,
+		QueueURLs:         builder.NamedQueueURLs,
+		TopicARN:          builder.TopicARN,
 	}
-	credentialsTemplate, err := json.Marshal(credentialsPlaceholders)
-	if err != nil {
-		return "", err
+	if builder.BroadcastQueue != nil {
+		credentialsPlaceholders.PrimaryQueueURL = fmt.Sprintf("${%s}", ResourceBindingQueue)
 	}
-	return string(credentialsTemplate), nil
+	return credentialsPlaceholders
 }
 
 func (builder UserTemplateBuilder) Build() (string, error) {
 	if builder.AccessPolicy == "" {
 		builder.AccessPolicy = "full"
 	}
-	var err error
-	builder.AccessPolicyActions, err = builder.GetAccessPolicy()
+	switch builder.BindingType {
+	case "", BindingTypeUser:
+	case BindingTypeRole:
+		if builder.RoleTrustPrincipalARN == "" || builder.ExternalID == "" {
+			return "", fmt.Errorf("role bindings need a trust principal and an external ID")
+		}
+	default:
+		return "", fmt.Errorf("unknown binding type %#v", builder.BindingType)
+	}
+	actions, err := builder.GetAccessPolicy()
 	if err != nil {
 		return "", err
 	}
+	policy := builder.accessPolicies()[builder.AccessPolicy]
+	builder.AccessPolicyQueues = policy.Queues
+	builder.PolicyStatements = policy.Statements
+	if !builder.GrantsPrimaryQueues() && !builder.GrantsSecondaryQueue() {
+		return "", invalidParams(fmt.Errorf("access policy %#v only applies to the dead letter queue, which this service instance does not have", builder.AccessPolicy))
+	}
+	for _, action := range actions {
+		if strings.HasPrefix(action, "sns:") {
+			builder.TopicPolicyActions = append(builder.TopicPolicyActions, action)
+		} else {
+			builder.AccessPolicyActions = append(builder.AccessPolicyActions, action)
+		}
+	}
 	t, err := template.New("user-template").Parse(userTemplateFormat)
 	if err != nil {
 		return "", err
@@ -96,44 +239,26 @@ func (builder UserTemplateBuilder) Build() (string, error) {
 	return buf.String(), nil
 }
 
+// GetAccessPolicy returns the actions granted by the binding's access
+// policy. When the instance has a topic, the actions on the topic and its
+// subscriptions are included, prefixed "sns:".
 func (builder UserTemplateBuilder) GetAccessPolicy() ([]string, error) {
-	switch builder.AccessPolicy {
-	case AccessPolicyFull:
-		return []string{
-			"sqs:ChangeMessageVisibility",
-			"sqs:DeleteMessage",
-			"sqs:GetQueueAttributes",
-			"sqs:GetQueueUrl",
-			"sqs:ListDeadLetterSourceQueues",
-			"sqs:ListQueueTags",
-			"sqs:PurgeQueue",
-			"sqs:ReceiveMessage",
-			"sqs:SendMessage",
-		}, nil
-	case AccessPolicyProducer:
-		return []string{
-			"sqs:GetQueueAttributes",
-			"sqs:GetQueueUrl",
-			"sqs:ListDeadLetterSourceQueues",
-			"sqs:ListQueueTags",
-			"sqs:SendMessage",
-		}, nil
-	case AccessPolicyConsumer:
-		return []string{
-			"sqs:DeleteMessage",
-			"sqs:GetQueueAttributes",
-			"sqs:GetQueueUrl",
-			"sqs:ListDeadLetterSourceQueues",
-			"sqs:ListQueueTags",
-			"sqs:PurgeQueue",
-			"sqs:ReceiveMessage",
-		}, nil
+	policy, ok := builder.accessPolicies()[builder.AccessPolicy]
+	if !ok {
+		return nil, unknownAccessPolicy(builder.AccessPolicy)
+	}
+	var actions []string
+	for _, action := range policy.Actions {
+		if !strings.HasPrefix(action, "sns:") || builder.TopicARN != "" {
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
 
-	default:
-		return nil, apiresponses.NewFailureResponse(
-			fmt.Errorf("unknown access policy %#v", builder.AccessPolicy),
-			http.StatusBadRequest,
-			"unknown-access-policy",
-		)
+func (builder UserTemplateBuilder) accessPolicies() map[string]AccessPolicyConfig {
+	if builder.AccessPolicies == nil {
+		return DefaultAccessPolicies
 	}
+	return builder.AccessPolicies
 }
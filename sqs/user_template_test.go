@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 
 	"github.com/alphagov/paas-sqs-broker/sqs"
+	"github.com/aws/aws-sdk-go/aws"
 	goformation "github.com/awslabs/goformation/v4"
 	goformationiam "github.com/awslabs/goformation/v4/cloudformation/iam"
 	goformationsecretsmanager "github.com/awslabs/goformation/v4/cloudformation/secretsmanager"
 	goformationtags "github.com/awslabs/goformation/v4/cloudformation/tags"
-	"github.com/awslabs/goformation/v4/intrinsics"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"gopkg.in/yaml.v2"
@@ -43,32 +43,60 @@ var _ = Describe("UserTemplate", func() {
 		Expect(ok).To(BeTrue())
 	})
 
-	It("should not have any input parameters", func() {
+	It("should only have the access key rotation parameters", func() {
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
 		t, err := goformation.ParseYAML([]byte(text))
 		Expect(err).ToNot(HaveOccurred())
-		Expect(t.Parameters).To(BeEmpty())
+		Expect(t.Parameters).To(HaveLen(3))
+		Expect(t.Parameters).To(HaveKey(sqs.ParamActiveAccessKey))
+		Expect(t.Parameters).To(HaveKey(sqs.ParamRetiringAccessKey))
+		Expect(t.Parameters).To(HaveKey(sqs.ParamAccessKeyRotatedAt))
 	})
 
-	It("should create a template for a json blob containing provisioned credentials", func() {
-		processed, err := intrinsics.ProcessYAML([]byte(rawText), nil)
-		Expect(err).ToNot(HaveOccurred())
-		var result map[string]interface{}
-		err = json.Unmarshal(processed, &result)
+	credentialsWith := func(params map[string]string) map[string]interface{} {
+		t := parseTemplate(rawText, params)
+		value := t.Resources[sqs.ResourceCredentials].Properties["SecretString"].(string)
+		var credentials map[string]interface{}
+		err := json.Unmarshal([]byte(value), &credentials)
 		Expect(err).ToNot(HaveOccurred())
-		resources := result["Resources"].(map[string]interface{})
-		resource := resources[sqs.ResourceCredentials].(map[string]interface{})
-		properties := resource["Properties"].(map[string]interface{})
-		value := properties["SecretString"].(string)
-		var credentials map[string]string
-		err = json.Unmarshal([]byte(value), &credentials)
-		Expect(err).ToNot(HaveOccurred())
-		Expect(credentials).To(HaveKey("aws_access_key_id"))
-		Expect(credentials).To(HaveKey("aws_secret_access_key"))
-		Expect(credentials).To(HaveKey("aws_region"))
-		Expect(credentials).To(HaveKey("primary_queue_url"))
-		Expect(credentials).To(HaveKey("secondary_queue_url"))
+		return credentials
+	}
+
+	credentials := func() map[string]interface{} {
+		return credentialsWith(nil)
+	}
+
+	Context("when the instance has a secondary queue", func() {
+		BeforeEach(func() {
+			builder.SecondaryQueueURL = "https://sqs.eu-west-2.amazonaws.com/123456789012/q-sec"
+		})
+		It("should create a template for a json blob containing provisioned credentials", func() {
+			credentials := credentials()
+			Expect(credentials).To(HaveKey("aws_access_key_id"))
+			Expect(credentials).To(HaveKey("aws_secret_access_key"))
+			Expect(credentials).To(HaveKey("aws_region"))
+			Expect(credentials).To(HaveKey("primary_queue_url"))
+			Expect(credentials).To(HaveKey("secondary_queue_url"))
+		})
+	})
+
+	Context("when the instance has no secondary queue", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "abc"
+		})
+		It("should leave the secondary queue out of the credentials", func() {
+			credentials := credentials()
+			Expect(credentials).To(HaveKey("primary_queue_url"))
+			Expect(credentials).NotTo(HaveKey("secondary_queue_url"))
+			Expect(credentials).NotTo(HaveKey("queue_urls"))
+		})
+		It("should scope the policy to the primary queue", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf("abc")),
+				)))
+		})
 	})
 
 	Context("when binding id and prefix are set", func() {
@@ -130,6 +158,7 @@ var _ = Describe("UserTemplate", func() {
 			Expect(policy.PolicyDocument).To(
 				HaveKeyWithValue("Statement", ConsistOf(
 					HaveKeyWithValue("Action", ConsistOf(
+						"sqs:ChangeMessageVisibility",
 						"sqs:DeleteMessage",
 						"sqs:GetQueueAttributes",
 						"sqs:GetQueueUrl",
@@ -214,6 +243,201 @@ var _ = Describe("UserTemplate", func() {
 		})
 	})
 
+	Context("when the instance has named queues", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "abc"
+			builder.SecondaryQueueARN = "qwe"
+			builder.NamedQueueURLs = map[string]string{
+				"jobs": "https://sqs.eu-west-2.amazonaws.com/123456789012/q-jobs",
+			}
+			builder.NamedQueueARNs = []string{"jkl"}
+		})
+		It("should add their URLs to the credentials by name", func() {
+			Expect(credentials()).To(HaveKeyWithValue("queue_urls", map[string]interface{}{
+				"jobs": "https://sqs.eu-west-2.amazonaws.com/123456789012/q-jobs",
+			}))
+		})
+		It("should scope the policy to them as well", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf("abc", "qwe", "jkl")),
+				)))
+		})
+	})
+
+	Context("when the instance has a topic", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "abc"
+			builder.TopicARN = "arn:aws:sns:eu-west-2:123456789012:topic"
+		})
+		It("should add the topic ARN to the credentials", func() {
+			Expect(credentials()).To(HaveKeyWithValue("topic_arn", "arn:aws:sns:eu-west-2:123456789012:topic"))
+		})
+		It("should grant access to the topic and its subscriptions", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf("abc")),
+					And(
+						HaveKeyWithValue("Resource", ConsistOf(
+							"arn:aws:sns:eu-west-2:123456789012:topic",
+							"arn:aws:sns:eu-west-2:123456789012:topic:*",
+						)),
+						HaveKeyWithValue("Action", ContainElements("sns:Publish", "sns:Subscribe")),
+					),
+				)))
+		})
+		Context("and the access policy is 'consumer'", func() {
+			BeforeEach(func() {
+				builder.AccessPolicy = sqs.AccessPolicyConsumer
+			})
+			It("should not allow publishing", func() {
+				Expect(policy.PolicyDocument).To(
+					HaveKeyWithValue("Statement", ContainElement(And(
+						HaveKeyWithValue("Resource", ContainElement("arn:aws:sns:eu-west-2:123456789012:topic")),
+						HaveKeyWithValue("Action", Not(ContainElement("sns:Publish"))),
+					))))
+			})
+		})
+	})
+
+	It("should include topic actions only when there is a topic", func() {
+		builder.AccessPolicy = sqs.AccessPolicyProducer
+		actions, err := builder.GetAccessPolicy()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actions).ToNot(ContainElement(HavePrefix("sns:")))
+		builder.TopicARN = "arn:aws:sns:eu-west-2:123456789012:topic"
+		actions, err = builder.GetAccessPolicy()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actions).To(ContainElements("sqs:SendMessage", "sns:Publish", "sns:GetTopicAttributes"))
+	})
+
+	Context("when the access policy is 'monitor'", func() {
+		BeforeEach(func() {
+			builder.AccessPolicy = sqs.AccessPolicyMonitor
+			builder.PrimaryQueueARN = "abc"
+			builder.SecondaryQueueARN = "qwe"
+		})
+		It("should allow reading queue attributes and CloudWatch metrics only", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					And(
+						HaveKeyWithValue("Resource", ConsistOf("abc", "qwe")),
+						HaveKeyWithValue("Action", ConsistOf(
+							"sqs:GetQueueAttributes",
+							"sqs:GetQueueUrl",
+							"sqs:ListDeadLetterSourceQueues",
+							"sqs:ListQueueTags",
+						)),
+					),
+					And(
+						HaveKeyWithValue("Effect", "Allow"),
+						HaveKeyWithValue("Resource", ConsistOf("*")),
+						HaveKeyWithValue("Action", ConsistOf(
+							"cloudwatch:GetMetricData",
+							"cloudwatch:GetMetricStatistics",
+							"cloudwatch:ListMetrics",
+						)),
+					),
+				)))
+		})
+	})
+
+	Context("when the access policies are configured", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "abc"
+			builder.SecondaryQueueARN = "qwe"
+			builder.NamedQueueARNs = []string{"jkl"}
+			builder.AccessPolicies = map[string]sqs.AccessPolicyConfig{
+				"dlq-reader": {
+					Actions: []string{"sqs:ReceiveMessage", "sqs:DeleteMessage"},
+					Queues:  sqs.AccessPolicyQueuesSecondary,
+				},
+				"sender": {
+					Actions: []string{"sqs:SendMessage"},
+					Queues:  sqs.AccessPolicyQueuesPrimary,
+					Statements: []sqs.PolicyStatement{{
+						Effect:    "Deny",
+						Actions:   []string{"sqs:PurgeQueue"},
+						Resources: []string{"arn:aws:sqs:eu-west-2:123456789012:*"},
+					}},
+				},
+			}
+			builder.AccessPolicy = "sender"
+		})
+		It("should grant the actions on the primary and named queues", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					And(
+						HaveKeyWithValue("Resource", ConsistOf("abc", "jkl")),
+						HaveKeyWithValue("Action", ConsistOf("sqs:SendMessage")),
+					),
+					And(
+						HaveKeyWithValue("Effect", "Deny"),
+						HaveKeyWithValue("Resource", ConsistOf("arn:aws:sqs:eu-west-2:123456789012:*")),
+						HaveKeyWithValue("Action", ConsistOf("sqs:PurgeQueue")),
+					),
+				)))
+		})
+		Context("and the access policy applies to the dead letter queue", func() {
+			BeforeEach(func() {
+				builder.AccessPolicy = "dlq-reader"
+			})
+			It("should grant the actions on the dead letter queue only", func() {
+				Expect(policy.PolicyDocument).To(
+					HaveKeyWithValue("Statement", ConsistOf(
+						And(
+							HaveKeyWithValue("Resource", ConsistOf("qwe")),
+							HaveKeyWithValue("Action", ConsistOf("sqs:ReceiveMessage", "sqs:DeleteMessage")),
+						),
+					)))
+			})
+		})
+		Context("and the instance has a topic", func() {
+			BeforeEach(func() {
+				builder.TopicARN = "arn:aws:sns:eu-west-2:123456789012:topic"
+			})
+			It("should not grant access to the topic when the access policy has no sns: actions", func() {
+				Expect(policy.PolicyDocument).To(
+					HaveKeyWithValue("Statement", ConsistOf(
+						HaveKeyWithValue("Resource", ConsistOf("abc", "jkl")),
+						HaveKeyWithValue("Effect", "Deny"),
+					)))
+			})
+		})
+		It("should reject the default access policies that were not configured", func() {
+			builder.AccessPolicy = sqs.AccessPolicyFull
+			_, err := builder.Build()
+			Expect(err).To(MatchError(`unknown access policy "full"`))
+		})
+		It("should reject access policies for the dead letter queue when there is none", func() {
+			builder.AccessPolicy = "dlq-reader"
+			builder.SecondaryQueueARN = ""
+			_, err := builder.Build()
+			Expect(err).To(MatchError(ContainSubstring("only applies to the dead letter queue")))
+		})
+	})
+
+	Context("when a KMS key ARN is set", func() {
+		BeforeEach(func() {
+			builder.PrimaryQueueARN = "abc"
+			builder.SecondaryQueueARN = "qwe"
+			builder.KMSKeyARN = "arn:aws:kms:eu-west-2:123456789012:key/abcd"
+		})
+		It("grants use of the key", func() {
+			Expect(policy.PolicyDocument).To(
+				HaveKeyWithValue("Statement", ConsistOf(
+					HaveKeyWithValue("Resource", ConsistOf("abc", "qwe")),
+					And(
+						HaveKeyWithValue("Effect", "Allow"),
+						HaveKeyWithValue("Resource", ConsistOf("arn:aws:kms:eu-west-2:123456789012:key/abcd")),
+						HaveKeyWithValue("Action", ConsistOf(
+							"kms:Decrypt",
+							"kms:GenerateDataKey",
+						))),
+				)))
+		})
+	})
+
 	It("should create an active access key", func() {
 		var result map[string]interface{}
 		Expect(yaml.Unmarshal([]byte(rawText), &result)).To(Succeed())
@@ -225,6 +449,40 @@ var _ = Describe("UserTemplate", func() {
 		Expect(properties).To(HaveKeyWithValue("UserName", HaveKeyWithValue("Ref", sqs.ResourceUser)))
 	})
 
+	It("should keep both access keys only while one is being retired", func() {
+		Expect(parseTemplate(rawText, nil).Resources).To(And(
+			HaveKey(sqs.ResourceAccessKey),
+			Not(HaveKey(sqs.ResourceAccessKey2)),
+		))
+		Expect(parseTemplate(rawText, map[string]string{
+			sqs.ParamActiveAccessKey:   "2",
+			sqs.ParamRetiringAccessKey: "1",
+		}).Resources).To(And(
+			HaveKey(sqs.ResourceAccessKey),
+			HaveKey(sqs.ResourceAccessKey2),
+		))
+		Expect(parseTemplate(rawText, map[string]string{
+			sqs.ParamActiveAccessKey: "2",
+		}).Resources).To(And(
+			Not(HaveKey(sqs.ResourceAccessKey)),
+			HaveKey(sqs.ResourceAccessKey2),
+		))
+	})
+
+	It("should put the active access key in the credentials", func() {
+		var result map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(rawText), &result)).To(Succeed())
+		resources := result["Resources"].(map[interface{}]interface{})
+		resource := resources[sqs.ResourceCredentials].(map[interface{}]interface{})
+		properties := resource["Properties"].(map[interface{}]interface{})
+
+		Expect(properties).To(HaveKeyWithValue("SecretString", HaveKeyWithValue("Fn::If", ConsistOf(
+			"ShouldUseAccessKey2",
+			HaveKeyWithValue("Fn::Sub", ContainSubstring(`"aws_access_key_id":"${IAMAccessKey2}"`)),
+			HaveKeyWithValue("Fn::Sub", ContainSubstring(`"aws_access_key_id":"${IAMAccessKey}"`)),
+		))))
+	})
+
 	It("should have an output for the secretsmanager path to credentials", func() {
 		text, err := builder.Build()
 		Expect(err).ToNot(HaveOccurred())
@@ -235,3 +493,214 @@ var _ = Describe("UserTemplate", func() {
 		))
 	})
 })
+
+var _ = Describe("UserTemplate with a broadcast queue", func() {
+	var builder sqs.UserTemplateBuilder
+	var rawText string
+	var t parsedTemplate
+
+	BeforeEach(func() {
+		builder = sqs.UserTemplateBuilder{
+			BindingID:      "binding-id",
+			ResourcePrefix: "testprefix",
+			TopicARN:       "arn:aws:sns:eu-west-2:123456789012:testprefix-instance-id",
+			Tags:           map[string]string{"Name": "binding-id"},
+			BroadcastQueue: &sqs.BroadcastQueue{
+				QueueName: "testprefix-binding-id",
+				Params: sqs.QueueParams{
+					VisibilityTimeout: aws.Int(60),
+				},
+			},
+		}
+	})
+
+	// goformation's Queue rejects SqsManagedSseEnabled, so the template is
+	// not parsed into goformation resources here
+	JustBeforeEach(func() {
+		var err error
+		rawText, err = builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+		t = parseTemplate(rawText, nil)
+	})
+
+	It("creates a queue for the binding with the instance's settings", func() {
+		queue := t.Queue(sqs.ResourceBindingQueue)
+		Expect(queue.QueueName).To(Equal("testprefix-binding-id"))
+		Expect(queue.FifoQueue).To(BeFalse())
+		Expect(queue.VisibilityTimeout).To(Equal(60))
+		Expect(queue.Tags).To(ContainElement(goformationtags.Tag{Key: "Name", Value: "binding-id"}))
+		Expect(t.Resources[sqs.ResourceBindingQueue].Properties).To(HaveKeyWithValue("SqsManagedSseEnabled", true))
+	})
+
+	It("subscribes the queue to the topic", func() {
+		subscription := t.Resources[sqs.ResourceBindingQueueSubscription]
+		Expect(subscription.Type).To(Equal("AWS::SNS::Subscription"))
+		Expect(subscription.Properties).To(HaveKeyWithValue("TopicArn", builder.TopicARN))
+		Expect(subscription.Properties).To(HaveKeyWithValue("Endpoint", "BindingQueue.Arn"))
+		Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", false))
+		Expect(subscription.Properties).ToNot(HaveKey("FilterPolicy"))
+	})
+
+	It("lets only the topic send to the queue", func() {
+		policy := t.Resources[sqs.ResourceBindingQueuePolicy]
+		Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(And(
+			HaveKeyWithValue("Principal", HaveKeyWithValue("Service", "sns.amazonaws.com")),
+			HaveKeyWithValue("Action", "sqs:SendMessage"),
+			HaveKeyWithValue("Condition", HaveKeyWithValue("ArnEquals", HaveKeyWithValue("aws:SourceArn", builder.TopicARN))),
+		)))))
+	})
+
+	It("grants access to the binding queue", func() {
+		policy := t.Resources[sqs.ResourcePolicy]
+		Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ContainElement(And(
+			HaveKeyWithValue("Resource", ConsistOf("BindingQueue.Arn")),
+			HaveKeyWithValue("Action", ContainElement("sqs:ReceiveMessage")),
+		)))))
+	})
+
+	It("puts the binding queue URL in the credentials", func() {
+		Expect(rawText).To(ContainSubstring(`"primary_queue_url":"${BindingQueue}"`))
+	})
+
+	Context("when the binding sets subscription parameters", func() {
+		BeforeEach(func() {
+			builder.FilterPolicy = json.RawMessage(`{"event": ["order_placed"]}`)
+			builder.RawMessageDelivery = aws.Bool(true)
+		})
+
+		It("configures the subscription", func() {
+			subscription := t.Resources[sqs.ResourceBindingQueueSubscription]
+			Expect(subscription.Properties).To(HaveKeyWithValue("FilterPolicy", HaveKeyWithValue("event", ConsistOf("order_placed"))))
+			Expect(subscription.Properties).To(HaveKeyWithValue("RawMessageDelivery", true))
+		})
+	})
+
+	Context("when the queue policy is hardened", func() {
+		BeforeEach(func() {
+			builder.DenyInsecureTransport = true
+			builder.RestrictPrincipals = true
+			builder.BrokerPrincipalARN = "arn:aws:iam::123456789012:role/sqs-broker"
+		})
+
+		It("denies requests without TLS and from other principals", func() {
+			policy := t.Resources[sqs.ResourceBindingQueuePolicy]
+			Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(
+				HaveKeyWithValue("Condition", HaveKey("ArnEquals")),
+				HaveKeyWithValue("Condition", HaveKey("Bool")),
+				HaveKeyWithValue("Condition", HaveKeyWithValue("ArnNotLike", HaveKeyWithValue("aws:PrincipalArn", ContainElement(HaveSuffix(":user/testprefix/*"))))),
+			))))
+		})
+	})
+
+	Context("when the topic is FIFO and encrypted with KMS", func() {
+		BeforeEach(func() {
+			builder.TopicARN += ".fifo"
+			builder.KMSKeyARN = "arn:aws:kms:eu-west-2:123456789012:key/abcd"
+			builder.BroadcastQueue.QueueName += ".fifo"
+			builder.BroadcastQueue.FIFO = true
+			builder.BroadcastQueue.Params.ContentBasedDeduplication = aws.Bool(true)
+		})
+
+		It("creates a FIFO queue encrypted with the key", func() {
+			queue := t.Queue(sqs.ResourceBindingQueue)
+			Expect(queue.QueueName).To(Equal("testprefix-binding-id.fifo"))
+			Expect(queue.FifoQueue).To(BeTrue())
+			Expect(queue.ContentBasedDeduplication).To(BeTrue())
+			Expect(queue.KmsMasterKeyId).To(Equal(builder.KMSKeyARN))
+		})
+	})
+})
+
+var _ = Describe("UserTemplate for a role binding", func() {
+	var builder sqs.UserTemplateBuilder
+	var rawText string
+
+	BeforeEach(func() {
+		builder = sqs.UserTemplateBuilder{
+			BindingID:             "binding-id",
+			ResourcePrefix:        "testprefix",
+			PrimaryQueueURL:       "https://sqs.eu-west-2.amazonaws.com/123456789012/q-pri",
+			PrimaryQueueARN:       "abc",
+			PermissionsBoundary:   "arn:aws:iam::123456789012:policy/boundary",
+			AdditionalUserPolicy:  "arn:aws:iam::123456789012:policy/vpc-only",
+			Tags:                  map[string]string{"Name": "binding-id"},
+			AccessPolicy:          sqs.AccessPolicyConsumer,
+			BindingType:           sqs.BindingTypeRole,
+			RoleTrustPrincipalARN: "arn:aws:iam::123456789012:role/platform",
+			ExternalID:            "5a1e3c1e-1b7a-4bb0-9d0c-2f8e0a7f6c11",
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		rawText, err = builder.Build()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("creates a role instead of a user with access keys", func() {
+		t, err := goformation.ParseYAML([]byte(rawText))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Parameters).To(BeEmpty())
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceUser))
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceAccessKey))
+		Expect(t.Resources).ToNot(HaveKey(sqs.ResourceAccessKey2))
+
+		role, err := t.GetIAMRoleWithName(sqs.ResourceRole)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(role.RoleName).To(Equal("binding-binding-id"))
+		Expect(role.Path).To(Equal("/testprefix/"))
+		Expect(role.PermissionsBoundary).To(Equal(builder.PermissionsBoundary))
+		Expect(role.ManagedPolicyArns).To(ConsistOf(builder.AdditionalUserPolicy))
+		Expect(role.Tags).To(ConsistOf(goformationtags.Tag{Key: "Name", Value: "binding-id"}))
+	})
+
+	It("lets the trusted principal assume the role with the external ID", func() {
+		t, err := goformation.ParseYAML([]byte(rawText))
+		Expect(err).ToNot(HaveOccurred())
+		role, err := t.GetIAMRoleWithName(sqs.ResourceRole)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(role.AssumeRolePolicyDocument).To(HaveKeyWithValue("Statement", ConsistOf(And(
+			HaveKeyWithValue("Effect", "Allow"),
+			HaveKeyWithValue("Action", "sts:AssumeRole"),
+			HaveKeyWithValue("Principal", HaveKeyWithValue("AWS", builder.RoleTrustPrincipalARN)),
+			HaveKeyWithValue("Condition", HaveKeyWithValue("StringEquals", HaveKeyWithValue("sts:ExternalId", builder.ExternalID))),
+		))))
+	})
+
+	It("grants the role the access policy", func() {
+		t := parseTemplate(rawText, nil)
+		policy := t.Resources[sqs.ResourcePolicy]
+		Expect(policy.Properties).To(HaveKeyWithValue("Roles", ConsistOf(BeNil())))
+		Expect(policy.Properties).ToNot(HaveKey("Users"))
+		Expect(policy.Properties).To(HaveKeyWithValue("PolicyDocument", HaveKeyWithValue("Statement", ConsistOf(And(
+			HaveKeyWithValue("Resource", ConsistOf("abc")),
+			HaveKeyWithValue("Action", ConsistOf(
+				"sqs:ChangeMessageVisibility",
+				"sqs:DeleteMessage",
+				"sqs:GetQueueAttributes",
+				"sqs:GetQueueUrl",
+				"sqs:ListDeadLetterSourceQueues",
+				"sqs:ListQueueTags",
+				"sqs:PurgeQueue",
+				"sqs:ReceiveMessage",
+			)),
+		)))))
+	})
+
+	It("puts the role ARN and external ID in the credentials", func() {
+		Expect(rawText).To(ContainSubstring(`"aws_role_arn":"${IAMRole.Arn}"`))
+		t := parseTemplate(rawText, nil)
+		var credentials map[string]interface{}
+		Expect(json.Unmarshal([]byte(t.Resources[sqs.ResourceCredentials].Properties["SecretString"].(string)), &credentials)).To(Succeed())
+		Expect(credentials).To(HaveKeyWithValue("aws_external_id", builder.ExternalID))
+		Expect(credentials).To(HaveKeyWithValue("primary_queue_url", builder.PrimaryQueueURL))
+		Expect(credentials).ToNot(HaveKey("aws_access_key_id"))
+		Expect(credentials).ToNot(HaveKey("aws_secret_access_key"))
+	})
+
+	It("requires a trusted principal and external ID", func() {
+		builder.RoleTrustPrincipalARN = ""
+		_, err := builder.Build()
+		Expect(err).To(MatchError("role bindings need a trust principal and an external ID"))
+	})
+})
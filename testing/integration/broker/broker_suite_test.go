@@ -62,15 +62,31 @@ var _ = BeforeSuite(func() {
 		Client: struct {
 			*secretsmanager.SecretsManager
 			*cloudformation.CloudFormation
+			sqs.MessageMoveClient
 		}{
-			SecretsManager: secretsmanager.New(sess),
-			CloudFormation: cloudformation.New(sess),
+			SecretsManager:    secretsmanager.New(sess),
+			CloudFormation:    cloudformation.New(sess),
+			MessageMoveClient: sqs.MessageMoveClient{SQS: sqsAdminClient},
 		},
-		Environment:         sqsClientConfig.DeployEnvironment,
-		ResourcePrefix:      sqsClientConfig.ResourcePrefix,
-		PermissionsBoundary: sqsClientConfig.PermissionsBoundary,
-		Timeout:             sqsClientConfig.Timeout,
-		Logger:              logger,
+		Environment:           sqsClientConfig.DeployEnvironment,
+		ResourcePrefix:        sqsClientConfig.ResourcePrefix,
+		PermissionsBoundary:   sqsClientConfig.PermissionsBoundary,
+		AllowedKMSKeyARNs:     sqsClientConfig.AllowedKMSKeyARNs,
+		AllowedSenderAccounts: sqsClientConfig.AllowedSenderAccounts,
+		DenyInsecureTransport: sqsClientConfig.DenyInsecureTransport,
+		RestrictPrincipals:    sqsClientConfig.RestrictPrincipals,
+		BrokerPrincipalARN:    sqsClientConfig.BrokerPrincipalARN,
+		Tags:                  sqsClientConfig.Tags,
+		Plans:                 sqsClientConfig.Plans,
+		Catalog:               config.Catalog.Catalog.Services,
+		Timeout:               sqsClientConfig.Timeout,
+		Logger:                logger,
+	}
+
+	for i, service := range config.Catalog.Catalog.Services {
+		for j, plan := range service.Plans {
+			config.Catalog.Catalog.Services[i].Plans[j].Schemas = sqsProvider.Schemas(plan)
+		}
 	}
 
 	serviceBroker, err := brokerbase.New(config, sqsProvider, logger)
@@ -87,7 +103,7 @@ var _ = BeforeSuite(func() {
 	Expect(provisionerimplemented).To(BeTrue())
 	Expect(updaterimplemented).To(BeTrue())
 
-	brokerAPI := brokerbase.NewAPI(serviceBroker, logger, config)
+	brokerAPI := sqs.LastOperationRecovery(sqs.BindingRotation(brokerbase.NewAPI(sqs.NewBroker(serviceBroker, sqsProvider), logger, config), sqsProvider))
 
 	broker = brokertesting.New(brokerapi.BrokerCredentials{
 		Username: "username",